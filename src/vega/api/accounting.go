@@ -0,0 +1,113 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"time"
+
+	"github.com/htbig/common/src/vega/api/auth"
+	"vega/api/handlers"
+	"github.com/htbig/common/src/vega/api/sessions"
+	"vega/core"
+	"vega/core/aaa/radius"
+	"github.com/htbig/common/src/vega/syslogger"
+)
+
+// sendAccounting sends the records in order in the background when RADIUS
+// accounting is on
+func sendAccounting(config *core.Config, records ...radius.Accounting) {
+	if !config.AAA.RADIUS.Accounting {
+		return
+	}
+
+	cfg := config.AAA.RADIUS.Clone()
+	go func() {
+		for _, record := range records {
+			if errs := cfg.Account(record); len(errs) > 0 {
+				syslogger.Err("API accounting:", errs)
+			}
+		}
+	}()
+}
+
+// sessionAccounting returns the session hook sending a start record when an
+// API session is issued and a stop record when it ends
+func sessionAccounting(config *core.Config) sessions.Hook {
+	return func(s sessions.Session, event string) {
+		record := radius.Accounting{
+			Status:        radius.ACCT_STOP,
+			SessionID:     s.ID,
+			Username:      s.Username,
+			Privileged:    s.Privileged,
+			RemoteAddress: s.Remote,
+		}
+
+		switch event {
+		case sessions.EVENT_START:
+			record.Status = radius.ACCT_START
+		case sessions.EVENT_EXPIRED:
+			record.SessionTime = s.Expires.Sub(s.Issued)
+			record.TerminateCause = radius.TERMINATE_SESSION_TIMEOUT
+		case sessions.EVENT_DISCONNECTED:
+			record.SessionTime = time.Since(s.Issued)
+			record.TerminateCause = radius.TERMINATE_ADMIN_RESET
+		default:
+			// logged out or exchanged for a new token
+			record.SessionTime = time.Since(s.Issued)
+			record.TerminateCause = radius.TERMINATE_USER_REQUEST
+		}
+
+		sendAccounting(config, record)
+	}
+}
+
+// accountChange sends an interim record for a successful configuration change
+// made in a session. A change made without session is a session of its own,
+// started and stopped around it.
+func accountChange(ctx handlers.Context) {
+	if recorder, ok := ctx.Writer.(*Recorder); !ok || recorder.status >= 300 {
+		return
+	}
+
+	identity, ok := auth.IdentityOf(ctx.Request)
+	if !ok {
+		return
+	}
+
+	record := radius.Accounting{
+		Status:     radius.ACCT_INTERIM,
+		Username:   identity.Username,
+		Privileged: identity.Privileged,
+		Command:    ctx.Request.Method + " " + ctx.Request.URL.Path,
+	}
+
+	if host, _, err := net.SplitHostPort(ctx.Request.RemoteAddr); err == nil {
+		record.RemoteAddress = host
+	}
+
+	// changes made with a bearer token belong to its session
+	if token, ok := sessions.BearerToken(ctx.Request.Header.Get("Authorization")); ok {
+		if session, err := apiSessions.Verify(token); err == nil {
+			record.SessionID = session.ID
+			record.SessionTime = time.Since(session.Issued)
+		}
+	}
+
+	if record.SessionID != "" {
+		sendAccounting(ctx.Config, record)
+		return
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	record.SessionID = hex.EncodeToString(id)
+
+	start, stop := record, record
+	start.Status = radius.ACCT_START
+	stop.Status = radius.ACCT_STOP
+	stop.TerminateCause = radius.TERMINATE_USER_REQUEST
+	sendAccounting(ctx.Config, start, stop)
+}
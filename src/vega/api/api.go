@@ -5,19 +5,27 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"flag"
+	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"runtime/debug"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/htbig/common/src/vega/api/audit"
 	"github.com/htbig/common/src/vega/api/auth"
 	"vega/api/handlers"
 	"github.com/htbig/common/src/vega/api/locker"
+	"github.com/htbig/common/src/vega/api/ratelimit"
+	"github.com/htbig/common/src/vega/api/sessions"
 	"github.com/htbig/common/src/vega/api/tasks"
+	"github.com/htbig/common/src/vega/api/transactions"
 	"vega/core"
 	"vega/core/aaa/radius"
+	"vega/core/aaa/trusted"
 	"github.com/htbig/common/src/vega/syslogger"
 
 	"github.com/julienschmidt/httprouter"
@@ -25,6 +33,31 @@ import (
 
 const ContentTypeJSON = "application/json"
 
+var (
+	tasksDir        = flag.String("tasks-dir", "/var/lib/vega/api/tasks", "directory where task records are kept across restarts")
+	tasksMaxRunning = flag.Int("tasks-max-running", 4, "maximum number of tasks running at once, 0 for no limit")
+	tasksMaxKept    = flag.Int("tasks-max-finished", 100, "maximum number of finished tasks kept, 0 for no limit")
+	tasksMaxAge     = flag.Duration("tasks-max-age", 24*time.Hour, "how long finished tasks are kept, 0 for no limit")
+	sessionTTL      = flag.Duration("session-ttl", sessions.DefaultTTL, "lifetime of API session tokens")
+	configRevisions = flag.Int("config-revisions", transactions.DefaultKeep, "number of committed configuration revisions kept")
+	auditLogPath    = flag.String("audit-log", "/var/log/vega/api-audit.log", "file where the configuration changes are recorded")
+	auditLogMaxSize = flag.Int64("audit-log-max-size", audit.DefaultMaxSize, "size in bytes past which the audit log is rotated")
+	auditLogKeep    = flag.Int("audit-log-keep", audit.DefaultKeep, "number of rotated audit logs kept")
+	rateLimits      = ratelimit.Flag("rate-limit", defaultRateLimits, "requests per second and burst of route groups, as group=rate:burst,... with a rate of 0 for no limit")
+)
+
+// defaultRateLimits are the limits per source address and per user of the
+// route groups, logins are slower as they are the ones reaching PAM or RADIUS
+var defaultRateLimits = ratelimit.Limits{
+	ratelimit.DEFAULT: {Rate: 10, Burst: 30},
+	"sessions":        {Rate: 1, Burst: 10},
+}
+
+// apiSessions holds the bearer token sessions accepted by wrapAuth
+var apiSessions *sessions.Manager
+
+const tasksReapInterval = time.Minute
+
 type handlerWrapper func(handlers.Handler) handlers.Handler
 
 type handler struct {
@@ -52,6 +85,13 @@ func (r *Recorder) Write(data []byte) (int, error) {
 	return r.rw.Write(data)
 }
 
+// Flush lets streaming handlers push data through the recorder
+func (r *Recorder) Flush() {
+	if flusher, ok := r.rw.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 func (h *handler) handle(w http.ResponseWriter, r *http.Request, p handlers.Params) {
 	recorder := Recorder{status: http.StatusOK, rw: w}
 	var ctx handlers.Context
@@ -110,15 +150,65 @@ func (c *chain) add(wrappers ...handlerWrapper) {
 	}
 }
 
+// how lockRunning treats the changes of the running configuration
+type lockMode int
+
+const (
+	// refused while a confirmed commit is pending, recorded as revisions
+	lockChange lockMode = iota
+	// let through while a confirmed commit is pending, recorded as revisions
+	lockRecovery
+	// the commits and rollbacks, which make their own revisions
+	lockCommit
+)
+
+// wrapLocker serializes the changes of the running configuration, each one
+// recorded as a revision. They are refused while a confirmed commit waits for
+// its confirmation, as its rollback would undo them.
 func wrapLocker(handler handlers.Handler) handlers.Handler {
+	return lockRunning(handler, lockChange)
+}
+
+// wrapRecoveryLocker is wrapLocker for the routes that get a user back in,
+// lockouts, second factors and keys, which can not wait for a confirmation
+func wrapRecoveryLocker(handler handlers.Handler) handlers.Handler {
+	return lockRunning(handler, lockRecovery)
+}
+
+// wrapCommitLocker is wrapLocker for the commits and rollbacks, which
+// confirm the pending commit
+func wrapCommitLocker(handler handlers.Handler) handlers.Handler {
+	return lockRunning(handler, lockCommit)
+}
+
+func lockRunning(handler handlers.Handler, mode lockMode) handlers.Handler {
 	return func(ctx handlers.Context) {
 		syslogger.Info(ctx.Request.URL)
 		syslogger.Info(ctx.Request.RequestURI)
-		syslogger.Info(ctx.Request)
 
 		if ctx.TryLock() {
 			defer ctx.Lock.Unlock()
+
+			if _, pending := apiTransactions.Pending(); pending && mode == lockChange && ctx.Request.Method != "GET" {
+				ctx.EncodeErrors(http.StatusConflict, transactions.ErrPending)
+				return
+			}
+
+			var before interface{}
+			if ctx.Request.Method != "GET" {
+				before = configSnapshot(ctx.Config)
+			}
+
 			handler(ctx)
+
+			if ctx.Request.Method != "GET" {
+				if mode != lockCommit {
+					recordChange(ctx)
+				}
+				accountChange(ctx)
+				auditChange(ctx, before)
+				reconcileCoA(ctx.Config)
+			}
 		}
 	}
 }
@@ -149,9 +239,7 @@ func wrapLocal(handler handlers.Handler) handlers.Handler {
 		switch {
 		case err != nil:
 			panic(err)
-		case host == "127.0.0.1":
-			fallthrough
-		case host == "::1":
+		case ctx.Config.AAA.Trusted.Lookup(net.ParseIP(host)) == trusted.PRIVILEGED:
 			handler(ctx)
 		default:
 			ctx.Writer.WriteHeader(http.StatusNotFound)
@@ -159,27 +247,56 @@ func wrapLocal(handler handlers.Handler) handlers.Handler {
 	}
 }
 
+// wrapAuth authenticates the request and attaches the caller's identity. With
+// roles enabled checkPrivilege is ignored and every authenticated request goes
+// through wrapRoles instead, so no route wrapped here escapes the roles check.
 func wrapAuth(checkPrivilege bool) func(handlers.Handler) handlers.Handler {
 	return func(handler handlers.Handler) handlers.Handler {
+		handler = wrapRoles(handler)
 		return func(ctx handlers.Context) {
 			var authenticated, authorized bool
+			var identity auth.Identity
 			var errs []error
 
+			// with roles enabled the roles decide what the caller may do
+			roles := ctx.Config.AAA.Roles.Enabled
+
 			request := ctx.Request
 			if host, _, err := net.SplitHostPort(request.RemoteAddr); err == nil {
-				if host == "127.0.0.1" || host == "::1" {
-					// skip authentication for local requests
+				privilege := ctx.Config.AAA.Trusted.Lookup(net.ParseIP(host))
+				if privilege == trusted.PRIVILEGED {
+					// skip authentication for trusted requests
 					authorized, authenticated = true, true
-				} else if !checkPrivilege && strings.HasPrefix(host, "172.17.0.") {
-					// skip authentication for unprivileged container requests
+					identity = auth.Identity{Method: auth.METHOD_TRUSTED, Privileged: true, Trusted: true}
+				} else if !checkPrivilege && privilege == trusted.UNPRIVILEGED {
+					// skip authentication for unprivileged trusted requests
 					authorized, authenticated = true, true
+					identity = auth.Identity{Method: auth.METHOD_TRUSTED, Trusted: true}
+				} else if token, ok := sessions.BearerToken(request.Header.Get("Authorization")); ok {
+					if session, err := apiSessions.Verify(token); err == nil {
+						authenticated = true
+						authorized = roles || !checkPrivilege || session.Privileged
+						identity = auth.Identity{
+							Username:   session.Username,
+							Method:     auth.METHOD_SESSION,
+							Privileged: session.Privileged,
+							Roles:      session.Roles,
+							Session:    session.Login,
+						}
+					}
 				} else {
 					username, password, ok := request.BasicAuth()
 					if ok {
-						radius := ctx.Config.AAA.RADIUS.Enabled
-						fallback := ctx.Config.AAA.RADIUS.Fallback
-
-						authenticated, authorized, errs = auth.AuthenticateAPI(radius, fallback, checkPrivilege, username, password)
+						var result auth.Result
+						result, errs = auth.Authenticate(&ctx.Config.AAA, username, password, request.Header.Get(auth.OTPHeader))
+						authenticated = result.Authenticated
+						authorized = authenticated && (roles || !checkPrivilege || result.Privileged)
+						identity = auth.Identity{
+							Username:   username,
+							Method:     result.Method,
+							Privileged: result.Privileged,
+							Roles:      ctx.Config.AAA.Roles.Resolve(result.Groups, result.RadiusPrivilege, result.TacacsPrivilege),
+						}
 					}
 				}
 			} else {
@@ -188,19 +305,14 @@ func wrapAuth(checkPrivilege bool) func(handlers.Handler) handlers.Handler {
 
 			if authenticated {
 				if authorized {
+					ctx.Request = auth.WithIdentity(request, identity)
 					handler(ctx)
 				} else {
 					ctx.Writer.WriteHeader(http.StatusForbidden)
 				}
 			} else {
 				if len(errs) > 0 {
-					if errs[0].Error() == radius.GatewayTimeoutError {
-						ctx.EncodeErrors(http.StatusGatewayTimeout, errs...)
-					} else if errs[0].Error() == radius.RadiusAuthError {
-						ctx.EncodeErrors(http.StatusBadGateway, errs...)
-					} else {
-						ctx.EncodeInternalServerErrors(errs...)
-					}
+					ctx.EncodeErrors(auth.ErrorStatus(errs), errs...)
 				} else {
 					ctx.Writer.WriteHeader(http.StatusUnauthorized)
 				}
@@ -209,6 +321,30 @@ func wrapAuth(checkPrivilege bool) func(handlers.Handler) handlers.Handler {
 	}
 }
 
+// wrapRoles checks the roles of the identity set by wrapAuth against the
+// requested method and path. It lets everything through while roles are
+// disabled and for trusted networks.
+func wrapRoles(handler handlers.Handler) handlers.Handler {
+	return func(ctx handlers.Context) {
+		config := &ctx.Config.AAA.Roles
+		identity, ok := auth.IdentityOf(ctx.Request)
+
+		if !config.Enabled || (ok && identity.Trusted) {
+			handler(ctx)
+			return
+		}
+
+		path := strings.TrimPrefix(ctx.Request.URL.Path, ctx.BasePath)
+		allowed, permission := config.Allowed(identity.Roles, ctx.Request.Method, path)
+		if !allowed {
+			ctx.EncodeErrors(http.StatusForbidden, fmt.Errorf("Missing permission: %s", permission))
+			return
+		}
+
+		handler(ctx)
+	}
+}
+
 //func wrapAuthAdmin(handler handlers.Handler) handlers.Handler {
 //	return func(ctx handlers.Context) {
 //		var authorized, authenticated bool
@@ -322,21 +458,75 @@ func handleNotFound(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNotFound)
 }
 
+// mergeRoutes adds the routes of more to routes
+func mergeRoutes(routes map[string]map[string]handler, more ...map[string]map[string]handler) {
+	for _, m := range more {
+		for method, paths := range m {
+			if routes[method] == nil {
+				routes[method] = make(map[string]handler)
+			}
+			for path, handle := range paths {
+				routes[method][path] = handle
+			}
+		}
+	}
+}
+
 func newChain(ctx handlers.Context) chain {
-	return chain{ctx: ctx}
+	return chain{ctx: ctx, tasks: ctx.Tasks}
+}
+
+func newTaskManager() *tasks.Manager {
+	var manager *tasks.Manager
+
+	store, err := tasks.NewFileStore(*tasksDir)
+	if err == nil {
+		manager, err = tasks.NewPersistentManager(store)
+	}
+
+	if err != nil {
+		syslogger.Err("API task store:", err)
+		manager = tasks.NewManager()
+	}
+
+	manager.SetMaxRunning(*tasksMaxRunning)
+	manager.SetRetention(tasks.Retention{MaxFinished: *tasksMaxKept, MaxAge: *tasksMaxAge})
+	manager.StartReaper(tasksReapInterval)
+	return manager
+}
+
+func newSessionManager() *sessions.Manager {
+	manager, err := sessions.New(*sessionTTL)
+	if err != nil {
+		// no key, no sessions
+		panic(err)
+	}
+
+	return manager
 }
 
 func router() (http.Handler, handlers.Context) {
 	ctx := handlers.Context{
 		Lock:     locker.New(),
+		Tasks:    newTaskManager(),
 		BasePath: "",
 		Config:   core.NewConfig(),
 	}
 
+	apiSessions = newSessionManager()
+	apiSessions.SetHook(discardCandidate(sessionAccounting(ctx.Config)))
+	apiRateLimits = ratelimit.NewRegistry(rateLimits)
+	apiAudit = newAuditLog()
+
 	r := httprouter.New()
 	ctx.Config.LoadStartup() // ignore error here
 	cfg_factory := core.NewConfig()
 	ctx.Config.Save(*cfg_factory)
+	reconcileCoA(ctx.Config)
+	apiTransactions = newTransactionManager(ctx)
+	radius.StartProber(func() *radius.Config {
+		return ctx.Config.AAA.RADIUS.Clone()
+	})
 
 	// local routes
 	localRouting := localRoutes(ctx)
@@ -349,6 +539,7 @@ func router() (http.Handler, handlers.Context) {
 	// public routes
 	endpoints := make(map[string][]string)
 	publicRouting := publicRoutes(ctx)
+	mergeRoutes(publicRouting, taskRoutes(ctx), sessionRoutes(ctx), ldapRoutes(ctx), radiusRoutes(ctx), localusersRoutes(ctx), rateLimitRoutes(ctx), auditRoutes(ctx), configRoutes(ctx))
 	for method, paths := range publicRouting {
 		for path, handle := range paths {
 			endpoints[method] = append(endpoints[method], path)
@@ -367,7 +558,7 @@ func router() (http.Handler, handlers.Context) {
 		ctx.Writer.Header().Set("Content-Type", ContentTypeJSON)
 		json.NewEncoder(ctx.Writer).Encode(endpoints)
 	}
-	r.GET(ctx.BasePath+"/endpoints", wrapRouter(handler{ctx, wrapAuth(true)(root)}))
+	r.GET(ctx.BasePath+"/endpoints", wrapRouter(handler{ctx, wrapRateLimit("endpoints")(wrapAuth(true)(wrapUserRateLimit("endpoints")(root)))}))
 
 	// endpoint for ping
 	ping := func(ctx handlers.Context) {
@@ -0,0 +1,21 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+package main
+
+import (
+	"vega/api/handlers"
+	"github.com/htbig/common/src/vega/api/handlers/audit"
+)
+
+func auditRoutes(ctx handlers.Context) map[string]map[string]handler {
+	admin := newChain(ctx)
+	admin.add(wrapRateLimit("audit"), wrapAuth(true), wrapUserRateLimit("audit"))
+
+	r := map[string]map[string]handler{
+		"GET": {
+			"/system/audit": admin.wrap(audit.Get(apiAudit)),
+		},
+	}
+
+	return r
+}
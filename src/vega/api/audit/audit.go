@@ -0,0 +1,190 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+// Package audit records the configuration changes made through the API
+package audit
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Operations of a Change, as in JSON Patch
+const (
+	OP_ADD     = "add"
+	OP_REMOVE  = "remove"
+	OP_REPLACE = "replace"
+)
+
+// values of the fields holding secrets are not recorded
+const redacted = "********"
+
+// secret fields are named so or end with "_" and so, like bind_password
+var secretFields = []string{"password", "secret"}
+
+// Record is a configuration change
+type Record struct {
+	Time time.Time `json:"time"`
+	// user that made the change, "" for trusted networks
+	Username string `json:"username"`
+	// authentication method of the user
+	AuthMethod string `json:"auth_method"`
+	Source     string `json:"source"`
+	Method     string `json:"method"`
+	Endpoint   string `json:"endpoint"`
+	Status     int    `json:"status"`
+	// what the change was, for the changes made by the API itself
+	Comment string   `json:"comment,omitempty"`
+	Changes []Change `json:"changes"`
+}
+
+// Change is a difference between the configuration before and after, at a
+// JSON pointer
+type Change struct {
+	Op     string      `json:"op"`
+	Path   string      `json:"path"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// Diff returns the changes from before to after, values decoded from JSON.
+// Objects are compared by field and arrays by index; the values of the
+// fields holding secrets are redacted.
+func Diff(before, after interface{}) []Change {
+	changes := []Change{}
+	diff("", before, after, &changes)
+
+	for idx := range changes {
+		if secretPath(changes[idx].Path) {
+			changes[idx].Before = redactValue(changes[idx].Before)
+			changes[idx].After = redactValue(changes[idx].After)
+		} else {
+			changes[idx].Before = redact(changes[idx].Before)
+			changes[idx].After = redact(changes[idx].After)
+		}
+	}
+
+	return changes
+}
+
+func diff(path string, before, after interface{}, changes *[]Change) {
+	switch b := before.(type) {
+	case map[string]interface{}:
+		a, ok := after.(map[string]interface{})
+		if !ok {
+			break
+		}
+
+		keys := []string{}
+		for key := range b {
+			keys = append(keys, key)
+		}
+		for key := range a {
+			if _, ok := b[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			diffField(path+"/"+escape(key), b, a, key, changes)
+		}
+		return
+	case []interface{}:
+		a, ok := after.([]interface{})
+		if !ok {
+			break
+		}
+
+		for idx := 0; idx < len(b) || idx < len(a); idx++ {
+			field := path + "/" + strconv.Itoa(idx)
+			switch {
+			case idx >= len(a):
+				*changes = append(*changes, Change{Op: OP_REMOVE, Path: field, Before: b[idx]})
+			case idx >= len(b):
+				*changes = append(*changes, Change{Op: OP_ADD, Path: field, After: a[idx]})
+			default:
+				diff(field, b[idx], a[idx], changes)
+			}
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(before, after) {
+		*changes = append(*changes, Change{Op: OP_REPLACE, Path: path, Before: before, After: after})
+	}
+}
+
+func diffField(path string, before, after map[string]interface{}, key string, changes *[]Change) {
+	b, inBefore := before[key]
+	a, inAfter := after[key]
+
+	switch {
+	case !inAfter:
+		*changes = append(*changes, Change{Op: OP_REMOVE, Path: path, Before: b})
+	case !inBefore:
+		*changes = append(*changes, Change{Op: OP_ADD, Path: path, After: a})
+	default:
+		diff(path, b, a, changes)
+	}
+}
+
+// escape escapes a JSON pointer token
+func escape(token string) string {
+	return strings.Replace(strings.Replace(token, "~", "~0", -1), "/", "~1", -1)
+}
+
+func secretField(name string) bool {
+	name = strings.ToLower(name)
+	for _, field := range secretFields {
+		if name == field || strings.HasSuffix(name, "_"+field) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func secretPath(path string) bool {
+	for _, token := range strings.Split(path, "/") {
+		if secretField(token) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func redactValue(value interface{}) interface{} {
+	if value == nil || value == "" {
+		return value
+	}
+
+	return redacted
+}
+
+// redact returns value with the secret fields of its objects redacted
+func redact(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		object := make(map[string]interface{}, len(v))
+		for key, field := range v {
+			if secretField(key) {
+				object[key] = redactValue(field)
+			} else {
+				object[key] = redact(field)
+			}
+		}
+		return object
+	case []interface{}:
+		array := make([]interface{}, len(v))
+		for idx, item := range v {
+			array[idx] = redact(item)
+		}
+		return array
+	}
+
+	return value
+}
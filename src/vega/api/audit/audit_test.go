@@ -0,0 +1,198 @@
+package audit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func decode(t *testing.T, data string) interface{} {
+	var value interface{}
+	if err := json.Unmarshal([]byte(data), &value); err != nil {
+		t.Fatal(err)
+	}
+
+	return value
+}
+
+func TestDiff(t *testing.T) {
+	before := decode(t, `{"hostname": "vega", "ldap": {"bind_password": "old", "password_policy": {"min_length": 8}},
+		"servers": [{"host": "a", "secret": "s1"}], "dns": ["1.1.1.1"]}`)
+	after := decode(t, `{"hostname": "vega2", "ldap": {"bind_password": "new", "password_policy": {"min_length": 10}},
+		"servers": [{"host": "a", "secret": "s1"}, {"host": "b", "secret": "s2"}], "ntp": "pool"}`)
+
+	t.Log("[case] Test changes")
+	changes := Diff(before, after)
+	expected := map[string]Change{
+		"/dns":                             {Op: OP_REMOVE, Before: []interface{}{"1.1.1.1"}},
+		"/hostname":                        {Op: OP_REPLACE, Before: "vega", After: "vega2"},
+		"/ldap/bind_password":              {Op: OP_REPLACE, Before: redacted, After: redacted},
+		"/ldap/password_policy/min_length": {Op: OP_REPLACE, Before: 8.0, After: 10.0},
+		"/ntp":                             {Op: OP_ADD, After: "pool"},
+		"/servers/1":                       {Op: OP_ADD, After: map[string]interface{}{"host": "b", "secret": redacted}},
+	}
+	if len(changes) != len(expected) {
+		t.Error("[err] changes:", changes)
+	}
+	for _, change := range changes {
+		want, ok := expected[change.Path]
+		want.Path = change.Path
+		data, _ := json.Marshal(change)
+		wantData, _ := json.Marshal(want)
+		if !ok || string(data) != string(wantData) {
+			t.Error("[err] change:", string(data), "expected", string(wantData))
+		}
+	}
+
+	t.Log("[case] Test no change")
+	if changes := Diff(before, before); len(changes) != 0 {
+		t.Error("[err] changes of the same configuration:", changes)
+	}
+
+	t.Log("[case] Test escaped path")
+	changes = Diff(decode(t, `{"a/b~c": 1}`), decode(t, `{"a/b~c": 2}`))
+	if len(changes) != 1 || changes[0].Path != "/a~1b~0c" {
+		t.Error("[err] escaped changes:", changes)
+	}
+}
+
+func TestLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "log", "api-audit.log")
+	l, err := Open(path, 300, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	users := []string{"admin", "operator"}
+	for i := 0; i < 10; i++ {
+		record := Record{
+			Time:     start.Add(time.Duration(i) * time.Hour),
+			Username: users[i%2],
+			Method:   "PUT",
+			Endpoint: "/system",
+			Status:   200,
+			Changes:  []Change{},
+		}
+		if err := l.Append(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Log("[case] Test rotation")
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Error("[err] log not rotated:", err)
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("[err] too many rotated logs kept:", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Size() > 300 || info.Mode().Perm() != 0600 {
+		t.Error("[err] log:", info, err)
+	}
+
+	t.Log("[case] Test query")
+	records, err := l.Query(Filter{})
+	if err != nil || len(records) == 0 || len(records) > 10 {
+		t.Fatal("[err] query:", len(records), err)
+	}
+	last := records[len(records)-1]
+	if !last.Time.Equal(start.Add(9 * time.Hour)) {
+		t.Error("[err] last record:", last)
+	}
+	for i := 1; i < len(records); i++ {
+		if !records[i-1].Time.Before(records[i].Time) {
+			t.Error("[err] records not in order:", records)
+		}
+	}
+
+	t.Log("[case] Test filters")
+	records, err = l.Query(Filter{
+		Since:    start.Add(6 * time.Hour),
+		Until:    start.Add(8 * time.Hour),
+		Username: "admin",
+	})
+	if err != nil || len(records) != 2 || !records[0].Time.Equal(start.Add(6*time.Hour)) {
+		t.Error("[err] filtered records:", records, err)
+	}
+
+	records, err = l.Query(Filter{Limit: 3})
+	if err != nil || len(records) != 3 || !records[2].Time.Equal(start.Add(9*time.Hour)) {
+		t.Error("[err] limited records:", records, err)
+	}
+}
+
+func TestLogLimits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "api-audit.log")
+	l, err := Open(path, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	t.Log("[case] Test record too large")
+	large := strings.Repeat("x", maxRecordSize)
+	record := Record{
+		Method:  "PUT",
+		Changes: []Change{{Op: "replace", Path: "/system/banner", Before: "", After: large}},
+	}
+	if err := l.Append(record); err != nil {
+		t.Error("[err] append:", err)
+	}
+	records, err := l.Query(Filter{})
+	if err != nil || len(records) != 1 || records[0].Comment != valuesDropped ||
+		len(records[0].Changes) != 1 || records[0].Changes[0].After != nil {
+		t.Error("[err] cut record:", err)
+	}
+
+	t.Log("[case] Test line too long")
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"comment":"` + large + "\"}\n")
+	file.Close()
+	if err := l.Append(Record{Method: "POST"}); err != nil {
+		t.Error("[err] append:", err)
+	}
+	records, err = l.Query(Filter{})
+	if err != nil || len(records) != 2 || records[1].Method != "POST" {
+		t.Error("[err] records around the long line:", len(records), err)
+	}
+
+	t.Log("[case] Test rotation failed")
+	l.maxSize = 1
+	if err := os.MkdirAll(filepath.Join(path+".1", "busy"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Append(Record{Method: "DELETE"}); err == nil {
+		t.Error("[err] rotation did not fail")
+	}
+	if err := os.RemoveAll(path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Append(Record{Method: "PATCH"}); err != nil {
+		t.Error("[err] append after the failed rotation:", err)
+	}
+	records, err = l.Query(Filter{})
+	if err != nil || len(records) != 4 || records[3].Method != "PATCH" {
+		t.Error("[err] records after the failed rotation:", len(records), err)
+	}
+}
@@ -0,0 +1,285 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMaxSize = 10 * 1024 * 1024
+	DefaultKeep    = 5
+
+	// longest record written and read, newline included
+	maxRecordSize = 4 * 1024 * 1024
+
+	// comments of the records cut down to maxRecordSize
+	valuesDropped  = "values of the changes left out, too large"
+	changesDropped = "changes left out, too large"
+)
+
+// Filter selects records, the zero values select all
+type Filter struct {
+	Since    time.Time
+	Until    time.Time
+	Username string
+	// at most the last Limit records
+	Limit int
+}
+
+func (f Filter) match(record Record) bool {
+	if !f.Since.IsZero() && record.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && record.Time.After(f.Until) {
+		return false
+	}
+	if f.Username != "" && record.Username != f.Username {
+		return false
+	}
+
+	return true
+}
+
+// Log is a file of records, one JSON object per line. The file is only
+// appended to; once larger than its maximum size it is rotated to path.1,
+// path.1 to path.2 and so on, keeping that many rotated files.
+type Log struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	keep    int
+	file    *os.File
+	size    int64
+}
+
+// Open opens the log at path, creating it and its directory if needed
+func Open(path string, maxSize int64, keep int) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, err
+	}
+
+	l := &Log{path: path, maxSize: maxSize, keep: keep}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (l *Log) open() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	l.file, l.size = file, info.Size()
+	return nil
+}
+
+// Append adds a record to the log. A record larger than maxRecordSize loses
+// the values of its changes, then its changes, as told by its comment.
+func (l *Log) Append(record Record) error {
+	data, err := marshal(record)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		// a failed rotation left the log closed
+		if err = l.open(); err != nil {
+			return err
+		}
+	}
+
+	var rotateErr error
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(data)) > l.maxSize {
+		if rotateErr = l.rotate(); l.file == nil {
+			return rotateErr
+		}
+	}
+
+	n, err := l.file.Write(data)
+	l.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	if err = l.file.Sync(); err != nil {
+		return err
+	}
+
+	return rotateErr
+}
+
+// marshal returns the line of record, cut down to maxRecordSize
+func marshal(record Record) ([]byte, error) {
+	for cut := 0; ; cut++ {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, '\n')
+
+		if len(data) <= maxRecordSize {
+			return data, nil
+		}
+
+		switch cut {
+		case 0:
+			changes := make([]Change, len(record.Changes))
+			for idx, change := range record.Changes {
+				changes[idx] = Change{Op: change.Op, Path: change.Path}
+			}
+			record.Changes = changes
+			record.Comment = note(record.Comment, valuesDropped)
+		case 1:
+			record.Changes = nil
+			record.Comment = note(record.Comment, changesDropped)
+		default:
+			return nil, fmt.Errorf("Audit record larger than %d bytes", maxRecordSize)
+		}
+	}
+}
+
+func note(comment, text string) string {
+	if comment == "" {
+		return text
+	}
+
+	return comment + ", " + text
+}
+
+// rotate rotates the files and opens a new one. The log goes on in the file
+// at path even if the rotation failed; it is closed only if that can not be
+// opened.
+func (l *Log) rotate() error {
+	err := l.file.Close()
+	l.file = nil
+	if err == nil {
+		err = l.shift()
+	}
+
+	if openErr := l.open(); openErr != nil {
+		return openErr
+	}
+
+	return err
+}
+
+// shift renames the files, path to path.1 and so on
+func (l *Log) shift() error {
+	os.Remove(l.rotated(l.keep))
+	for n := l.keep - 1; n >= 1; n-- {
+		if err := os.Rename(l.rotated(n), l.rotated(n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if l.keep > 0 {
+		if err := os.Rename(l.path, l.rotated(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(l.path); err != nil {
+		return err
+	}
+
+	return l.open()
+}
+
+func (l *Log) rotated(n int) string {
+	return fmt.Sprintf("%s.%d", l.path, n)
+}
+
+// Query returns the records matching filter, oldest first
+func (l *Log) Query(filter Filter) ([]Record, error) {
+	// rotation renames the files, it must not happen while they are read
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	records := []Record{}
+	for n := l.keep; n >= 0; n-- {
+		path := l.path
+		if n > 0 {
+			path = l.rotated(n)
+		}
+
+		file, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		reader := bufio.NewReader(file)
+		for {
+			line, err := readLine(reader)
+			var record Record
+			if line != nil && json.Unmarshal(line, &record) == nil && filter.match(record) {
+				records = append(records, record)
+			}
+
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				file.Close()
+				return nil, err
+			}
+		}
+
+		file.Close()
+	}
+
+	if filter.Limit > 0 && len(records) > filter.Limit {
+		records = records[len(records)-filter.Limit:]
+	}
+
+	return records, nil
+}
+
+// readLine returns the next line of reader, nil for a line longer than
+// maxRecordSize that is skipped without being kept in memory
+func readLine(reader *bufio.Reader) ([]byte, error) {
+	var line []byte
+	tooLong := false
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if !tooLong && len(line)+len(chunk) <= maxRecordSize {
+			line = append(line, chunk...)
+		} else {
+			tooLong, line = true, nil
+		}
+
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+	}
+}
+
+// Close closes the log file
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+
+	return l.file.Close()
+}
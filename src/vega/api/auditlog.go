@@ -0,0 +1,92 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"time"
+
+	"github.com/htbig/common/src/vega/api/audit"
+	"github.com/htbig/common/src/vega/api/auth"
+	"github.com/htbig/common/src/vega/api/transactions"
+	"vega/api/handlers"
+	"vega/core"
+	"github.com/htbig/common/src/vega/syslogger"
+)
+
+// apiAudit records the configuration changes, nil when it could not be
+// opened
+var apiAudit *audit.Log
+
+func newAuditLog() *audit.Log {
+	log, err := audit.Open(*auditLogPath, *auditLogMaxSize, *auditLogKeep)
+	if err != nil {
+		syslogger.Err("API audit log:", err)
+		return nil
+	}
+
+	return log
+}
+
+// configSnapshot returns the configuration as decoded from JSON, for
+// audit.Diff
+func configSnapshot(config *core.Config) interface{} {
+	var snapshot interface{}
+
+	data, err := json.Marshal(config.Map())
+	if err == nil {
+		err = json.Unmarshal(data, &snapshot)
+	}
+	if err != nil {
+		syslogger.Err("API audit snapshot:", err)
+	}
+
+	return snapshot
+}
+
+// auditChange records a successful configuration change, before is the
+// configuration snapshot taken before the handler
+func auditChange(ctx handlers.Context, before interface{}) {
+	recorder, ok := ctx.Writer.(*Recorder)
+	if apiAudit == nil || !ok || recorder.status >= 300 {
+		return
+	}
+
+	identity, _ := auth.IdentityOf(ctx.Request)
+	record := audit.Record{
+		Time:       time.Now().UTC(),
+		Username:   identity.Username,
+		AuthMethod: identity.Method,
+		Method:     ctx.Request.Method,
+		Endpoint:   ctx.Request.URL.Path,
+		Status:     recorder.status,
+		Changes:    audit.Diff(before, configSnapshot(ctx.Config)),
+	}
+
+	if host, _, err := net.SplitHostPort(ctx.Request.RemoteAddr); err == nil {
+		record.Source = host
+	}
+
+	appendAudit(record)
+}
+
+// auditRevision records a revision the API committed by itself, before is the
+// snapshot of the configuration it replaced
+func auditRevision(config *core.Config, r transactions.Revision, before interface{}) {
+	if apiAudit == nil {
+		return
+	}
+
+	appendAudit(audit.Record{
+		Time:    r.Time,
+		Comment: r.Comment,
+		Changes: audit.Diff(before, configSnapshot(config)),
+	})
+}
+
+func appendAudit(record audit.Record) {
+	if err := apiAudit.Append(record); err != nil {
+		syslogger.Err("API audit log:", err)
+	}
+}
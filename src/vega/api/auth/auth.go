@@ -4,10 +4,16 @@ package auth
 
 import (
 	"errors"
+	"net/http"
 	"os/exec"
 	"strings"
 
+	"vega/core/aaa"
+	"vega/core/aaa/ldap"
+	"vega/core/aaa/localusers"
 	"vega/core/aaa/radius"
+	"vega/core/aaa/tacacs"
+	"github.com/htbig/common/src/vega/syslogger"
 
 	"github.com/msteinert/pam"
 )
@@ -116,7 +122,88 @@ import (
 //	}
 //}
 
-func authenticatePAM(checkPrivilege bool, username, password string) (bool, bool, []error) {
+const (
+	METHOD_RADIUS  = aaa.METHOD_RADIUS
+	METHOD_TACACS  = aaa.METHOD_TACACS
+	METHOD_LDAP    = aaa.METHOD_LDAP
+	METHOD_LOCAL   = aaa.METHOD_LOCAL
+	METHOD_SESSION = "session"
+	METHOD_TRUSTED = "trusted"
+)
+
+const (
+	AccountLockedError      = "Account locked after too many failed logins"
+	PasswordExpiredError    = "Password expired"
+	SecondFactorError       = "Second factor required"
+	SecondFactorEnrollError = "Second factor required but not enrolled"
+)
+
+// OTPHeader carries the second factor of a local user, a TOTP code or a
+// recovery code. Without it a TOTP code may be appended to the password.
+const OTPHeader = "X-Vega-OTP"
+
+// Result is the outcome of a credentials check
+type Result struct {
+	Authenticated bool
+	Privileged    bool
+	// method that answered
+	Method string
+	// local groups of the user, or LDAP group DNs
+	Groups []string
+	// RADIUS privilege, -1 when not authenticated by RADIUS
+	RadiusPrivilege int
+	// RADIUS attribute mapping that gave the privilege, "" for none
+	RadiusRule string
+	// TACACS+ priv-lvl, -1 when not authenticated by TACACS+
+	TacacsPrivilege int
+}
+
+// Groups returns the local groups of a user
+func Groups(username string) ([]string, error) {
+	bytes, err := exec.Command("groups", username).Output()
+	if err != nil {
+		return nil, err
+	}
+
+	// output is "username : group1 group2"
+	fields := strings.Fields(string(bytes))
+	for i, field := range fields {
+		if field == ":" {
+			return fields[i+1:], nil
+		}
+	}
+
+	return fields, nil
+}
+
+// splitOTP splits the 6 digits of a TOTP code appended to password
+func splitOTP(password string) (string, string) {
+	length := len(password) - 6
+	if length < 0 {
+		return password, ""
+	}
+
+	for _, c := range password[length:] {
+		if c < '0' || c > '9' {
+			return password, ""
+		}
+	}
+
+	return password[:length], password[length:]
+}
+
+func authenticatePAM(policy *localusers.Policy, username, password, otp string) (Result, []error) {
+	result := Result{Method: METHOD_LOCAL, RadiusPrivilege: -1, TacacsPrivilege: -1}
+
+	twoFactor, err := localusers.TwoFactorOf(username)
+	if err != nil {
+		return result, []error{err}
+	}
+
+	if twoFactor.Enrolled && otp == "" {
+		password, otp = splitOTP(password)
+	}
+
 	const serviceName = "login"
 	transaction, err := pam.StartFunc(
 		serviceName,
@@ -133,66 +220,197 @@ func authenticatePAM(checkPrivilege bool, username, password string) (bool, bool
 	)
 
 	if err != nil {
-		return false, false, []error{err}
+		return result, []error{err}
 	}
 
 	if err = transaction.Authenticate(pam.Flags(0)); err != nil {
-		return false, false, nil
+		return result, nil
 	}
 
-	if !checkPrivilege {
-		return true, true, nil
+	if policy.Expired(username) {
+		return result, []error{errors.New(PasswordExpiredError)}
 	}
 
-	//
-	// check current user against privilege group
-	const privilegedGroup = "wheel"
+	if twoFactor.Needed() {
+		if !twoFactor.Enrolled {
+			return result, []error{errors.New(SecondFactorEnrollError)}
+		} else if otp == "" {
+			return result, []error{errors.New(SecondFactorError)}
+		}
 
-	bytes, err := exec.Command("groups", username).Output()
+		ok, err := localusers.VerifySecondFactor(username, otp)
+		if err != nil {
+			return result, []error{err}
+		} else if !ok {
+			return result, nil
+		}
+	}
+
+	groups, err := Groups(username)
 	if err != nil {
 		// fail to check groups
-		return false, false, []error{err}
+		return result, []error{err}
 	}
 
-	groups := strings.Fields(string(bytes))
+	//
+	// check current user against privilege group
+	const privilegedGroup = "wheel"
+
+	result.Authenticated = true
+	result.Groups = groups
 	for _, group := range groups {
 		if group == privilegedGroup {
 			// in privileged group
-			return true, true, nil
+			result.Privileged = true
 		}
 	}
 
-	// not in privileged group
-	return true, false, nil
+	return result, nil
 }
 
-func authenticateRADIUS(checkPrivilege bool, username, password string) (bool, bool, []error) {
-	// reverse returned values
-	privileged, ok, errs := radius.RadiusAuthenticate(username, password) // authorized, authenticated, errors
+func authenticateRADIUS(config *radius.Config, username, password string) (Result, []error) {
+	result := Result{Method: METHOD_RADIUS, RadiusPrivilege: -1, TacacsPrivilege: -1}
 
+	privilege, rule, ok, errs := config.Authenticate(username, password)
 	if len(errs) > 0 {
-		return false, false, errs
-	} else {
-		if checkPrivilege {
-			return ok, privileged, nil
-		} else {
-			return ok, true, nil
+		return result, errs
+	}
+
+	result.Authenticated = ok
+	if ok {
+		result.RadiusPrivilege = privilege
+		result.Privileged = privilege == radius.PRIVILEGE_ADMIN
+		if rule != nil {
+			result.RadiusRule = rule.String()
+			syslogger.Info("Radius:", username, "given privilege", privilege, "by", result.RadiusRule)
 		}
 	}
+
+	return result, nil
+}
+
+func authenticateTACACS(config *tacacs.Config, username, password string) (Result, []error) {
+	result := Result{Method: METHOD_TACACS, RadiusPrivilege: -1, TacacsPrivilege: -1}
+
+	privilege, ok, errs := config.Authenticate(username, password)
+	if len(errs) > 0 {
+		return result, errs
+	}
+
+	result.Authenticated = ok
+	if ok {
+		result.TacacsPrivilege = privilege
+		result.Privileged = privilege >= tacacs.PRIVILEGE_ADMIN
+	}
+
+	return result, nil
+}
+
+func authenticateLDAP(config *ldap.Config, username, password string) (Result, []error) {
+	result := Result{Method: METHOD_LDAP, RadiusPrivilege: -1, TacacsPrivilege: -1}
+
+	privileged, groups, ok, errs := config.Authenticate(username, password)
+	if len(errs) > 0 {
+		return result, errs
+	}
+
+	result.Authenticated = ok
+	result.Privileged = privileged
+	result.Groups = groups
+
+	return result, nil
+}
+
+// Authenticate checks credentials with the method list of config, skipping
+// the disabled methods. Each method hands over to the next one as its
+// continue condition says; Result.Method names the method that answered.
+// When no method answered, the errors are those of the last one tried.
+// A username locked out by the password policy is rejected before any
+// method is tried. otp is the second factor of the local users, "" for none.
+func Authenticate(config *aaa.Config, username, password, otp string) (Result, []error) {
+	if Locked(username) {
+		return Result{RadiusPrivilege: -1, TacacsPrivilege: -1}, []error{errors.New(AccountLockedError)}
+	}
+
+	result, errs := authenticate(config, username, password, otp)
+
+	// only a rejection counts, not a server that could not answer
+	if result.Authenticated || len(errs) == 0 {
+		recordLogin(&config.PasswordPolicy, username, result.Authenticated)
+	}
+
+	return result, errs
 }
 
-func AuthenticateAPI(radius, fallback, checkPrivilege bool, username, password string) (bool, bool, []error) {
-	var authenticated, authorized bool
+func authenticate(config *aaa.Config, username, password, otp string) (Result, []error) {
+	result := Result{RadiusPrivilege: -1, TacacsPrivilege: -1}
 	var errs []error = []error{}
 
-	if radius {
-		authenticated, authorized, errs = authenticateRADIUS(checkPrivilege, username, password)
+	for _, method := range config.MethodList() {
+		if !config.MethodEnabled(method.Name) {
+			continue
+		}
+
+		switch method.Name {
+		case METHOD_RADIUS:
+			result, errs = authenticateRADIUS(&config.RADIUS, username, password)
+		case METHOD_TACACS:
+			result, errs = authenticateTACACS(&config.TACACS, username, password)
+		case METHOD_LDAP:
+			result, errs = authenticateLDAP(&config.LDAP, username, password)
+		case METHOD_LOCAL:
+			result, errs = authenticatePAM(&config.PasswordPolicy, username, password, otp)
+		}
+
+		if !method.Next(result.Authenticated, errs) || decided(errs) {
+			return result, errs
+		}
+	}
+
+	return result, errs
+}
+
+// decided tells whether the errors of a method are its answer rather than a
+// failure to answer: the password was right but the user may not log in yet,
+// no other method is tried then
+func decided(errs []error) bool {
+	for _, err := range errs {
+		switch err.Error() {
+		case PasswordExpiredError, SecondFactorError, SecondFactorEnrollError:
+			return true
+		}
 	}
 
-	if !radius || (fallback && !authenticated) {
-		authenticated, authorized, errs = authenticatePAM(checkPrivilege, username, password)
+	return false
+}
+
+// AuthenticateAPI is Authenticate for a route, authorized tells whether the
+// user may access a route that checks privilege. It also returns the method
+// that answered.
+func AuthenticateAPI(config *aaa.Config, checkPrivilege bool, username, password, otp string) (authenticated, authorized bool, method string, errs []error) {
+	result, errs := Authenticate(config, username, password, otp)
 
+	authorized = result.Authenticated && (!checkPrivilege || result.Privileged)
+	return result.Authenticated, authorized, result.Method, errs
+}
+
+// ErrorStatus returns the HTTP status matching the errors of a failed
+// authentication
+func ErrorStatus(errs []error) int {
+	if len(errs) == 0 {
+		return http.StatusUnauthorized
 	}
 
-	return authenticated, authorized, errs
+	switch errs[0].Error() {
+	case SecondFactorError:
+		return http.StatusUnauthorized
+	case AccountLockedError, PasswordExpiredError, SecondFactorEnrollError:
+		return http.StatusForbidden
+	case radius.GatewayTimeoutError:
+		return http.StatusGatewayTimeout
+	case radius.RadiusAuthError, tacacs.TacacsAuthError, ldap.LdapAuthError:
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
 }
@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"vega/core/aaa/radius"
+)
+
+func TestSplitOTP(t *testing.T) {
+	t.Log("[case] Test TOTP code appended to the password")
+	tests := []struct{ input, password, otp string }{
+		{"secret123456", "secret", "123456"},
+		{"123456", "", "123456"},
+		{"secret12345x", "secret12345x", ""},
+		{"12345", "12345", ""},
+	}
+	for _, test := range tests {
+		if password, otp := splitOTP(test.input); password != test.password || otp != test.otp {
+			t.Error("[err] split", test.input, ":", password, otp)
+		}
+	}
+}
+
+func TestDecided(t *testing.T) {
+	t.Log("[case] Test local answers stop the method list")
+	for _, answer := range []string{PasswordExpiredError, SecondFactorError, SecondFactorEnrollError} {
+		if !decided([]error{errors.New(answer)}) {
+			t.Error("[err] not decided:", answer)
+		}
+	}
+
+	t.Log("[case] Test failures to answer go on")
+	if decided(nil) || decided([]error{errors.New(radius.GatewayTimeoutError)}) {
+		t.Error("[err] failure to answer decided")
+	}
+}
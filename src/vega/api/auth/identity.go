@@ -0,0 +1,35 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+package auth
+
+import (
+	"context"
+	"net/http"
+)
+
+// Identity is the authenticated caller of an API request
+type Identity struct {
+	Username   string   `json:"username"`
+	Method     string   `json:"method"`
+	Privileged bool     `json:"privileged"`
+	Roles      []string `json:"roles,omitempty"`
+	// login of the session token, "" for other credentials
+	Session string `json:"-"`
+	// request from a trusted network, no credentials were checked
+	Trusted bool `json:"trusted,omitempty"`
+}
+
+type contextKey int
+
+const identityKey contextKey = 0
+
+// WithIdentity returns a shallow copy of r carrying id
+func WithIdentity(r *http.Request, id Identity) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), identityKey, id))
+}
+
+// IdentityOf returns the identity wrapAuth attached to r
+func IdentityOf(r *http.Request) (Identity, bool) {
+	id, ok := r.Context().Value(identityKey).(Identity)
+	return id, ok
+}
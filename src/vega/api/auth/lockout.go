@@ -0,0 +1,160 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+package auth
+
+import (
+	"sync"
+	"time"
+
+	"vega/core/aaa/localusers"
+	"github.com/htbig/common/src/vega/syslogger"
+)
+
+// Lockout is the failed login record of a username
+type Lockout struct {
+	Username string `json:"username"`
+	// failed logins in a row
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	// zero unless the account got locked
+	LockedUntil time.Time `json:"locked_until"`
+	Locked      bool      `json:"locked"`
+}
+
+const (
+	// most usernames whose failures are kept, rejected logins may name
+	// any username
+	maxFailureRecords = 10000
+	// records expired are dropped at most once per sweepInterval
+	sweepInterval = time.Minute
+)
+
+// failures are kept per username of any method, so a RADIUS or LDAP user
+// is locked the same way as a local one. Failures older than the lockout
+// period are forgotten.
+var failures = struct {
+	sync.Mutex
+	users     map[string]*Lockout
+	lastSweep time.Time
+}{users: make(map[string]*Lockout)}
+
+// locked tells whether username may not log in at now, failures.Mutex must
+// be held
+func locked(username string, now time.Time) bool {
+	record, ok := failures.users[username]
+	return ok && now.Before(record.LockedUntil)
+}
+
+// Locked tells whether username is locked out
+func Locked(username string) bool {
+	failures.Lock()
+	defer failures.Unlock()
+
+	return locked(username, time.Now())
+}
+
+// recordLogin counts a rejected login of username, or forgets its failures
+// once it logged in
+func recordLogin(policy *localusers.Policy, username string, authenticated bool) {
+	failures.Lock()
+	defer failures.Unlock()
+
+	if authenticated || policy.Lockout() == 0 {
+		delete(failures.users, username)
+		return
+	}
+
+	now := time.Now()
+	window := policy.Lockout()
+	sweep(now, window)
+
+	record, ok := failures.users[username]
+	if !ok || expired(record, now, window) {
+		// a lockout that ran out starts the count again
+		if !ok && len(failures.users) >= maxFailureRecords {
+			evictOldest()
+		}
+		record = &Lockout{Username: username}
+		failures.users[username] = record
+	}
+
+	record.Failures++
+	record.LastFailure = now
+
+	if record.Failures >= policy.MaxFailures {
+		record.LockedUntil = now.Add(policy.Lockout())
+		syslogger.Warning("Auth:", username, "locked until", record.LockedUntil, "after", record.Failures, "failed logins")
+	}
+}
+
+// expired tells whether the failures of record are forgotten at now: its
+// lockout ran out, or its last failure is older than window
+func expired(record *Lockout, now time.Time, window time.Duration) bool {
+	if !record.LockedUntil.IsZero() {
+		return !now.Before(record.LockedUntil)
+	}
+
+	return now.Sub(record.LastFailure) >= window
+}
+
+// sweep drops the expired records, failures.Mutex must be held
+func sweep(now time.Time, window time.Duration) {
+	if now.Sub(failures.lastSweep) < sweepInterval {
+		return
+	}
+	failures.lastSweep = now
+
+	for username, record := range failures.users {
+		if expired(record, now, window) {
+			delete(failures.users, username)
+		}
+	}
+}
+
+// evictOldest drops the record with the oldest failure, preferring the ones
+// not locked, failures.Mutex must be held
+func evictOldest() {
+	var oldest *Lockout
+	for _, record := range failures.users {
+		switch {
+		case oldest == nil:
+			oldest = record
+		case record.LockedUntil.IsZero() != oldest.LockedUntil.IsZero():
+			if record.LockedUntil.IsZero() {
+				oldest = record
+			}
+		case record.LastFailure.Before(oldest.LastFailure):
+			oldest = record
+		}
+	}
+
+	if oldest != nil {
+		delete(failures.users, oldest.Username)
+	}
+}
+
+// LockoutOf returns the failed login record of username
+func LockoutOf(username string) Lockout {
+	failures.Lock()
+	defer failures.Unlock()
+
+	record, ok := failures.users[username]
+	if !ok {
+		return Lockout{Username: username}
+	}
+
+	lockout := *record
+	lockout.Locked = locked(username, time.Now())
+	return lockout
+}
+
+// Unlock forgets the failed logins of username, it reports whether there
+// were any
+func Unlock(username string) bool {
+	failures.Lock()
+	defer failures.Unlock()
+
+	_, ok := failures.users[username]
+	delete(failures.users, username)
+	return ok
+}
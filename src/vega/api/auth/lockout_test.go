@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"vega/core/aaa/localusers"
+)
+
+func TestLockout(t *testing.T) {
+	policy := &localusers.Policy{MaxFailures: 3, LockoutSeconds: 60}
+
+	t.Log("[case] Test lock after failed logins")
+	recordLogin(policy, "test", false)
+	recordLogin(policy, "test", false)
+	if Locked("test") {
+		t.Error("[err] locked before the maximum failures")
+	}
+	recordLogin(policy, "test", false)
+	if !Locked("test") || !LockoutOf("test").Locked || LockoutOf("test").Failures != 3 {
+		t.Error("[err] not locked:", LockoutOf("test"))
+	}
+	if Locked("other") {
+		t.Error("[err] other user locked")
+	}
+
+	t.Log("[case] Test unlock")
+	if !Unlock("test") || Locked("test") {
+		t.Error("[err] unlock:", LockoutOf("test"))
+	}
+	if Unlock("test") {
+		t.Error("[err] unlocked a user without failures")
+	}
+
+	t.Log("[case] Test login forgets failures")
+	recordLogin(policy, "test", false)
+	recordLogin(policy, "test", true)
+	if LockoutOf("test").Failures != 0 {
+		t.Error("[err] failures kept:", LockoutOf("test"))
+	}
+
+	t.Log("[case] Test no lockout")
+	recordLogin(&localusers.Policy{}, "test", false)
+	if LockoutOf("test").Failures != 0 {
+		t.Error("[err] failures counted without lockout")
+	}
+}
+
+func TestLockoutExpiry(t *testing.T) {
+	policy := &localusers.Policy{MaxFailures: 3, LockoutSeconds: 60}
+
+	t.Log("[case] Test old failures forgotten")
+	recordLogin(policy, "expiry", false)
+	recordLogin(policy, "expiry", false)
+	failures.Lock()
+	failures.users["expiry"].LastFailure = time.Now().Add(-2 * time.Minute)
+	failures.Unlock()
+	recordLogin(policy, "expiry", false)
+	if Locked("expiry") || LockoutOf("expiry").Failures != 1 {
+		t.Error("[err] old failures counted:", LockoutOf("expiry"))
+	}
+
+	t.Log("[case] Test expired records swept")
+	failures.Lock()
+	failures.users["expiry"].LastFailure = time.Now().Add(-2 * time.Minute)
+	failures.lastSweep = time.Time{}
+	failures.Unlock()
+	recordLogin(policy, "sweeper", false)
+	failures.Lock()
+	_, ok := failures.users["expiry"]
+	failures.Unlock()
+	if ok {
+		t.Error("[err] expired record kept")
+	}
+	Unlock("sweeper")
+
+	t.Log("[case] Test record count capped")
+	for i := 0; i < 3; i++ {
+		recordLogin(policy, "locked", false)
+	}
+	for i := 0; i < maxFailureRecords+10; i++ {
+		recordLogin(policy, "user"+strconv.Itoa(i), false)
+	}
+	failures.Lock()
+	count := len(failures.users)
+	failures.Unlock()
+	if count > maxFailureRecords {
+		t.Error("[err] records over the cap:", count)
+	}
+	if !Locked("locked") {
+		t.Error("[err] locked record evicted")
+	}
+
+	failures.Lock()
+	failures.users = make(map[string]*Lockout)
+	failures.Unlock()
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"vega/core"
+	"vega/core/aaa/radius"
+	"github.com/htbig/common/src/vega/syslogger"
+)
+
+// uids below are system accounts whose sessions a CoA never hangs up
+const firstLoginUID = 1000
+
+// coa is the running RADIUS CoA listener, nil while disabled
+var coa struct {
+	sync.Mutex
+	listener *radius.Listener
+	port     uint16
+}
+
+// reconcileCoA starts, restarts or stops the CoA listener to match config
+func reconcileCoA(config *core.Config) {
+	cfg := config.AAA.RADIUS.Clone()
+
+	coa.Lock()
+	defer coa.Unlock()
+
+	if coa.listener != nil && (!cfg.CoA || cfg.CoAPort != coa.port) {
+		coa.listener.Close()
+		coa.listener = nil
+	}
+
+	if !cfg.CoA || coa.listener != nil {
+		return
+	}
+
+	listener, err := radius.Listen(cfg.CoAPort, func() *radius.Config {
+		return config.AAA.RADIUS.Clone()
+	}, applyChange(config))
+	if err != nil {
+		syslogger.Err("API CoA:", err)
+		return
+	}
+
+	coa.listener = listener
+	coa.port = cfg.CoAPort
+}
+
+// applyChange returns the handler applying CoA and Disconnect requests to API
+// sessions and shell logins
+func applyChange(config *core.Config) radius.ChangeHandler {
+	return func(change radius.Change) (int, error) {
+		if !change.Disconnect {
+			privileged := change.Privilege == radius.PRIVILEGE_ADMIN
+			roles := config.AAA.Roles.Resolve(nil, change.Privilege, -1)
+			return apiSessions.Update(change.Username, change.SessionID, privileged, roles), nil
+		}
+
+		matched := apiSessions.Disconnect(change.Username, change.SessionID)
+		if matched > 0 && change.SessionID != "" || change.Username == "" {
+			// the session was an API session, or we can't tell whose shells
+			return matched, nil
+		}
+
+		shells, err := terminateShells(change.Username, change.SessionID)
+		return matched + shells, err
+	}
+}
+
+// terminateShells hangs up the login sessions of a user, or with a session
+// ID only the one pam_radius_auth numbered so, answering how many it hung up.
+// pam_radius_auth numbers a session by the pid of the process that opened it.
+func terminateShells(username, sessionID string) (int, error) {
+	u, err := user.Lookup(username)
+	if err != nil {
+		// no local account, so no shell either
+		return 0, nil
+	}
+
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil || uid < firstLoginUID {
+		syslogger.Err("API CoA: refusing to hang up sessions of system account", username)
+		return 0, nil
+	}
+
+	logins, err := loginSessions()
+	if err != nil {
+		return 0, err
+	}
+
+	terminated := 0
+	for _, login := range logins {
+		if login.User != username || login.PID <= 1 {
+			continue
+		}
+		if sessionID != "" && sessionID != fmt.Sprintf("%08d", login.PID) {
+			continue
+		}
+		if !ownedBy(login.PID, u.Uid) && !leads(login.PID, login.Line) {
+			// a stale record, its pid may be another process by now
+			continue
+		}
+
+		if err := syscall.Kill(login.PID, syscall.SIGHUP); err != nil {
+			if err != syscall.ESRCH {
+				syslogger.Err("API CoA: hang up", username, "on", login.Line, err)
+			}
+			continue
+		}
+
+		syslogger.Info("API CoA: hung up", username, "on", login.Line, login.Host)
+		terminated++
+	}
+
+	return terminated, nil
+}
+
+// ownedBy tells whether the process pid runs as the real uid
+func ownedBy(pid int, uid string) bool {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return false
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "Uid:" {
+			return fields[1] == uid
+		}
+	}
+
+	return false
+}
+
+// leads tells whether the process pid leads the session of the terminal
+// line, as login does while running as root
+func leads(pid int, line string) bool {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil || line == "" {
+		return false
+	}
+
+	// the fields after the command name, which may hold spaces
+	idx := bytes.LastIndexByte(data, ')')
+	if idx < 0 {
+		return false
+	}
+	fields := strings.Fields(string(data[idx+1:]))
+	if len(fields) < 5 {
+		return false
+	}
+
+	session, err := strconv.Atoi(fields[3])
+	if err != nil || session != pid {
+		return false
+	}
+	tty, err := strconv.ParseUint(fields[4], 10, 64)
+	if err != nil || tty == 0 {
+		return false
+	}
+
+	var stat syscall.Stat_t
+	if err := syscall.Stat("/dev/"+line, &stat); err != nil {
+		return false
+	}
+
+	// /proc and stat encode the device numbers differently
+	rdev := uint64(stat.Rdev)
+	major := (rdev>>8)&0xfff | (rdev>>32)&^0xfff
+	minor := rdev&0xff | (rdev>>12)&^0xff
+
+	return (tty>>8)&0xfff == major && (tty&0xff|(tty>>12)&0xfff00) == minor
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+package main
+
+import (
+	"vega/api/handlers"
+	"vega/api/handlers/system/configs"
+	"vega/core"
+	"github.com/htbig/common/src/vega/api/auth"
+	"github.com/htbig/common/src/vega/api/sessions"
+	"github.com/htbig/common/src/vega/api/transactions"
+	"github.com/htbig/common/src/vega/syslogger"
+)
+
+// apiTransactions holds the candidates and the revisions of the running
+// configuration
+var apiTransactions *transactions.Manager
+
+func newTransactionManager(ctx handlers.Context) *transactions.Manager {
+	manager, err := transactions.New(ctx.Config, ctx.Lock, *configRevisions)
+	if err != nil {
+		// no copy of the configuration, no transactions
+		panic(err)
+	}
+
+	manager.SetHook(func(r transactions.Revision, replaced *core.Config) {
+		auditRevision(ctx.Config, r, configSnapshot(replaced))
+		reconcileCoA(ctx.Config)
+	})
+
+	return manager
+}
+
+// recordChange records the change a request made in place to the running
+// configuration as a revision, if it made one
+func recordChange(ctx handlers.Context) {
+	identity, _ := auth.IdentityOf(ctx.Request)
+	comment := ctx.Request.Method + " " + ctx.Request.URL.Path
+
+	r, ok, err := apiTransactions.Record(identity.Username, comment)
+	if err != nil {
+		syslogger.Err("API revision of", comment, "failed:", err)
+	} else if ok {
+		syslogger.Info("API", comment, "recorded as revision", r.ID)
+	}
+}
+
+// discardCandidate drops the candidate of a login once its session ends for
+// good, a refreshed session keeps it
+func discardCandidate(hook sessions.Hook) sessions.Hook {
+	return func(s sessions.Session, event string) {
+		hook(s, event)
+
+		switch event {
+		case sessions.EVENT_START, sessions.EVENT_REFRESHED:
+		default:
+			if apiTransactions.Discard(transactions.SessionOwner(s.Login)) {
+				syslogger.Info("API candidate of", s.Username, "discarded, session", event)
+			}
+		}
+	}
+}
+
+func configRoutes(ctx handlers.Context) map[string]map[string]handler {
+	admin := newChain(ctx)
+	admin.add(wrapRateLimit("configs"), wrapAuth(true), wrapUserRateLimit("configs"))
+
+	// changes of the running configuration
+	write := newChain(ctx)
+	write.add(wrapRateLimit("configs"), wrapAuth(true), wrapUserRateLimit("configs"), wrapCommitLocker)
+
+	m := apiTransactions
+	r := map[string]map[string]handler{
+		"GET": {
+			"/system/configs/candidate":     admin.wrap(configs.GetCandidate(m)),
+			"/system/configs/confirm":       admin.wrap(configs.GetPending(m)),
+			"/system/configs/revisions":     admin.wrap(configs.GetRevisions(m)),
+			"/system/configs/revisions/:id": admin.wrap(configs.GetRevision(m)),
+		},
+		"PATCH": {
+			"/system/configs/candidate": admin.wrap(configs.PatchCandidate(m)),
+		},
+		"POST": {
+			"/system/configs/candidate/commit":       write.wrap(configs.CommitCandidate(m)),
+			"/system/configs/confirm":                admin.wrap(configs.Confirm(m)),
+			"/system/configs/revisions/:id/rollback": write.wrap(configs.RollbackRevision(m)),
+		},
+		"DELETE": {
+			"/system/configs/candidate": admin.wrap(configs.DeleteCandidate(m)),
+		},
+	}
+
+	return r
+}
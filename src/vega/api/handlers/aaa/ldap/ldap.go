@@ -0,0 +1,143 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+package ldap
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"vega/api/handlers"
+	"vega/core/aaa/ldap"
+)
+
+func Get(ctx handlers.Context) {
+	ctx.Encode(ctx.Config.AAA.LDAP)
+}
+
+func GetEnable(ctx handlers.Context) {
+	ctx.Encode(ctx.Config.AAA.LDAP.Enabled)
+}
+
+func GetFallback(ctx handlers.Context) {
+	ctx.Encode(ctx.Config.AAA.LDAP.Fallback)
+}
+
+func GetServers(ctx handlers.Context) {
+	ctx.Encode(ctx.Config.AAA.LDAP.Servers)
+}
+
+func Patch(ctx handlers.Context) {
+	ctx.MapDecodeVerifySave(&ctx.Config.AAA.LDAP)
+}
+
+func PutEnable(ctx handlers.Context) {
+	cfg := ctx.Config.AAA.LDAP.Clone()
+	var en bool
+	if ctx.Decode(&en) {
+		cfg.Enabled = en
+
+		ctx.VerifySave(cfg, &ctx.Config.AAA.LDAP)
+	}
+}
+
+func PutFallback(ctx handlers.Context) {
+	cfg := ctx.Config.AAA.LDAP.Clone()
+	if ctx.Decode(&cfg.Fallback) {
+		ctx.VerifySave(cfg, &ctx.Config.AAA.LDAP)
+	}
+}
+
+func PutServers(ctx handlers.Context) {
+	cfg := ctx.Config.AAA.LDAP.Clone()
+	var serverList []ldap.Server
+
+	if ctx.Decode(&serverList) {
+		cfg.Servers = serverList
+
+		ctx.VerifySave(cfg, &ctx.Config.AAA.LDAP)
+	}
+}
+
+func PostServers(ctx handlers.Context) {
+	cfg := ctx.Config.AAA.LDAP.Clone()
+	var serverList []ldap.Server
+
+	if ctx.Decode(&serverList) {
+		cfg.Servers = append(cfg.Servers, serverList...)
+
+		ctx.VerifySave(cfg, &ctx.Config.AAA.LDAP)
+	}
+}
+
+func DeleteServers(ctx handlers.Context) {
+	serverList := ctx.Request.URL.Query().Get("servers")
+
+	s := strings.FieldsFunc(serverList, func(c rune) bool {
+		return c == ','
+	})
+
+	if len(s) > 0 {
+		deleteServersByName(ctx, s)
+	} else {
+		//delete all servers
+		cfg := ctx.Config.AAA.LDAP.Clone()
+		cfg.Servers = []ldap.Server{}
+		ctx.VerifySave(cfg, &ctx.Config.AAA.LDAP)
+	}
+}
+
+func deleteServersByName(ctx handlers.Context, toRemove []string) {
+	cfg := ctx.Config.AAA.LDAP.Clone()
+	results, _, notRemoved := removeSelectServers(toRemove, cfg.Servers)
+	if len(notRemoved) > 0 {
+		errs := []error{}
+		for _, server := range notRemoved {
+			errs = append(errs, errors.New("["+server+"] is not configured"))
+		}
+		ctx.EncodeErrors(http.StatusNotFound, errs...)
+	} else {
+		cfg.Servers = results
+
+		ctx.VerifySave(cfg, &ctx.Config.AAA.LDAP)
+	}
+}
+
+// removeSelectServers removes the servers named "host" or "host:port", IPv6
+// hosts with a port in brackets
+func removeSelectServers(toRemove []string, from []ldap.Server) (results []ldap.Server, removed, notRemoved []string) {
+	results = []ldap.Server{}
+	removed = []string{}
+	notRemoved = []string{}
+	removedSet := make(map[string]bool)
+
+outer:
+	for _, ofFrom := range from {
+		for _, ofToRemove := range toRemove {
+			host, port := getHostPort(ofToRemove)
+			if ofFrom.Host == host && (port == "" || strconv.Itoa(int(ofFrom.Port)) == port) {
+				removedSet[ofToRemove] = true
+				continue outer
+			}
+		}
+		results = append(results, ofFrom)
+	}
+
+	for _, remove := range toRemove {
+		if removedSet[remove] {
+			removed = append(removed, remove)
+		} else {
+			notRemoved = append(notRemoved, remove)
+		}
+	}
+
+	return
+}
+
+func getHostPort(hostport string) (host string, port string) {
+	if host, port, err := net.SplitHostPort(hostport); err == nil {
+		return host, port
+	}
+	return hostport, ""
+}
@@ -7,17 +7,20 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"github.com/htbig/common/src/vega/api/auth"
 	"vega/api/handlers"
 	"vega/core/aaa/localusers"
+	"github.com/htbig/common/src/vega/syslogger"
 )
 
+// Get answers the users without their password hashes
 func Get(ctx handlers.Context) {
-	ctx.Encode(ctx.Config.AAA.LocalUsers)
+	ctx.Encode(ctx.Config.AAA.LocalUsers.Redacted())
 }
 
 func GetUser(ctx handlers.Context) {
 	name := ctx.Params.ByName("username")
-	if user, err := findUser(ctx.Config.AAA.LocalUsers, name); err != nil {
+	if user, err := findUser(ctx.Config.AAA.LocalUsers.Redacted(), name); err != nil {
 		ctx.NotFound()
 	} else {
 		ctx.Encode(user)
@@ -44,20 +47,39 @@ func PutUser(ctx handlers.Context) {
 
 	user.Username = username
 
+	policy := &ctx.Config.AAA.PasswordPolicy
 	errs := localusers.VerifyUsers([]localusers.User{user})
+	errs = append(errs, policy.Check(user.Username, user.Password)...)
 	if len(errs) > 0 {
 		ctx.EncodeBadRequests(errs...)
 		return
 	}
 
-	localusers.SetPassword(user.Username, user.Password)
+	hash, err := policy.Hash(user.Password)
+	if err != nil {
+		ctx.EncodeInternalServerErrors(err)
+		return
+	}
+
+	err = localusers.SetPassword(user.Username, hash)
+	if err != nil {
+		defer rollback(ctx)
+		ctx.EncodeInternalServerErrors(err)
+		return
+	}
+
+	if err = policy.Remember(user.Username, hash); err != nil {
+		syslogger.Err("Password history of", user.Username, ":", err)
+	}
+
+	err = localusers.SetPrivilege(user.Username, user.Privilege)
 	if err != nil {
 		defer rollback(ctx)
 		ctx.EncodeInternalServerErrors(err)
 		return
 	}
 
-	localusers.SetPrivilege(user.Username, user.Privilege)
+	err = localusers.SetTwoFactor(user.Username, user.TwoFactor)
 	if err != nil {
 		defer rollback(ctx)
 		ctx.EncodeInternalServerErrors(err)
@@ -94,19 +116,31 @@ func PutPassByName(ctx handlers.Context) {
 
 	user.Username = username
 
+	policy := &ctx.Config.AAA.PasswordPolicy
 	errs := localusers.VerifyUsers([]localusers.User{user})
+	errs = append(errs, policy.Check(user.Username, user.Password)...)
 	if len(errs) > 0 {
 		ctx.EncodeBadRequests(errs...)
 		return
 	}
 
-	localusers.SetPassword(user.Username, user.Password)
+	hash, err := policy.Hash(user.Password)
+	if err != nil {
+		ctx.EncodeInternalServerErrors(err)
+		return
+	}
+
+	err = localusers.SetPassword(user.Username, hash)
 	if err != nil {
 		defer rollback(ctx)
 		ctx.EncodeInternalServerErrors(err)
 		return
 	}
 
+	if err = policy.Remember(user.Username, hash); err != nil {
+		syslogger.Err("Password history of", user.Username, ":", err)
+	}
+
 	err = cfg.LoadUsers()
 	if err != nil {
 		defer rollback(ctx)
@@ -141,7 +175,7 @@ func PutPrivilegeByName(ctx handlers.Context) {
 		return
 	}
 
-	localusers.SetPrivilege(user.Username, user.Privilege)
+	err = localusers.SetPrivilege(user.Username, user.Privilege)
 	if err != nil {
 		defer rollback(ctx)
 		ctx.EncodeInternalServerErrors(err)
@@ -164,7 +198,61 @@ func Patch(ctx handlers.Context) {
 		return
 	}
 
-	ctx.VerifySave(users, &ctx.Config.AAA.LocalUsers)
+	// users are read without password, sending them back keeps it
+	users.KeepPasswords(ctx.Config.AAA.LocalUsers)
+
+	// a changed password goes through the policy like any other
+	policy := &ctx.Config.AAA.PasswordPolicy
+	changed := []int{}
+	var errs []error
+	for idx, user := range *users {
+		if !passwordChanged(ctx.Config.AAA.LocalUsers, user) {
+			continue
+		}
+
+		errs = append(errs, policy.Check(user.Username, user.Password)...)
+		changed = append(changed, idx)
+	}
+	if len(errs) > 0 {
+		ctx.EncodeBadRequests(errs...)
+		return
+	}
+
+	for _, idx := range changed {
+		hash, err := policy.Hash((*users)[idx].Password)
+		if err != nil {
+			ctx.EncodeInternalServerErrors(err)
+			return
+		}
+		(*users)[idx].Password = hash
+	}
+
+	if !ctx.VerifySave(users, &ctx.Config.AAA.LocalUsers) {
+		return
+	}
+
+	for _, idx := range changed {
+		user := (*users)[idx]
+		if err := policy.Remember(user.Username, user.Password); err != nil {
+			syslogger.Err("Password history of", user.Username, ":", err)
+		}
+	}
+}
+
+// passwordChanged tells whether user comes with another password than the
+// one of the same user in config
+func passwordChanged(config localusers.Config, user localusers.User) bool {
+	if user.Password == "" {
+		return false
+	}
+
+	for _, oldUser := range config {
+		if oldUser.Username == user.Username {
+			return user.Password != oldUser.Password
+		}
+	}
+
+	return true
 }
 
 func Post(ctx handlers.Context) {
@@ -189,7 +277,11 @@ func Post(ctx handlers.Context) {
 		}
 	}()
 
+	policy := &ctx.Config.AAA.PasswordPolicy
 	errs = users.Verify()
+	for _, user := range users {
+		errs = append(errs, policy.Check(user.Username, user.Password)...)
+	}
 	if len(errs) > 0 {
 		ctx.EncodeBadRequests(errs...)
 		return
@@ -220,12 +312,37 @@ func Post(ctx handlers.Context) {
 
 	if len(errs) == 0 {
 		for _, user := range users {
-			err = localusers.AddUser(user.Username, user.Password, user.Privilege)
+			hash, err := policy.Hash(user.Password)
+			if err != nil {
+				ctx.EncodeInternalServerErrors(err)
+				rollback(ctx)
+				return
+			}
+
+			err = localusers.AddUser(user.Username, hash, user.Privilege)
+			if err != nil {
+				ctx.EncodeInternalServerErrors(err)
+				rollback(ctx)
+				return
+			}
+
+			err = localusers.SetKeys(user.Username, user.Keys)
+			if err != nil {
+				ctx.EncodeInternalServerErrors(err)
+				rollback(ctx)
+				return
+			}
+
+			err = localusers.SetTwoFactor(user.Username, user.TwoFactor)
 			if err != nil {
 				ctx.EncodeInternalServerErrors(err)
 				rollback(ctx)
 				return
 			}
+
+			if err = policy.Remember(user.Username, hash); err != nil {
+				syslogger.Err("Password history of", user.Username, ":", err)
+			}
 		}
 
 		err = cfg.LoadUsers()
@@ -346,6 +463,196 @@ func Delete(ctx handlers.Context) {
 	//	}
 }
 
+// GetLockout answers the failed logins of a user and whether it is locked
+func GetLockout(ctx handlers.Context) {
+	ctx.Encode(auth.LockoutOf(ctx.Params.ByName("username")))
+}
+
+// DeleteLockout unlocks a user locked out by failed logins
+func DeleteLockout(ctx handlers.Context) {
+	username := ctx.Params.ByName("username")
+	if !auth.Unlock(username) {
+		ctx.NotFound()
+		return
+	}
+
+	identity, _ := auth.IdentityOf(ctx.Request)
+	syslogger.Info("Auth:", username, "unlocked by", identity.Username)
+}
+
+// GetKeys answers the SSH keys of a user with their fingerprints
+func GetKeys(ctx handlers.Context) {
+	name := ctx.Params.ByName("username")
+	if user, err := findUser(ctx.Config.AAA.LocalUsers, name); err != nil {
+		ctx.NotFound()
+	} else {
+		ctx.Encode(user.ParsedKeys())
+	}
+}
+
+// PostKey adds an SSH key to a user, the key is given as its authorized_keys
+// line in {"key": "ssh-ed25519 AAAA... comment"}
+func PostKey(ctx handlers.Context) {
+	username := ctx.Params.ByName("username")
+	cfg := ctx.Config.AAA.LocalUsers.Clone()
+
+	user, err := findUser(*cfg, username)
+	if err != nil {
+		ctx.NotFound()
+		return
+	}
+
+	var input localusers.Key
+	if !ctx.Decode(&input) {
+		ctx.EncodeBadRequests()
+		return
+	}
+
+	key, err := localusers.ParseKey(input.Key)
+	if err != nil {
+		ctx.EncodeBadRequests(err)
+		return
+	}
+
+	if user.FindKey(key.Fingerprint) >= 0 {
+		ctx.EncodeErrors(http.StatusConflict, fmt.Errorf("Key %s already exists", key.Fingerprint))
+		return
+	}
+
+	if err = setKeys(ctx, cfg, user.Username, append(user.Keys, key.Key)); err != nil {
+		return
+	}
+
+	ctx.Encode(key)
+}
+
+// DeleteKey removes the SSH key of a user with the fingerprint given by the
+// "fingerprint" query parameter, as fingerprints may contain a slash
+func DeleteKey(ctx handlers.Context) {
+	username := ctx.Params.ByName("username")
+	cfg := ctx.Config.AAA.LocalUsers.Clone()
+
+	user, err := findUser(*cfg, username)
+	if err != nil {
+		ctx.NotFound()
+		return
+	}
+
+	idx := user.FindKey(ctx.Request.URL.Query().Get("fingerprint"))
+	if idx < 0 {
+		ctx.NotFound()
+		return
+	}
+
+	keys := append(append([]string{}, user.Keys[:idx]...), user.Keys[idx+1:]...)
+	setKeys(ctx, cfg, user.Username, keys)
+}
+
+// GetTOTP answers the second factor of a user
+func GetTOTP(ctx handlers.Context) {
+	username := ctx.Params.ByName("username")
+	if _, err := findUser(ctx.Config.AAA.LocalUsers, username); err != nil {
+		ctx.NotFound()
+		return
+	}
+
+	status, err := localusers.TwoFactorOf(username)
+	if err != nil {
+		ctx.EncodeInternalServerErrors(err)
+		return
+	}
+
+	ctx.Encode(status)
+}
+
+// PostTOTP starts a TOTP enrollment of a user and answers the otpauth URI
+// to scan and the recovery codes, they are not shown again. The enrollment
+// takes effect once confirmed by a code.
+func PostTOTP(ctx handlers.Context) {
+	username := ctx.Params.ByName("username")
+	if _, err := findUser(ctx.Config.AAA.LocalUsers, username); err != nil {
+		ctx.NotFound()
+		return
+	}
+
+	enrollment, err := localusers.Enroll(username)
+	if err != nil {
+		ctx.EncodeInternalServerErrors(err)
+		return
+	}
+
+	identity, _ := auth.IdentityOf(ctx.Request)
+	syslogger.Info("Auth: TOTP enrollment of", username, "started by", identity.Username)
+
+	ctx.Writer.WriteHeader(http.StatusCreated)
+	ctx.Encode(enrollment)
+}
+
+// ConfirmTOTP ends the TOTP enrollment of a user with a code of the new
+// secret given as {"code": "123456"}
+func ConfirmTOTP(ctx handlers.Context) {
+	username := ctx.Params.ByName("username")
+	if _, err := findUser(ctx.Config.AAA.LocalUsers, username); err != nil {
+		ctx.NotFound()
+		return
+	}
+
+	var input struct {
+		Code string `json:"code"`
+	}
+	if !ctx.Decode(&input) {
+		ctx.EncodeBadRequests()
+		return
+	}
+
+	ok, err := localusers.ConfirmEnrollment(username, input.Code)
+	if err != nil {
+		ctx.EncodeBadRequests(err)
+		return
+	} else if !ok {
+		ctx.EncodeBadRequests(errors.New("Bad TOTP code"))
+		return
+	}
+
+	syslogger.Info("Auth: TOTP enrollment of", username, "confirmed")
+}
+
+// DeleteTOTP removes the TOTP secret and the recovery codes of a user
+func DeleteTOTP(ctx handlers.Context) {
+	username := ctx.Params.ByName("username")
+
+	ok, err := localusers.Disenroll(username)
+	if err != nil {
+		ctx.EncodeInternalServerErrors(err)
+		return
+	} else if !ok {
+		ctx.NotFound()
+		return
+	}
+
+	identity, _ := auth.IdentityOf(ctx.Request)
+	syslogger.Info("Auth: TOTP of", username, "removed by", identity.Username)
+}
+
+func setKeys(ctx handlers.Context, cfg *localusers.Config, username string, keys []string) error {
+	err := localusers.SetKeys(username, keys)
+	if err != nil {
+		defer rollback(ctx)
+		ctx.EncodeInternalServerErrors(err)
+		return err
+	}
+
+	err = cfg.LoadUsers()
+	if err != nil {
+		defer rollback(ctx)
+		ctx.EncodeInternalServerErrors(err)
+		return err
+	}
+
+	ctx.Config.AAA.LocalUsers.CopyFrom(*cfg)
+	return nil
+}
+
 func findUser(users []localusers.User, query string) (localusers.User, error) {
 	for _, usr := range users {
 		if query == usr.Username {
@@ -30,6 +30,11 @@ func GetServers(ctx handlers.Context) {
 	ctx.Encode(ctx.Config.AAA.RADIUS.Servers)
 }
 
+// GetServersStatus answers the outcome of the last probes of each server
+func GetServersStatus(ctx handlers.Context) {
+	ctx.Encode(ctx.Config.AAA.RADIUS.ServerStatus())
+}
+
 func Patch(ctx handlers.Context) {
 	ctx.MapDecodeVerifySave(&ctx.Config.AAA.RADIUS)
 }
@@ -163,27 +168,24 @@ func LocalAuthenticate(ctx handlers.Context) {
 
 	username, password, _ := ctx.Request.BasicAuth()
 
-	isRadius := true
-	isFallback := ctx.Config.AAA.RADIUS.Fallback
+	// the local RADIUS helper asks even while RADIUS is disabled for the API
+	config := ctx.Config.AAA.Clone()
+	config.RADIUS.Enabled = true
 	checkPrivilege := true
-	authenticated, authorized, errs := auth.AuthenticateAPI(isRadius, isFallback, checkPrivilege, username, password)
+	authenticated, authorized, method, errs := auth.AuthenticateAPI(config, checkPrivilege, username, password, ctx.Request.Header.Get(auth.OTPHeader))
 
 	if len(errs) > 0 {
-		if errs[0].Error() == radius.GatewayTimeoutError {
-			ctx.EncodeErrors(http.StatusGatewayTimeout, errs...)
-		} else if errs[0].Error() == radius.RadiusAuthError {
-			ctx.EncodeErrors(http.StatusBadGateway, errs...)
-		} else {
-			ctx.EncodeInternalServerErrors(errs...)
-		}
+		ctx.EncodeErrors(auth.ErrorStatus(errs), errs...)
 	} else {
 		ctx.Encode(
 			struct {
 				Authenticated bool
 				Privileged    bool
+				Method        string
 			}{
 				authenticated,
 				authorized,
+				method,
 			},
 		)
 	}
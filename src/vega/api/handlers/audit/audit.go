@@ -0,0 +1,58 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/htbig/common/src/vega/api/audit"
+	"vega/api/handlers"
+)
+
+// Get answers the records of the audit log, oldest first. The query
+// parameters "since" and "until" (RFC 3339), "user" and "limit" filter them.
+func Get(l *audit.Log) handlers.Handler {
+	return func(ctx handlers.Context) {
+		if l == nil {
+			ctx.EncodeErrors(http.StatusServiceUnavailable, errors.New("Audit log not available"))
+			return
+		}
+
+		query := ctx.Request.URL.Query()
+		filter := audit.Filter{Username: query.Get("user")}
+
+		var errs []error
+		var err error
+		if value := query.Get("since"); value != "" {
+			if filter.Since, err = time.Parse(time.RFC3339, value); err != nil {
+				errs = append(errs, fmt.Errorf("Bad since time: %s", value))
+			}
+		}
+		if value := query.Get("until"); value != "" {
+			if filter.Until, err = time.Parse(time.RFC3339, value); err != nil {
+				errs = append(errs, fmt.Errorf("Bad until time: %s", value))
+			}
+		}
+		if value := query.Get("limit"); value != "" {
+			if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit < 0 {
+				errs = append(errs, fmt.Errorf("Bad limit: %s", value))
+			}
+		}
+		if len(errs) > 0 {
+			ctx.EncodeBadRequests(errs...)
+			return
+		}
+
+		records, err := l.Query(filter)
+		if err != nil {
+			ctx.EncodeInternalServerErrors(err)
+			return
+		}
+
+		ctx.Encode(records)
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+package ratelimits
+
+import (
+	"github.com/htbig/common/src/vega/api/auth"
+	"vega/api/handlers"
+	"github.com/htbig/common/src/vega/api/ratelimit"
+	"github.com/htbig/common/src/vega/syslogger"
+)
+
+// Get answers the limit of every route group and its buckets not full
+func Get(r *ratelimit.Registry) handlers.Handler {
+	return func(ctx handlers.Context) {
+		ctx.Encode(r.State())
+	}
+}
+
+// Delete refills the buckets of the "key" query parameter, as
+// "source:<address>" or "user:<username>"
+func Delete(r *ratelimit.Registry) handlers.Handler {
+	return func(ctx handlers.Context) {
+		key := ctx.Request.URL.Query().Get("key")
+		if !r.Reset(key) {
+			ctx.NotFound()
+			return
+		}
+
+		identity, _ := auth.IdentityOf(ctx.Request)
+		syslogger.Info("Rate limit of", key, "reset by", identity.Username)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+package sessions
+
+import (
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/htbig/common/src/vega/api/auth"
+	"vega/api/handlers"
+	"github.com/htbig/common/src/vega/api/sessions"
+)
+
+type tokenResponse struct {
+	Token string `json:"token"`
+	sessions.Session
+}
+
+// Login authenticates Basic credentials once and answers with a bearer token
+func Login(m *sessions.Manager) handlers.Handler {
+	return func(ctx handlers.Context) {
+		username, password, ok := ctx.Request.BasicAuth()
+		if !ok {
+			ctx.Writer.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		result, errs := auth.Authenticate(&ctx.Config.AAA, username, password, ctx.Request.Header.Get(auth.OTPHeader))
+		if !result.Authenticated {
+			if len(errs) > 0 {
+				ctx.EncodeErrors(auth.ErrorStatus(errs), errs...)
+			} else {
+				ctx.Writer.WriteHeader(http.StatusUnauthorized)
+			}
+			return
+		}
+
+		remote, _, _ := net.SplitHostPort(ctx.Request.RemoteAddr)
+		token, session, err := m.Issue(sessions.Session{
+			Username:   username,
+			Method:     result.Method,
+			Privileged: result.Privileged,
+			Roles:      ctx.Config.AAA.Roles.Resolve(result.Groups, result.RadiusPrivilege, result.TacacsPrivilege),
+			Remote:     remote,
+		})
+		if err != nil {
+			ctx.EncodeInternalServerErrors(err)
+			return
+		}
+
+		ctx.Writer.WriteHeader(http.StatusCreated)
+		ctx.Encode(tokenResponse{token, session})
+	}
+}
+
+// Refresh exchanges a valid bearer token for a new one
+func Refresh(m *sessions.Manager) handlers.Handler {
+	return func(ctx handlers.Context) {
+		token, ok := sessions.BearerToken(ctx.Request.Header.Get("Authorization"))
+		if !ok {
+			ctx.EncodeErrors(http.StatusUnauthorized, errors.New("Bearer token required"))
+			return
+		}
+
+		token, session, err := m.Refresh(token)
+		if err != nil {
+			ctx.EncodeErrors(http.StatusUnauthorized, err)
+			return
+		}
+
+		ctx.Encode(tokenResponse{token, session})
+	}
+}
+
+// Logout revokes the bearer token of the request
+func Logout(m *sessions.Manager) handlers.Handler {
+	return func(ctx handlers.Context) {
+		token, ok := sessions.BearerToken(ctx.Request.Header.Get("Authorization"))
+		if !ok {
+			ctx.EncodeErrors(http.StatusUnauthorized, errors.New("Bearer token required"))
+			return
+		}
+
+		session, err := m.Verify(token)
+		if err != nil {
+			ctx.EncodeErrors(http.StatusUnauthorized, err)
+			return
+		}
+
+		m.Revoke(session.ID)
+	}
+}
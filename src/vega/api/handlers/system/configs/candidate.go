@@ -0,0 +1,186 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+package configs
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/htbig/common/src/vega/api/auth"
+	"vega/api/handlers"
+	"github.com/htbig/common/src/vega/api/transactions"
+	"github.com/htbig/common/src/vega/syslogger"
+)
+
+// owner returns the owner of the candidate of the request: its login
+// session, or its user for the other credentials
+func owner(ctx handlers.Context) (string, string) {
+	identity, _ := auth.IdentityOf(ctx.Request)
+	if identity.Session != "" {
+		return transactions.SessionOwner(identity.Session), identity.Username
+	}
+
+	return transactions.UserOwner(identity.Username), identity.Username
+}
+
+func GetCandidate(m *transactions.Manager) handlers.Handler {
+	return func(ctx handlers.Context) {
+		key, _ := owner(ctx)
+
+		cfg, err := m.Candidate(key)
+		if err != nil {
+			ctx.EncodeInternalServerErrors(err)
+			return
+		}
+
+		ctx.Encode(cfg.Map())
+	}
+}
+
+// PatchCandidate changes the candidate of the caller, the running
+// configuration is left as is until the candidate is committed
+func PatchCandidate(m *transactions.Manager) handlers.Handler {
+	return func(ctx handlers.Context) {
+		key, _ := owner(ctx)
+
+		cfg, err := m.Candidate(key)
+		if err != nil {
+			ctx.EncodeInternalServerErrors(err)
+			return
+		}
+
+		if !ctx.MapDecode(&cfg) {
+			return
+		}
+
+		if errorMap := cfg.Verify(); len(errorMap) > 0 {
+			ctx.EncodeErrorMap(http.StatusBadRequest, errorMap)
+			return
+		}
+
+		if err := m.SetCandidate(key, cfg); err != nil {
+			ctx.EncodeInternalServerErrors(err)
+		}
+	}
+}
+
+func DeleteCandidate(m *transactions.Manager) handlers.Handler {
+	return func(ctx handlers.Context) {
+		key, _ := owner(ctx)
+
+		if !m.Discard(key) {
+			ctx.NotFound()
+		}
+	}
+}
+
+// CommitCandidate applies the candidate of the caller to the running
+// configuration. With "confirmed" minutes the commit is rolled back unless
+// confirmed in time.
+func CommitCandidate(m *transactions.Manager) handlers.Handler {
+	return func(ctx handlers.Context) {
+		key, username := owner(ctx)
+
+		var input struct {
+			Confirmed int    `json:"confirmed"`
+			Comment   string `json:"comment"`
+		}
+		if ctx.Request.ContentLength != 0 && !ctx.Decode(&input) {
+			ctx.EncodeBadRequests()
+			return
+		}
+
+		timeout := time.Duration(input.Confirmed) * time.Minute
+		revision, errorMap, err := m.Commit(key, username, input.Comment, timeout)
+		switch {
+		case err == transactions.ErrTimeout:
+			ctx.EncodeBadRequests(err)
+		case err == transactions.ErrConflict:
+			ctx.EncodeErrors(http.StatusConflict, err)
+		case err != nil:
+			syslogger.Err("API Commit Error:", err)
+			ctx.EncodeInternalServerErrors(err)
+		case len(errorMap) > 0:
+			ctx.EncodeErrorMap(http.StatusBadRequest, errorMap)
+		default:
+			ctx.Encode(revision)
+		}
+	}
+}
+
+// GetPending answers the confirmed commit waiting for its confirmation
+func GetPending(m *transactions.Manager) handlers.Handler {
+	return func(ctx handlers.Context) {
+		if pending, ok := m.Pending(); ok {
+			ctx.Encode(pending)
+		} else {
+			ctx.NotFound()
+		}
+	}
+}
+
+// Confirm keeps the confirmed commit waiting for its confirmation
+func Confirm(m *transactions.Manager) handlers.Handler {
+	return func(ctx handlers.Context) {
+		pending, err := m.Confirm()
+		if err != nil {
+			ctx.EncodeErrors(http.StatusConflict, err)
+			return
+		}
+
+		ctx.Encode(pending)
+	}
+}
+
+func GetRevisions(m *transactions.Manager) handlers.Handler {
+	return func(ctx handlers.Context) {
+		ctx.Encode(m.Revisions())
+	}
+}
+
+func GetRevision(m *transactions.Manager) handlers.Handler {
+	return func(ctx handlers.Context) {
+		id, err := strconv.Atoi(ctx.Params.ByName("id"))
+		if err != nil {
+			ctx.NotFound()
+			return
+		}
+
+		cfg, err := m.Config(id)
+		if err == transactions.ErrNoRevision {
+			ctx.NotFound()
+			return
+		} else if err != nil {
+			ctx.EncodeInternalServerErrors(err)
+			return
+		}
+
+		ctx.Encode(cfg.Map())
+	}
+}
+
+// RollbackRevision applies the configuration of a revision as a new one
+func RollbackRevision(m *transactions.Manager) handlers.Handler {
+	return func(ctx handlers.Context) {
+		id, err := strconv.Atoi(ctx.Params.ByName("id"))
+		if err != nil {
+			ctx.NotFound()
+			return
+		}
+
+		_, username := owner(ctx)
+		revision, errorMap, err := m.Rollback(id, username)
+		switch {
+		case err == transactions.ErrNoRevision:
+			ctx.NotFound()
+		case err != nil:
+			syslogger.Err("API Rollback Error:", err)
+			ctx.EncodeInternalServerErrors(err)
+		case len(errorMap) > 0:
+			ctx.EncodeErrorMap(http.StatusBadRequest, errorMap)
+		default:
+			ctx.Encode(revision)
+		}
+	}
+}
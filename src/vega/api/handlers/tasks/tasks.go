@@ -0,0 +1,120 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+package tasks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"vega/api/handlers"
+	"github.com/htbig/common/src/vega/api/tasks"
+)
+
+const (
+	ContentTypeEventStream = "text/event-stream"
+
+	keepAliveInterval = 15 * time.Second
+)
+
+// List returns the tasks matching the optional "state" (comma separated) and
+// "description" query parameters
+func List(ctx handlers.Context) {
+	query := ctx.Request.URL.Query()
+
+	var filter tasks.Filter
+	filter.Description = query.Get("description")
+	for _, state := range strings.FieldsFunc(query.Get("state"), func(c rune) bool { return c == ',' }) {
+		filter.States = append(filter.States, tasks.State(state))
+	}
+
+	infos := []tasks.Info{}
+	for _, task := range ctx.Tasks.List(filter) {
+		infos = append(infos, task.Info())
+	}
+
+	ctx.Encode(infos)
+}
+
+// Delete stops a task if it is still pending and forgets it
+func Delete(ctx handlers.Context) {
+	id := ctx.Params.ByName("id")
+
+	task := ctx.Tasks.Get(id)
+	if task == nil {
+		ctx.NotFound()
+		return
+	}
+
+	task.Stop()
+	ctx.Tasks.Delete(id)
+}
+
+// Events streams the state and progress events of a task as server-sent
+// events until the task finishes or the client goes away
+func Events(ctx handlers.Context) {
+	id := ctx.Params.ByName("id")
+
+	// subscribe before taking the first snapshot so no transition is missed
+	events, cancel := ctx.Tasks.Subscribe(id)
+	defer cancel()
+
+	task := ctx.Tasks.Get(id)
+	if task == nil {
+		ctx.NotFound()
+		return
+	}
+
+	flusher, ok := ctx.Writer.(http.Flusher)
+	if !ok {
+		ctx.EncodeInternalServerErrors(errors.New("Streaming is not supported"))
+		return
+	}
+
+	header := ctx.Writer.Header()
+	header.Set("Content-Type", ContentTypeEventStream)
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no")
+	ctx.Writer.WriteHeader(http.StatusOK)
+
+	event := task.Event()
+	if err := writeEvent(ctx.Writer, event); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for !event.IsFinal() {
+		select {
+		case event, ok = <-events:
+			if !ok {
+				return
+			}
+			if err := writeEvent(ctx.Writer, event); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(ctx.Writer, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-ctx.Request.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event tasks.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	return err
+}
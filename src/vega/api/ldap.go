@@ -0,0 +1,45 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+package main
+
+import (
+	"vega/api/handlers"
+	"github.com/htbig/common/src/vega/api/handlers/aaa/ldap"
+)
+
+func ldapRoutes(ctx handlers.Context) map[string]map[string]handler {
+	// the config holds the bind password, every route is for admins
+	admin := newChain(ctx)
+	admin.add(wrapRateLimit("ldap"), wrapAuth(true), wrapUserRateLimit("ldap"))
+
+	write := newChain(ctx)
+	write.add(wrapRateLimit("ldap"), wrapAuth(true), wrapUserRateLimit("ldap"), wrapLocker)
+
+	writeJSON := newChain(ctx)
+	writeJSON.add(wrapRateLimit("ldap"), wrapAuth(true), wrapUserRateLimit("ldap"), wrapLocker, wrapValidJSON)
+
+	r := map[string]map[string]handler{
+		"GET": {
+			"/aaa/ldap":          admin.wrap(ldap.Get),
+			"/aaa/ldap/enable":   admin.wrap(ldap.GetEnable),
+			"/aaa/ldap/fallback": admin.wrap(ldap.GetFallback),
+			"/aaa/ldap/servers":  admin.wrap(ldap.GetServers),
+		},
+		"PATCH": {
+			"/aaa/ldap": writeJSON.wrap(ldap.Patch),
+		},
+		"PUT": {
+			"/aaa/ldap/enable":   writeJSON.wrap(ldap.PutEnable),
+			"/aaa/ldap/fallback": writeJSON.wrap(ldap.PutFallback),
+			"/aaa/ldap/servers":  writeJSON.wrap(ldap.PutServers),
+		},
+		"POST": {
+			"/aaa/ldap/servers": writeJSON.wrap(ldap.PostServers),
+		},
+		"DELETE": {
+			"/aaa/ldap/servers": write.wrap(ldap.DeleteServers),
+		},
+	}
+
+	return r
+}
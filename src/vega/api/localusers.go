@@ -0,0 +1,40 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+package main
+
+import (
+	"vega/api/handlers"
+	"github.com/htbig/common/src/vega/api/handlers/aaa/localusers"
+)
+
+func localusersRoutes(ctx handlers.Context) map[string]map[string]handler {
+	admin := newChain(ctx)
+	admin.add(wrapRateLimit("localusers"), wrapAuth(true), wrapUserRateLimit("localusers"))
+
+	// getting a user back in does not wait for a pending commit
+	recovery := newChain(ctx)
+	recovery.add(wrapRateLimit("localusers"), wrapAuth(true), wrapUserRateLimit("localusers"), wrapRecoveryLocker)
+
+	recoveryJSON := newChain(ctx)
+	recoveryJSON.add(wrapRateLimit("localusers"), wrapAuth(true), wrapUserRateLimit("localusers"), wrapRecoveryLocker, wrapValidJSON)
+
+	r := map[string]map[string]handler{
+		"GET": {
+			"/aaa/localusers/:username/lockout": admin.wrap(localusers.GetLockout),
+			"/aaa/localusers/:username/keys":    admin.wrap(localusers.GetKeys),
+			"/aaa/localusers/:username/totp":    admin.wrap(localusers.GetTOTP),
+		},
+		"POST": {
+			"/aaa/localusers/:username/keys":         recoveryJSON.wrap(localusers.PostKey),
+			"/aaa/localusers/:username/totp":         recovery.wrap(localusers.PostTOTP),
+			"/aaa/localusers/:username/totp/confirm": recoveryJSON.wrap(localusers.ConfirmTOTP),
+		},
+		"DELETE": {
+			"/aaa/localusers/:username/lockout": recovery.wrap(localusers.DeleteLockout),
+			"/aaa/localusers/:username/keys":    recovery.wrap(localusers.DeleteKey),
+			"/aaa/localusers/:username/totp":    recovery.wrap(localusers.DeleteTOTP),
+		},
+	}
+
+	return r
+}
@@ -1,27 +1,30 @@
 package locker
 
+import "sync"
+
+// Lock is taken by one client at a time, it is safe for concurrent use
 type Lock struct {
-	channel chan int
-	client  string
+	mu     sync.Mutex
+	locked bool
+	client string
 }
 
 func (lock *Lock) TryLock(lockerClient string) (bool, string) {
-	select {
-	case lock.channel <- 0:
-		lock.client = lockerClient
-		return true, lockerClient
-	default:
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+
+	if lock.locked {
 		return false, lock.client
 	}
+
+	lock.locked, lock.client = true, lockerClient
+	return true, lockerClient
 }
 
 func (lock *Lock) Unlock() {
-	select {
-	case <-lock.channel:
-		lock.client = ""
-	default:
-	}
-
+	lock.mu.Lock()
+	lock.locked, lock.client = false, ""
+	lock.mu.Unlock()
 }
 
 func (lock *Lock) Try(lockerClient string, f func()) (bool, string) {
@@ -35,10 +38,5 @@ func (lock *Lock) Try(lockerClient string, f func()) (bool, string) {
 }
 
 func New() *Lock {
-	lock := new(Lock)
-
-	lock.channel = make(chan int, 1)
-	lock.Unlock()
-
-	return lock
+	return new(Lock)
 }
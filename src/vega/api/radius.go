@@ -0,0 +1,21 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+package main
+
+import (
+	"vega/api/handlers"
+	"github.com/htbig/common/src/vega/api/handlers/aaa/radius"
+)
+
+func radiusRoutes(ctx handlers.Context) map[string]map[string]handler {
+	admin := newChain(ctx)
+	admin.add(wrapRateLimit("radius"), wrapAuth(true), wrapUserRateLimit("radius"))
+
+	r := map[string]map[string]handler{
+		"GET": {
+			"/aaa/radius/servers/status": admin.wrap(radius.GetServersStatus),
+		},
+	}
+
+	return r
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+package main
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/htbig/common/src/vega/api/auth"
+	"vega/api/handlers"
+	"github.com/htbig/common/src/vega/api/handlers/ratelimits"
+	"github.com/htbig/common/src/vega/api/ratelimit"
+)
+
+const RateLimitError = "Too many requests"
+
+// apiRateLimits holds the limiters of the route groups
+var apiRateLimits *ratelimit.Registry
+
+// wrapRateLimit limits the requests of a route group per source address.
+// It comes before wrapAuth so that the requests over the limit do not reach
+// PAM or the RADIUS servers.
+func wrapRateLimit(group string) handlerWrapper {
+	return func(handler handlers.Handler) handlers.Handler {
+		return func(ctx handlers.Context) {
+			host, _, err := net.SplitHostPort(ctx.Request.RemoteAddr)
+			if err != nil {
+				host = ctx.Request.RemoteAddr
+			}
+
+			if !rateLimited(ctx, group, "source:"+host) {
+				handler(ctx)
+			}
+		}
+	}
+}
+
+// wrapUserRateLimit limits the requests of a route group per user, it comes
+// after wrapAuth that sets the identity
+func wrapUserRateLimit(group string) handlerWrapper {
+	return func(handler handlers.Handler) handlers.Handler {
+		return func(ctx handlers.Context) {
+			identity, ok := auth.IdentityOf(ctx.Request)
+			if ok && identity.Username != "" && rateLimited(ctx, group, "user:"+identity.Username) {
+				return
+			}
+
+			handler(ctx)
+		}
+	}
+}
+
+// rateLimited takes a token of the bucket of key in group, if there is none
+// it answers 429 and tells so
+func rateLimited(ctx handlers.Context, group, key string) bool {
+	ok, wait := apiRateLimits.Limiter(group).Allow(key)
+	if ok {
+		return false
+	}
+
+	retry := int(math.Ceil(wait.Seconds()))
+	if retry < 1 {
+		retry = 1
+	}
+
+	ctx.Writer.Header().Set("Retry-After", strconv.Itoa(retry))
+	ctx.EncodeErrors(http.StatusTooManyRequests, errors.New(RateLimitError))
+	return true
+}
+
+func rateLimitRoutes(ctx handlers.Context) map[string]map[string]handler {
+	admin := newChain(ctx)
+	admin.add(wrapRateLimit("ratelimits"), wrapAuth(true), wrapUserRateLimit("ratelimits"))
+
+	r := map[string]map[string]handler{
+		"GET": {
+			"/system/ratelimits": admin.wrap(ratelimits.Get(apiRateLimits)),
+		},
+		"DELETE": {
+			"/system/ratelimits": admin.wrap(ratelimits.Delete(apiRateLimits)),
+		},
+	}
+
+	return r
+}
@@ -0,0 +1,276 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+// Package ratelimit limits the rate of API requests with token buckets
+package ratelimit
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DEFAULT is the group of the limit of the groups without one
+const DEFAULT = "default"
+
+// buckets full again are dropped at most once per sweepInterval
+const sweepInterval = time.Minute
+
+// Limit is a token bucket refilled by Rate tokens per second up to Burst,
+// a request takes a token. A zero Rate is no limit.
+type Limit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+func (l Limit) String() string {
+	return strconv.FormatFloat(l.Rate, 'f', -1, 64) + ":" + strconv.Itoa(l.Burst)
+}
+
+// Limits are the limits of route groups, as "group=rate:burst" in a flag
+type Limits map[string]Limit
+
+func (l Limits) String() string {
+	groups := []string{}
+	for group, limit := range l {
+		groups = append(groups, group+"="+limit.String())
+	}
+	sort.Strings(groups)
+
+	return strings.Join(groups, ",")
+}
+
+// Set sets the limits of a comma separated list of "group=rate:burst"
+func (l Limits) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		fields := strings.SplitN(item, "=", 2)
+		if len(fields) != 2 || fields[0] == "" {
+			return fmt.Errorf("Bad rate limit %q, expected group=rate:burst", item)
+		}
+
+		values := strings.SplitN(fields[1], ":", 2)
+		if len(values) != 2 {
+			return fmt.Errorf("Bad rate limit %q, expected group=rate:burst", item)
+		}
+
+		rate, err := strconv.ParseFloat(values[0], 64)
+		if err != nil || rate < 0 || math.IsInf(rate, 0) || math.IsNaN(rate) {
+			return fmt.Errorf("Bad rate of the rate limit %q", item)
+		}
+
+		burst, err := strconv.Atoi(values[1])
+		if err != nil || (burst < 1 && rate > 0) {
+			return fmt.Errorf("Bad burst of the rate limit %q", item)
+		}
+
+		l[fields[0]] = Limit{Rate: rate, Burst: burst}
+	}
+
+	return nil
+}
+
+// Of returns the limit of a group
+func (l Limits) Of(group string) Limit {
+	if limit, ok := l[group]; ok {
+		return limit
+	}
+
+	return l[DEFAULT]
+}
+
+// Flag defines a flag of limits with the defaults, the flag sets more
+// groups or replaces their defaults
+func Flag(name string, defaults Limits, usage string) Limits {
+	limits := Limits{}
+	for group, limit := range defaults {
+		limits[group] = limit
+	}
+
+	flag.Var(limits, name, usage)
+	return limits
+}
+
+// Bucket is the state of the bucket of a key
+type Bucket struct {
+	Key    string  `json:"key"`
+	Tokens float64 `json:"tokens"`
+	// requests rejected since the bucket was full
+	Rejected uint64    `json:"rejected"`
+	Last     time.Time `json:"last"`
+}
+
+// Limiter holds token buckets of the same limit by key
+type Limiter struct {
+	mu        sync.Mutex
+	limit     Limit
+	buckets   map[string]*Bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// New returns a limiter of limit
+func New(limit Limit) *Limiter {
+	return &Limiter{
+		limit:   limit,
+		buckets: make(map[string]*Bucket),
+		now:     time.Now,
+	}
+}
+
+// Limit returns the limit of the limiter
+func (l *Limiter) Limit() Limit {
+	return l.limit
+}
+
+// Allow takes a token of the bucket of key, it tells whether there was one
+// and if not how long until there is
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l.limit.Rate <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &Bucket{Key: key, Tokens: float64(l.limit.Burst), Last: now}
+		l.buckets[key] = bucket
+	}
+	l.refill(bucket, now)
+
+	if bucket.Tokens >= 1 {
+		bucket.Tokens--
+		return true, 0
+	}
+
+	bucket.Rejected++
+	wait := (1 - bucket.Tokens) / l.limit.Rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// Buckets returns the buckets not full, by key
+func (l *Limiter) Buckets() []Bucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	buckets := []Bucket{}
+	for _, bucket := range l.buckets {
+		l.refill(bucket, now)
+		if bucket.Tokens < float64(l.limit.Burst) {
+			buckets = append(buckets, *bucket)
+		}
+	}
+
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Key < buckets[j].Key })
+	return buckets
+}
+
+// Reset refills the bucket of key, it tells whether there was one
+func (l *Limiter) Reset(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, ok := l.buckets[key]
+	delete(l.buckets, key)
+	return ok
+}
+
+func (l *Limiter) refill(bucket *Bucket, now time.Time) {
+	elapsed := now.Sub(bucket.Last).Seconds()
+	if elapsed > 0 {
+		bucket.Tokens = math.Min(float64(l.limit.Burst), bucket.Tokens+elapsed*l.limit.Rate)
+		bucket.Last = now
+	}
+	if bucket.Tokens >= float64(l.limit.Burst) {
+		bucket.Rejected = 0
+	}
+}
+
+// sweep drops the buckets full again, they are as good as new ones
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for key, bucket := range l.buckets {
+		l.refill(bucket, now)
+		if bucket.Tokens >= float64(l.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Group is the state of the limiter of a route group
+type Group struct {
+	Limit   Limit    `json:"limit"`
+	Buckets []Bucket `json:"buckets"`
+}
+
+// Registry holds a limiter per route group
+type Registry struct {
+	mu       sync.Mutex
+	limits   Limits
+	limiters map[string]*Limiter
+}
+
+// NewRegistry returns a registry giving the groups their limit in limits
+func NewRegistry(limits Limits) *Registry {
+	return &Registry{limits: limits, limiters: make(map[string]*Limiter)}
+}
+
+// Limiter returns the limiter of a group
+func (r *Registry) Limiter(group string) *Limiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	limiter, ok := r.limiters[group]
+	if !ok {
+		limiter = New(r.limits.Of(group))
+		r.limiters[group] = limiter
+	}
+
+	return limiter
+}
+
+// State returns the limiters of the groups
+func (r *Registry) State() map[string]Group {
+	r.mu.Lock()
+	limiters := make(map[string]*Limiter, len(r.limiters))
+	for group, limiter := range r.limiters {
+		limiters[group] = limiter
+	}
+	r.mu.Unlock()
+
+	state := make(map[string]Group)
+	for group, limiter := range limiters {
+		state[group] = Group{Limit: limiter.Limit(), Buckets: limiter.Buckets()}
+	}
+
+	return state
+}
+
+// Reset refills the buckets of key in every group, it tells whether there
+// were any
+func (r *Registry) Reset(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	found := false
+	for _, limiter := range r.limiters {
+		if limiter.Reset(key) {
+			found = true
+		}
+	}
+
+	return found
+}
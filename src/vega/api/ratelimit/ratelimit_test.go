@@ -0,0 +1,90 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	clock := time.Unix(1500000000, 0)
+	limiter := New(Limit{Rate: 2, Burst: 3})
+	limiter.now = func() time.Time { return clock }
+
+	t.Log("[case] Test burst")
+	for i := 0; i < 3; i++ {
+		if ok, _ := limiter.Allow("source:10.0.0.1"); !ok {
+			t.Error("[err] request", i, "of the burst rejected")
+		}
+	}
+	ok, wait := limiter.Allow("source:10.0.0.1")
+	if ok || wait != 500*time.Millisecond {
+		t.Error("[err] request over the burst:", ok, wait)
+	}
+	if ok, _ := limiter.Allow("source:10.0.0.2"); !ok {
+		t.Error("[err] other key rejected")
+	}
+
+	t.Log("[case] Test refill")
+	clock = clock.Add(time.Second)
+	for i := 0; i < 2; i++ {
+		if ok, _ := limiter.Allow("source:10.0.0.1"); !ok {
+			t.Error("[err] refilled request", i, "rejected")
+		}
+	}
+	if ok, _ := limiter.Allow("source:10.0.0.1"); ok {
+		t.Error("[err] request over the refill accepted")
+	}
+
+	buckets := limiter.Buckets()
+	if len(buckets) != 1 || buckets[0].Key != "source:10.0.0.1" || buckets[0].Rejected != 2 {
+		t.Error("[err] buckets:", buckets)
+	}
+
+	t.Log("[case] Test sweep of full buckets")
+	clock = clock.Add(sweepInterval)
+	limiter.Allow("source:10.0.0.3")
+	if buckets := limiter.Buckets(); len(buckets) != 1 || len(limiter.buckets) != 1 {
+		t.Error("[err] buckets after sweep:", buckets)
+	}
+
+	t.Log("[case] Test no limit")
+	unlimited := New(Limit{})
+	for i := 0; i < 100; i++ {
+		if ok, _ := unlimited.Allow("user:test"); !ok {
+			t.Fatal("[err] request rejected without limit")
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	t.Log("[case] Test flag value")
+	limits := Limits{DEFAULT: {Rate: 10, Burst: 30}}
+	if err := limits.Set("sessions=0.5:5,tasks=0:0"); err != nil {
+		t.Error("[err] set:", err)
+	}
+	if limits.Of("sessions") != (Limit{0.5, 5}) || limits.Of("tasks") != (Limit{}) || limits.Of("ldap") != (Limit{10, 30}) {
+		t.Error("[err] limits:", limits)
+	}
+	if limits.String() != "default=10:30,sessions=0.5:5,tasks=0:0" {
+		t.Error("[err] string:", limits.String())
+	}
+
+	for _, bad := range []string{"sessions", "=1:1", "sessions=1", "sessions=-1:1", "sessions=1:0", "sessions=x:1"} {
+		if err := limits.Set(bad); err == nil {
+			t.Error("[err] bad limit accepted:", bad)
+		}
+	}
+
+	t.Log("[case] Test registry")
+	registry := NewRegistry(limits)
+	if registry.Limiter("sessions") != registry.Limiter("sessions") || registry.Limiter("ldap").Limit() != (Limit{10, 30}) {
+		t.Error("[err] limiters of the groups")
+	}
+	registry.Limiter("ldap").Allow("user:test")
+	if state := registry.State(); len(state) != 2 || len(state["ldap"].Buckets) != 1 {
+		t.Error("[err] state:", state)
+	}
+	if !registry.Reset("user:test") || registry.Reset("user:test") {
+		t.Error("[err] reset")
+	}
+}
@@ -0,0 +1,26 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+package main
+
+import (
+	"vega/api/handlers"
+	"github.com/htbig/common/src/vega/api/handlers/sessions"
+)
+
+func sessionRoutes(ctx handlers.Context) map[string]map[string]handler {
+	// the session handlers authenticate by themselves
+	public := newChain(ctx)
+	public.add(wrapRateLimit("sessions"))
+
+	r := map[string]map[string]handler{
+		"POST": {
+			"/sessions":         public.wrap(sessions.Login(apiSessions)),
+			"/sessions/refresh": public.wrap(sessions.Refresh(apiSessions)),
+		},
+		"DELETE": {
+			"/sessions": public.wrap(sessions.Logout(apiSessions)),
+		},
+	}
+
+	return r
+}
@@ -0,0 +1,343 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+// Package sessions issues and verifies the bearer tokens of API sessions
+package sessions
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultTTL = 30 * time.Minute
+
+	keySize = 32
+	idSize  = 16
+)
+
+// Events passed to a Hook
+const (
+	EVENT_START = "start"
+	// the user logged out
+	EVENT_LOGOUT  = "logout"
+	EVENT_EXPIRED = "expired"
+	// the session was replaced by a refreshed one
+	EVENT_REFRESHED = "refreshed"
+	// the session was ended by an administrator
+	EVENT_DISCONNECTED = "disconnected"
+)
+
+var (
+	ErrMalformed = errors.New("Malformed session token")
+	ErrSignature = errors.New("Invalid session token signature")
+	ErrExpired   = errors.New("Session expired")
+	ErrRevoked   = errors.New("Session revoked")
+)
+
+// Session is an authenticated API login
+type Session struct {
+	ID         string    `json:"-"`
+	Username   string    `json:"username"`
+	Method     string    `json:"method"`
+	Privileged bool      `json:"privileged"`
+	Roles      []string  `json:"roles,omitempty"`
+	Remote     string    `json:"remote,omitempty"`
+	Issued     time.Time `json:"issued"`
+	Expires    time.Time `json:"expires"`
+	// ID of the first session of the login, kept when the token is
+	// refreshed
+	Login string `json:"-"`
+}
+
+type claims struct {
+	ID         string `json:"jti"`
+	Username   string `json:"sub"`
+	Privileged bool   `json:"priv"`
+	Issued     int64  `json:"iat"`
+	Expires    int64  `json:"exp"`
+}
+
+// Hook is told when a session starts or ends. It runs outside of the
+// manager lock, on the goroutine that caused the event.
+type Hook func(s Session, event string)
+
+// Manager signs tokens with a key generated at start-up, so every session
+// ends when the process restarts. The manager also keeps the live sessions,
+// which makes the server's view authoritative: a revoked or expired session
+// is refused even though its token still carries a valid signature.
+type Manager struct {
+	key []byte
+	ttl time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+	hook     Hook
+}
+
+// New returns a manager issuing sessions that last ttl
+func New(ttl time.Duration) (*Manager, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	m := &Manager{
+		key:      key,
+		ttl:      ttl,
+		sessions: make(map[string]*Session),
+	}
+
+	return m, nil
+}
+
+// SetHook sets the function told about session starts and ends
+func (m *Manager) SetHook(h Hook) {
+	m.mu.Lock()
+	m.hook = h
+	m.mu.Unlock()
+}
+
+func (m *Manager) notify(hook Hook, event string, sessions ...Session) {
+	if hook == nil {
+		return
+	}
+
+	for _, s := range sessions {
+		hook(s, event)
+	}
+}
+
+// Issue starts a session for the user described by template and returns its
+// token. The ID and times of template are ignored, its Login is kept if set.
+func (m *Manager) Issue(template Session) (string, Session, error) {
+	id := make([]byte, idSize)
+	if _, err := rand.Read(id); err != nil {
+		return "", Session{}, err
+	}
+
+	now := time.Now()
+	s := &Session{
+		ID:         hex.EncodeToString(id),
+		Login:      template.Login,
+		Username:   template.Username,
+		Method:     template.Method,
+		Privileged: template.Privileged,
+		Roles:      append([]string{}, template.Roles...),
+		Remote:     template.Remote,
+		Issued:     now,
+		Expires:    now.Add(m.ttl),
+	}
+	if s.Login == "" {
+		s.Login = s.ID
+	}
+
+	token, err := m.sign(claims{
+		ID:         s.ID,
+		Username:   s.Username,
+		Privileged: s.Privileged,
+		Issued:     s.Issued.Unix(),
+		Expires:    s.Expires.Unix(),
+	})
+	if err != nil {
+		return "", Session{}, err
+	}
+
+	m.mu.Lock()
+	expired := m.sweep(now)
+	m.sessions[s.ID] = s
+	hook := m.hook
+	m.mu.Unlock()
+
+	m.notify(hook, EVENT_EXPIRED, expired...)
+	m.notify(hook, EVENT_START, *s)
+
+	return token, *s, nil
+}
+
+// Verify checks the token and returns its live session
+func (m *Manager) Verify(token string) (Session, error) {
+	c, err := m.parse(token)
+	if err != nil {
+		return Session{}, err
+	}
+
+	m.mu.Lock()
+	s, ok := m.sessions[c.ID]
+	if !ok {
+		m.mu.Unlock()
+		return Session{}, ErrRevoked
+	}
+
+	session := *s
+	session.Roles = append([]string{}, s.Roles...)
+
+	if time.Now().After(s.Expires) {
+		delete(m.sessions, c.ID)
+		hook := m.hook
+		m.mu.Unlock()
+
+		m.notify(hook, EVENT_EXPIRED, session)
+		return Session{}, ErrExpired
+	}
+
+	m.mu.Unlock()
+	return session, nil
+}
+
+// Refresh replaces a valid token by a new one with a fresh lifetime
+func (m *Manager) Refresh(token string) (string, Session, error) {
+	s, err := m.Verify(token)
+	if err != nil {
+		return "", Session{}, err
+	}
+
+	m.end(s.ID, EVENT_REFRESHED)
+
+	return m.Issue(s)
+}
+
+// Revoke ends the session with the given ID as a logout
+func (m *Manager) Revoke(id string) {
+	m.end(id, EVENT_LOGOUT)
+}
+
+func (m *Manager) end(id, event string) {
+	m.mu.Lock()
+	s, ok := m.sessions[id]
+	delete(m.sessions, id)
+	hook := m.hook
+	m.mu.Unlock()
+
+	if ok {
+		m.notify(hook, event, *s)
+	}
+}
+
+// match tells whether s is the session id of username, username and id may
+// be left empty but not both
+func (s *Session) match(username, id string) bool {
+	if username == "" && id == "" {
+		return false
+	}
+	return (username == "" || s.Username == username) && (id == "" || s.ID == id)
+}
+
+// Disconnect ends the sessions of username, or the one with ID id, and
+// returns how many it ended
+func (m *Manager) Disconnect(username, id string) int {
+	ended := []Session{}
+
+	m.mu.Lock()
+	for key, s := range m.sessions {
+		if s.match(username, id) {
+			ended = append(ended, *s)
+			delete(m.sessions, key)
+		}
+	}
+	hook := m.hook
+	m.mu.Unlock()
+
+	m.notify(hook, EVENT_DISCONNECTED, ended...)
+	return len(ended)
+}
+
+// Update sets the privilege and roles of the sessions of username, or the one
+// with ID id, and returns how many it updated
+func (m *Manager) Update(username, id string, privileged bool, roles []string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	updated := 0
+	for _, s := range m.sessions {
+		if s.match(username, id) {
+			s.Privileged = privileged
+			s.Roles = append([]string{}, roles...)
+			updated++
+		}
+	}
+
+	return updated
+}
+
+// sweep drops and returns the expired sessions, m.mu must be held
+func (m *Manager) sweep(now time.Time) []Session {
+	expired := []Session{}
+	for id, s := range m.sessions {
+		if now.After(s.Expires) {
+			expired = append(expired, *s)
+			delete(m.sessions, id)
+		}
+	}
+	return expired
+}
+
+func (m *Manager) sign(c claims) (string, error) {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(m.mac(encoded)), nil
+}
+
+func (m *Manager) parse(token string) (claims, error) {
+	var c claims
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return c, ErrMalformed
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return c, ErrMalformed
+	}
+
+	if !hmac.Equal(signature, m.mac(parts[0])) {
+		return c, ErrSignature
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return c, ErrMalformed
+	}
+
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return c, ErrMalformed
+	}
+
+	if time.Now().Unix() > c.Expires {
+		return c, ErrExpired
+	}
+
+	return c, nil
+}
+
+func (m *Manager) mac(payload string) []byte {
+	h := hmac.New(sha256.New, m.key)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+// BearerToken returns the token of an "Authorization: Bearer" header
+func BearerToken(authorization string) (string, bool) {
+	const prefix = "Bearer "
+	if len(authorization) <= len(prefix) || !strings.EqualFold(authorization[:len(prefix)], prefix) {
+		return "", false
+	}
+
+	return strings.TrimSpace(authorization[len(prefix):]), true
+}
@@ -0,0 +1,132 @@
+package sessions
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessions(t *testing.T) {
+	m, err := New(time.Minute)
+	if err != nil {
+		t.Fatal("[err] New:", err)
+	}
+
+	t.Log("[case] Test issue and verify")
+	token, issued, err := m.Issue(Session{Username: "admin", Privileged: true, Roles: []string{"admin"}})
+	if err != nil {
+		t.Fatal("[err] Issue:", err)
+	}
+
+	session, err := m.Verify(token)
+	if err != nil || session.Username != "admin" || !session.Privileged || session.ID != issued.ID || len(session.Roles) != 1 {
+		t.Error("[err] Verify:", session, err)
+	}
+
+	t.Log("[case] Test tampered token")
+	parts := strings.Split(token, ".")
+	flip := "A"
+	if strings.HasSuffix(parts[0], flip) {
+		flip = "B"
+	}
+	forged := parts[0][:len(parts[0])-1] + flip + "." + parts[1]
+	if _, err := m.Verify(forged); err == nil {
+		t.Error("[err] tampered token accepted")
+	}
+
+	other, _ := New(time.Minute)
+	if _, err := other.Verify(token); err != ErrSignature {
+		t.Error("[err] token of another key:", err)
+	}
+
+	t.Log("[case] Test refresh")
+	refreshed, _, err := m.Refresh(token)
+	if err != nil {
+		t.Fatal("[err] Refresh:", err)
+	}
+	if _, err := m.Verify(token); err != ErrRevoked {
+		t.Error("[err] refreshed token still valid:", err)
+	}
+	if s, err := m.Verify(refreshed); err != nil {
+		t.Error("[err] new token:", err)
+	} else if s.Login != session.Login || s.ID == session.ID {
+		t.Error("[err] login of the refreshed session:", s.Login, session.Login)
+	}
+
+	t.Log("[case] Test revoke")
+	session, _ = m.Verify(refreshed)
+	m.Revoke(session.ID)
+	if _, err := m.Verify(refreshed); err != ErrRevoked {
+		t.Error("[err] revoked token:", err)
+	}
+
+	t.Log("[case] Test expiry")
+	short, _ := New(time.Nanosecond)
+	token, _, _ = short.Issue(Session{Username: "user"})
+	time.Sleep(time.Millisecond)
+	if _, err := short.Verify(token); err != ErrExpired {
+		t.Error("[err] expired token:", err)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	t.Log("[case] Test parse Authorization header")
+
+	if token, ok := BearerToken("Bearer abc.def"); !ok || token != "abc.def" {
+		t.Error("[err] bearer:", token, ok)
+	}
+	if _, ok := BearerToken("Basic YWRtaW46YWRtaW4="); ok {
+		t.Error("[err] basic header taken as bearer")
+	}
+	if _, ok := BearerToken("Bearer "); ok {
+		t.Error("[err] empty bearer accepted")
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	m, _ := New(time.Minute)
+
+	events := []string{}
+	m.SetHook(func(s Session, event string) {
+		events = append(events, s.Username+" "+event)
+	})
+
+	admin, _, _ := m.Issue(Session{Username: "admin", Privileged: true})
+	_, other, _ := m.Issue(Session{Username: "admin"})
+	user, _, _ := m.Issue(Session{Username: "user"})
+
+	t.Log("[case] Test update privilege")
+	if n := m.Update("admin", "", false, []string{"auditor"}); n != 2 {
+		t.Error("[err] updated:", n)
+	}
+	if s, _ := m.Verify(admin); s.Privileged || len(s.Roles) != 1 || s.Roles[0] != "auditor" {
+		t.Error("[err] update not applied:", s)
+	}
+
+	t.Log("[case] Test disconnect by session ID")
+	if n := m.Disconnect("", other.ID); n != 1 {
+		t.Error("[err] disconnected:", n)
+	}
+	if _, err := m.Verify(admin); err != nil {
+		t.Error("[err] other session of user ended:", err)
+	}
+
+	t.Log("[case] Test disconnect by user")
+	if n := m.Disconnect("admin", ""); n != 1 {
+		t.Error("[err] disconnected:", n)
+	}
+	if _, err := m.Verify(admin); err != ErrRevoked {
+		t.Error("[err] disconnected token:", err)
+	}
+	if _, err := m.Verify(user); err != nil {
+		t.Error("[err] session of another user ended:", err)
+	}
+
+	if n := m.Disconnect("", ""); n != 0 {
+		t.Error("[err] empty match disconnected:", n)
+	}
+
+	if len(events) != 5 || events[3] != "admin disconnected" || events[4] != "admin disconnected" {
+		t.Error("[err] events:", events)
+	}
+}
@@ -0,0 +1,28 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+package main
+
+import (
+	"vega/api/handlers"
+	"github.com/htbig/common/src/vega/api/handlers/tasks"
+)
+
+func taskRoutes(ctx handlers.Context) map[string]map[string]handler {
+	user := newChain(ctx)
+	user.add(wrapRateLimit("tasks"), wrapAuth(false), wrapUserRateLimit("tasks"))
+
+	admin := newChain(ctx)
+	admin.add(wrapRateLimit("tasks"), wrapAuth(true), wrapUserRateLimit("tasks"))
+
+	r := map[string]map[string]handler{
+		"GET": {
+			"/tasks":            user.wrap(tasks.List),
+			"/tasks/:id/events": user.wrap(tasks.Events),
+		},
+		"DELETE": {
+			"/tasks/:id": admin.wrap(tasks.Delete),
+		},
+	}
+
+	return r
+}
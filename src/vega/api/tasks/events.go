@@ -0,0 +1,122 @@
+package tasks
+
+import "sync"
+
+const (
+	EVENT_STATE    = "state"
+	EVENT_PROGRESS = "progress"
+
+	// events buffered per subscriber before the oldest ones are dropped
+	eventBuffer = 16
+)
+
+// Event is a snapshot of a task taken when its state or progress changed
+type Event struct {
+	Type     string      `json:"type"`
+	ID       string      `json:"id"`
+	State    State       `json:"state"`
+	Progress float32     `json:"progress"`
+	Error    string      `json:"error,omitempty"`
+	Data     interface{} `json:"data,omitempty"`
+}
+
+// IsFinal reports whether no event will follow this one for the task
+func (e Event) IsFinal() bool {
+	return isDone(e.State)
+}
+
+type subscription struct {
+	id     string
+	events chan Event
+}
+
+type broker struct {
+	mu   sync.Mutex
+	subs map[*subscription]struct{}
+}
+
+// Subscribe returns a channel receiving the events of the task with the given
+// ID, or of every task if id is empty, and a function that ends the
+// subscription and closes the channel. A subscriber that falls behind loses
+// its oldest events, never the latest one.
+func (m *Manager) Subscribe(id string) (<-chan Event, func()) {
+	s := &subscription{id: id, events: make(chan Event, eventBuffer)}
+
+	m.events.mu.Lock()
+	if m.events.subs == nil {
+		m.events.subs = make(map[*subscription]struct{})
+	}
+	m.events.subs[s] = struct{}{}
+	m.events.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			m.events.mu.Lock()
+			delete(m.events.subs, s)
+			close(s.events)
+			m.events.mu.Unlock()
+		})
+	}
+
+	return s.events, cancel
+}
+
+func (b *broker) publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for s := range b.subs {
+		if s.id != "" && s.id != e.ID {
+			continue
+		}
+
+		select {
+		case s.events <- e:
+		default:
+			// make room by dropping the oldest event; only the
+			// subscriber competes with us for the channel
+			select {
+			case <-s.events:
+			default:
+			}
+			s.events <- e
+		}
+	}
+}
+
+// Event returns the current state of the task as an event
+func (t *Task) Event() Event {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.event(EVENT_STATE)
+}
+
+func (t *Task) event(eventType string) Event {
+	e := Event{
+		Type:     eventType,
+		ID:       t.id,
+		State:    t.state,
+		Progress: t.progress,
+		Data:     t.data,
+	}
+
+	if t.err != nil {
+		e.Error = t.err.Error()
+	}
+
+	return e
+}
+
+func (t *Task) publish(eventType string) {
+	if t.manager == nil {
+		return
+	}
+
+	t.mu.RLock()
+	e := t.event(eventType)
+	t.mu.RUnlock()
+
+	t.manager.events.publish(e)
+}
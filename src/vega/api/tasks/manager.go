@@ -1,42 +1,191 @@
 package tasks
 
-import "strconv"
+import (
+	"strconv"
+	"sync"
+	"time"
 
+	"github.com/htbig/common/src/vega/syslogger"
+)
+
+// Manager owns tasks and runs at most MaxRunning of them at a time; the
+// others stay WAITING in start order. It is safe for concurrent use.
 type Manager struct {
-	nextID chan string
-	tasks  map[string]*Task
+	mu         sync.Mutex
+	seq        uint64
+	tasks      map[string]*Task
+	store      Store
+	queue      []*Task
+	running    int
+	maxRunning int
+	events     broker
+	retention  Retention
 }
 
+// Clear stops and forgets every task
 func (m *Manager) Clear() {
-	m.nextID = make(chan string)
+	m.mu.Lock()
+	old := m.tasks
 	m.tasks = make(map[string]*Task)
-	go func() {
-		for id := uint64(0); ; id++ {
-			m.nextID <- strconv.FormatUint(id, 10)
+	m.mu.Unlock()
+
+	for id, t := range old {
+		t.Stop()
+		t.detach()
+		if m.store != nil {
+			if err := m.store.Delete(id); err != nil {
+				syslogger.Err("tasks: delete record", id, err)
+			}
 		}
-	}()
+	}
 }
 
 func (m *Manager) Get(id string) *Task {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	return m.tasks[id]
 }
 
 func (m *Manager) Delete(id string) {
+	m.mu.Lock()
+	t, ok := m.tasks[id]
 	delete(m.tasks, id)
+	m.mu.Unlock()
+
+	if ok {
+		// a stopping task would save its record again once stopped
+		t.detach()
+	}
+
+	if ok && m.store != nil {
+		if err := m.store.Delete(id); err != nil {
+			syslogger.Err("tasks: delete record", id, err)
+		}
+	}
 }
 
-func (m *Manager) New(r func(chan Pipe, chan struct{}) error) *Task {
+func (m *Manager) New(r Runner) *Task {
 	t := new(Task)
-	t.id = <-m.nextID
 	t.run = r
 	t.state = WAITING
+	t.manager = m
+	t.store = m.store
+
+	m.mu.Lock()
+	t.id = strconv.FormatUint(m.seq, 10)
+	m.seq++
 	m.tasks[t.id] = t
+	if m.store != nil {
+		if err := m.store.SaveNextID(m.seq); err != nil {
+			syslogger.Err("tasks: save next id", err)
+		}
+	}
+	m.mu.Unlock()
+
+	t.persist()
 	return t
 }
 
+// SetMaxRunning limits how many tasks run at once; n <= 0 means no limit
+func (m *Manager) SetMaxRunning(n int) {
+	m.mu.Lock()
+	m.maxRunning = n
+	m.mu.Unlock()
+
+	m.dispatch()
+}
+
+// Running returns the number of tasks currently running
+func (m *Manager) Running() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.running
+}
+
+func (m *Manager) enqueue(t *Task) {
+	m.mu.Lock()
+	m.queue = append(m.queue, t)
+	m.mu.Unlock()
+
+	m.dispatch()
+}
+
+// dispatch starts queued tasks while there are free slots. Lock order is
+// always Manager.mu before Task.mu.
+func (m *Manager) dispatch() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for len(m.queue) > 0 && (m.maxRunning <= 0 || m.running < m.maxRunning) {
+		t := m.queue[0]
+		m.queue[0] = nil
+		m.queue = m.queue[1:]
+
+		ctx, run, ok := t.begin()
+		if !ok {
+			// stopped while waiting
+			continue
+		}
+
+		m.running++
+		go func() {
+			t.execute(ctx, run)
+
+			m.mu.Lock()
+			m.running--
+			m.mu.Unlock()
+
+			m.dispatch()
+		}()
+	}
+}
+
 // NewManager returns a new task manager
 func NewManager() *Manager {
 	m := Manager{}
-	m.Clear()
+	m.tasks = make(map[string]*Task)
 	return &m
 }
+
+// NewPersistentManager returns a task manager that records every task in
+// store and reloads the tasks left by a previous process. Tasks that had not
+// finished are marked as failed with ErrInterrupted.
+func NewPersistentManager(store Store) (*Manager, error) {
+	m := NewManager()
+	m.store = store
+
+	seq, err := store.LoadNextID()
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	// never hand out an ID again, even if the sequence file is stale
+	for _, record := range records {
+		if id, err := strconv.ParseUint(record.ID, 10, 64); err == nil && id >= seq {
+			seq = id + 1
+		}
+	}
+	m.seq = seq
+
+	for _, record := range records {
+		t := restore(record)
+		t.manager = m
+		t.store = store
+		if !isDone(t.state) {
+			t.state = FAILED
+			t.err = ErrInterrupted
+			t.finished = time.Now()
+			t.persist()
+		}
+		m.tasks[t.id] = t
+	}
+
+	return m, nil
+}
@@ -0,0 +1,120 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+)
+
+// SKIPPED is the state of a pipeline step that never ran because an earlier
+// step failed or the pipeline was stopped
+const SKIPPED State = "skipped"
+
+// Step is one stage of a pipeline. Weight is the share of the pipeline
+// progress the step accounts for; zero counts as 1.
+type Step struct {
+	Description string
+	Weight      float32
+	Run         Runner
+}
+
+// StepStatus is the state of a single step as reported in PipelineData
+type StepStatus struct {
+	Description string      `json:"description"`
+	State       State       `json:"state"`
+	Progress    float32     `json:"progress"`
+	Error       string      `json:"error,omitempty"`
+	Data        interface{} `json:"data,omitempty"`
+}
+
+// PipelineData is the task data reported by a pipeline
+type PipelineData struct {
+	Current int          `json:"current"`
+	Steps   []StepStatus `json:"steps"`
+}
+
+// Pipeline returns a runner that runs steps one after another. The task
+// progress is the weighted sum of the step progress. The first failing step
+// fails the pipeline and the steps after it are skipped; stopping the task
+// cancels whichever step is running.
+func Pipeline(steps ...Step) Runner {
+	return func(ctx context.Context, pipe chan<- Pipe) error {
+		var total, done float32
+		status := make([]StepStatus, len(steps))
+		for i, step := range steps {
+			total += weight(step)
+			status[i].Description = step.Description
+			status[i].State = WAITING
+		}
+
+		report := func(current int, progress float32) {
+			data := PipelineData{Current: current, Steps: make([]StepStatus, len(status))}
+			copy(data.Steps, status)
+			pipe <- Pipe{Progress: progress / total, Data: data}
+		}
+
+		skip := func(from int) {
+			for i := from; i < len(status); i++ {
+				status[i].State = SKIPPED
+			}
+		}
+
+		for i, step := range steps {
+			if err := ctx.Err(); err != nil {
+				skip(i)
+				report(i, done)
+				return err
+			}
+
+			status[i].State = RUNNING
+			report(i, done)
+
+			err := runStep(ctx, step, func(p Pipe) {
+				status[i].Progress = p.Progress
+				status[i].Data = p.Data
+				report(i, done+p.Progress*weight(step))
+			})
+
+			if err != nil {
+				status[i].Error = err.Error()
+				if ctx.Err() != nil {
+					status[i].State = STOPPED
+				} else {
+					status[i].State = FAILED
+				}
+				skip(i + 1)
+				report(i, done)
+				return fmt.Errorf("%s: %s", step.Description, err.Error())
+			}
+
+			done += weight(step)
+			status[i].State = COMPLETED
+			status[i].Progress = 1
+			report(i, done)
+		}
+
+		return nil
+	}
+}
+
+func weight(step Step) float32 {
+	if step.Weight <= 0 {
+		return 1
+	}
+	return step.Weight
+}
+
+func runStep(ctx context.Context, step Step, progress func(Pipe)) error {
+	var err error
+	pc := make(chan Pipe)
+
+	go func() {
+		defer close(pc)
+		err = step.Run(ctx, pc)
+	}()
+
+	for p := range pc {
+		progress(p)
+	}
+
+	return err
+}
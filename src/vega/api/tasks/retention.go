@@ -0,0 +1,182 @@
+package tasks
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/htbig/common/src/vega/syslogger"
+)
+
+// Retention bounds how many finished tasks a manager keeps and for how long.
+// A zero field means no limit.
+type Retention struct {
+	MaxFinished int
+	MaxAge      time.Duration
+}
+
+// Filter selects tasks in List. Empty fields match every task.
+type Filter struct {
+	States      []State
+	Description string
+}
+
+// Info is a listing entry for a task
+type Info struct {
+	ID          string     `json:"id"`
+	Description string     `json:"description"`
+	State       State      `json:"state"`
+	Progress    float32    `json:"progress"`
+	Error       string     `json:"error,omitempty"`
+	Finished    *time.Time `json:"finished,omitempty"`
+}
+
+func (t *Task) Info() Info {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	info := Info{
+		ID:          t.id,
+		Description: t.Description,
+		State:       t.state,
+		Progress:    t.progress,
+	}
+
+	if t.err != nil {
+		info.Error = t.err.Error()
+	}
+
+	if isDone(t.state) {
+		finished := t.finished
+		info.Finished = &finished
+	}
+
+	return info
+}
+
+func (f Filter) match(t *Task) bool {
+	t.mu.RLock()
+	state := t.state
+	t.mu.RUnlock()
+
+	if len(f.States) > 0 {
+		found := false
+		for _, s := range f.States {
+			if s == state {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return f.Description == "" || strings.Contains(t.Description, f.Description)
+}
+
+// List returns the tasks matching filter ordered by ID
+func (m *Manager) List(filter Filter) []*Task {
+	m.mu.Lock()
+	list := make([]*Task, 0, len(m.tasks))
+	for _, t := range m.tasks {
+		list = append(list, t)
+	}
+	m.mu.Unlock()
+
+	matched := list[:0]
+	for _, t := range list {
+		if filter.match(t) {
+			matched = append(matched, t)
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return idLess(matched[i].id, matched[j].id)
+	})
+
+	return matched
+}
+
+func idLess(a, b string) bool {
+	x, errA := strconv.ParseUint(a, 10, 64)
+	y, errB := strconv.ParseUint(b, 10, 64)
+	if errA != nil || errB != nil {
+		return a < b
+	}
+	return x < y
+}
+
+func (m *Manager) SetRetention(r Retention) {
+	m.mu.Lock()
+	m.retention = r
+	m.mu.Unlock()
+}
+
+// Reap evicts the finished tasks that fall outside the retention policy,
+// oldest first, and returns how many were evicted
+func (m *Manager) Reap() int {
+	type finishedTask struct {
+		id       string
+		finished time.Time
+	}
+
+	now := time.Now()
+
+	m.mu.Lock()
+	retention := m.retention
+	finished := []finishedTask{}
+	for id, t := range m.tasks {
+		t.mu.RLock()
+		if isDone(t.state) {
+			finished = append(finished, finishedTask{id, t.finished})
+		}
+		t.mu.RUnlock()
+	}
+
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].finished.Before(finished[j].finished)
+	})
+
+	evict := []string{}
+	for i, f := range finished {
+		expired := retention.MaxAge > 0 && now.Sub(f.finished) > retention.MaxAge
+		overflow := retention.MaxFinished > 0 && len(finished)-i > retention.MaxFinished
+		if expired || overflow {
+			evict = append(evict, f.id)
+			delete(m.tasks, f.id)
+		}
+	}
+	m.mu.Unlock()
+
+	if m.store != nil {
+		for _, id := range evict {
+			if err := m.store.Delete(id); err != nil {
+				syslogger.Err("tasks: delete record", id, err)
+			}
+		}
+	}
+
+	return len(evict)
+}
+
+// StartReaper calls Reap every interval until the returned function is called
+func (m *Manager) StartReaper(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.Reap()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
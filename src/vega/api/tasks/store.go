@@ -0,0 +1,151 @@
+package tasks
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/htbig/common/src/vega/syslogger"
+)
+
+const (
+	recordSuffix = ".json"
+	nextIDFile   = "next_id"
+)
+
+// ErrInterrupted is the error given to tasks that were still pending when
+// the process went down
+var ErrInterrupted = errors.New("interrupted by restart")
+
+// Record is the persisted form of a task
+type Record struct {
+	ID          string          `json:"id"`
+	Description string          `json:"description"`
+	State       State           `json:"state"`
+	Progress    float32         `json:"progress"`
+	Error       string          `json:"error,omitempty"`
+	Data        json.RawMessage `json:"data,omitempty"`
+	Finished    time.Time       `json:"finished"`
+}
+
+// Store is a persistence backend for task records and the task ID sequence
+type Store interface {
+	Load() ([]Record, error)
+	Save(record Record) error
+	Delete(id string) error
+	LoadNextID() (uint64, error)
+	SaveNextID(id uint64) error
+}
+
+// FileStore keeps one JSON file per task under a directory
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a store rooted at dir, creating the directory if needed
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) Load() ([]Record, error) {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []Record{}
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), recordSuffix) {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(s.dir, info.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		var record Record
+		if err := json.Unmarshal(data, &record); err != nil {
+			// skip corrupted records instead of refusing to start
+			syslogger.Err("tasks: bad record", info.Name(), err)
+			continue
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+func (s *FileStore) Save(record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.recordPath(record.ID), data)
+}
+
+func (s *FileStore) Delete(id string) error {
+	if err := os.Remove(s.recordPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (s *FileStore) LoadNextID() (uint64, error) {
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, nextIDFile))
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+func (s *FileStore) SaveNextID(id uint64) error {
+	return writeFileAtomic(filepath.Join(s.dir, nextIDFile), []byte(strconv.FormatUint(id, 10)))
+}
+
+func (s *FileStore) recordPath(id string) string {
+	return filepath.Join(s.dir, filepath.Base(id)+recordSuffix)
+}
+
+// writeFileAtomic replaces path with data so that readers never observe a
+// partially written file
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
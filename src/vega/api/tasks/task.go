@@ -1,5 +1,15 @@
 package tasks
 
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/htbig/common/src/vega/syslogger"
+)
+
 const (
 	WAITING   State = "waiting"
 	RUNNING         = "running"
@@ -9,6 +19,9 @@ const (
 	STOPPED         = "stopped"
 )
 
+// ErrNoRunner is the error of a task started without anything to run
+var ErrNoRunner = errors.New("task has nothing to run")
+
 type State string
 
 type Pipe struct {
@@ -16,15 +29,27 @@ type Pipe struct {
 	Data     interface{}
 }
 
+// Runner does the work of a task. It reports progress on pipe and must
+// return once ctx is cancelled.
+type Runner func(ctx context.Context, pipe chan<- Pipe) error
+
+// Task is safe for concurrent use. Description must be set before the task
+// is started.
 type Task struct {
 	id          string
-	run         func(chan Pipe, chan struct{}) error
-	progress    float32
-	data        interface{}
-	state       State
-	err         error
-	stop        chan struct{}
+	manager     *Manager
+	store       Store
 	Description string
+
+	mu       sync.RWMutex
+	run      Runner
+	progress float32
+	data     interface{}
+	state    State
+	err      error
+	queued   bool
+	cancel   context.CancelFunc
+	finished time.Time
 }
 
 type Status struct {
@@ -33,7 +58,10 @@ type Status struct {
 	Error    string  `json:"error"`
 }
 
-func (t Task) Status() Status {
+func (t *Task) Status() Status {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
 	s := Status{}
 
 	s.Progress = t.progress
@@ -45,7 +73,10 @@ func (t Task) Status() Status {
 	return s
 }
 
-func (t Task) Map() map[string]interface{} {
+func (t *Task) Map() map[string]interface{} {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
 	m := make(map[string]interface{})
 	m["progress"] = t.progress
 	m["state"] = string(t.state)
@@ -55,7 +86,10 @@ func (t Task) Map() map[string]interface{} {
 	return m
 }
 
-func (t Task) MapWithData(dataKey string) map[string]interface{} {
+func (t *Task) MapWithData(dataKey string) map[string]interface{} {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
 	m := make(map[string]interface{})
 	m["progress"] = t.progress
 	m["state"] = string(t.state)
@@ -68,24 +102,40 @@ func (t Task) MapWithData(dataKey string) map[string]interface{} {
 	return m
 }
 
-func (t Task) Data() interface{} {
+func (t *Task) Data() interface{} {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
 	return t.data
 }
 
-func (t Task) ID() string {
+func (t *Task) ID() string {
 	return t.id
 }
 
-func (t Task) Error() error {
+func (t *Task) Error() error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
 	return t.err
 }
 
-func (t Task) IsCompleted() bool {
+func (t *Task) IsCompleted() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
 	return t.state == COMPLETED
 }
 
-func (t Task) IsDone() bool {
-	switch t.state {
+func (t *Task) IsDone() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return isDone(t.state)
+}
+
+func isDone(state State) bool {
+	switch state {
 	case COMPLETED, STOPPED, FAILED:
 		return true
 	default:
@@ -93,50 +143,196 @@ func (t Task) IsDone() bool {
 	}
 }
 
-func (t *Task) SetRun(runner func(chan Pipe, chan struct{}) error) {
+func (t *Task) SetRun(runner Runner) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
 	t.run = runner
 }
 
+// Stop cancels a running task. A task that has not begun yet is stopped
+// right away and will never run.
 func (t *Task) Stop() {
-	select {
-	case t.stop <- struct{}{}:
+	t.mu.Lock()
+
+	switch t.state {
+	case WAITING:
+		t.state = STOPPED
+		t.finished = time.Now()
+		t.queued = false
+		if t.cancel != nil {
+			t.cancel()
+		}
+		t.mu.Unlock()
+		t.changed()
+	case RUNNING:
+		t.state = STOPPING
+		t.cancel()
+		t.mu.Unlock()
+		t.publish(EVENT_STATE)
 	default:
+		t.mu.Unlock()
 	}
-	t.state = STOPPING
 }
 
 func (t *Task) State() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
 	return string(t.state)
 }
 
+// Start queues the task on its manager. It stays WAITING until the manager
+// has a free slot. Starting a task that is already queued or running does
+// nothing; starting a finished task runs it again.
 func (t *Task) Start() {
+	t.mu.Lock()
+
+	if t.queued || t.state == RUNNING || t.state == STOPPING {
+		t.mu.Unlock()
+		return
+	}
+
+	t.progress = 0
+	t.data = nil
+	t.err = nil
+	t.finished = time.Time{}
+
+	if t.run == nil {
+		t.state = FAILED
+		t.err = ErrNoRunner
+		t.finished = time.Now()
+		t.mu.Unlock()
+		t.changed()
+		return
+	}
+
+	t.state = WAITING
+	t.queued = true
+	t.mu.Unlock()
+
+	t.changed()
+	t.manager.enqueue(t)
+}
+
+// begin moves a queued task to RUNNING. It reports false if the task was
+// stopped while it was waiting.
+func (t *Task) begin() (context.Context, Runner, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.queued || t.state != WAITING {
+		return nil, nil, false
+	}
+
+	var ctx context.Context
+	ctx, t.cancel = context.WithCancel(context.Background())
+	t.queued = false
+	t.state = RUNNING
+
+	return ctx, t.run, true
+}
+
+func (t *Task) execute(ctx context.Context, run Runner) {
+	t.changed()
+
+	var err error
+	pc := make(chan Pipe)
+
 	go func() {
-		t.progress = 0
-		t.data = nil
-		t.state = RUNNING
+		defer close(pc)
+		err = run(ctx, pc)
+	}()
 
-		pc := make(chan Pipe)
-		t.stop = make(chan struct{}, 1)
+	for p := range pc {
+		t.mu.Lock()
+		t.progress = p.Progress
+		t.data = p.Data
+		t.mu.Unlock()
+		t.publish(EVENT_PROGRESS)
+	}
 
-		go func() {
-			defer close(pc)
-			t.err = t.run(pc, t.stop)
-		}()
+	t.mu.Lock()
+	t.err = err
+	if t.state == STOPPING {
+		t.state = STOPPED
+	} else if err != nil {
+		t.state = FAILED
+	} else {
+		t.state = COMPLETED
+	}
+	t.finished = time.Now()
+	t.cancel()
+	t.mu.Unlock()
 
-		for p, more := <-pc; more; p, more = <-pc {
-			t.progress = p.Progress
-			t.data = p.Data
-		}
+	t.changed()
+}
+
+// record returns the record of the task, t.mu must be held
+func (t *Task) record() Record {
+	r := Record{
+		ID:          t.id,
+		Description: t.Description,
+		State:       t.state,
+		Progress:    t.progress,
+		Finished:    t.finished,
+	}
+
+	if t.err != nil {
+		r.Error = t.err.Error()
+	}
 
-		if t.state == STOPPING {
-			t.state = STOPPED
+	if t.data != nil {
+		if data, err := json.Marshal(t.data); err != nil {
+			syslogger.Err("tasks: encode data of task", t.id, err)
 		} else {
-			if t.err != nil {
-				t.state = FAILED
-			} else {
-				t.state = COMPLETED
-			}
+			r.Data = data
 		}
+	}
 
-	}()
+	return r
+}
+
+// changed records and announces a state transition
+func (t *Task) changed() {
+	t.persist()
+	t.publish(EVENT_STATE)
+}
+
+func (t *Task) persist() {
+	// held while saving, so that a detached task is never saved again
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.store == nil {
+		return
+	}
+
+	if err := t.store.Save(t.record()); err != nil {
+		syslogger.Err("tasks: save record", t.id, err)
+	}
+}
+
+// detach stops recording the task, once deleted it must not come back
+func (t *Task) detach() {
+	t.mu.Lock()
+	t.store = nil
+	t.mu.Unlock()
+}
+
+func restore(r Record) *Task {
+	t := new(Task)
+	t.id = r.ID
+	t.Description = r.Description
+	t.state = r.State
+	t.progress = r.Progress
+	t.finished = r.Finished
+	if r.Error != "" {
+		t.err = errors.New(r.Error)
+	}
+	if len(r.Data) > 0 {
+		t.data = r.Data
+	}
+
+	return t
 }
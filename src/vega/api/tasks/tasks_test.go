@@ -0,0 +1,449 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const waitTimeout = 5 * time.Second
+
+func waitDone(t *testing.T, task *Task) {
+	deadline := time.Now().Add(waitTimeout)
+	for !task.IsDone() {
+		if time.Now().After(deadline) {
+			t.Fatal("[err] task", task.ID(), "not done, state:", task.State())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func waitState(t *testing.T, task *Task, state State) {
+	deadline := time.Now().Add(waitTimeout)
+	for task.State() != string(state) {
+		if time.Now().After(deadline) {
+			t.Fatal("[err] task", task.ID(), "state:", task.State(), "want:", state)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func blockingRunner(ctx context.Context, pipe chan<- Pipe) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestConcurrentTasks(t *testing.T) {
+	t.Log("[case] Test many concurrent tasks with bounded concurrency")
+
+	const (
+		taskCount  = 64
+		maxRunning = 4
+	)
+
+	m := NewManager()
+	m.SetMaxRunning(maxRunning)
+
+	var running, peak int32
+	runner := func(ctx context.Context, pipe chan<- Pipe) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+
+		for i := 1; i <= 10; i++ {
+			pipe <- Pipe{Progress: float32(i) / 10, Data: i}
+			time.Sleep(100 * time.Microsecond)
+		}
+		return nil
+	}
+
+	tasks := make([]*Task, taskCount)
+	for i := range tasks {
+		tasks[i] = m.New(runner)
+		tasks[i].Start()
+	}
+
+	// hammer the read side while the tasks run
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				for _, task := range tasks {
+					m.Get(task.ID()).Status()
+					task.MapWithData("data")
+					task.Data()
+				}
+				time.Sleep(100 * time.Microsecond)
+			}
+		}()
+	}
+
+	for _, task := range tasks {
+		waitDone(t, task)
+	}
+	close(stop)
+	readers.Wait()
+
+	if peak > maxRunning {
+		t.Error("[err] running tasks peaked at", peak, "limit:", maxRunning)
+	}
+
+	for _, task := range tasks {
+		s := task.Status()
+		if s.State != COMPLETED || s.Progress != 1 {
+			t.Error("[err] task", task.ID(), "status:", s)
+		}
+	}
+
+	if n := m.Running(); n != 0 {
+		t.Error("[err] running count not released:", n)
+	}
+}
+
+func TestStopWaiting(t *testing.T) {
+	t.Log("[case] Test stop a task waiting for a slot")
+
+	m := NewManager()
+	m.SetMaxRunning(1)
+
+	first := m.New(blockingRunner)
+	first.Start()
+	waitState(t, first, RUNNING)
+
+	var ran int32
+	second := m.New(func(ctx context.Context, pipe chan<- Pipe) error {
+		atomic.StoreInt32(&ran, 1)
+		return nil
+	})
+	second.Start()
+
+	if second.State() != string(WAITING) {
+		t.Error("[err] second task should wait, state:", second.State())
+	}
+
+	second.Stop()
+	first.Stop()
+	waitDone(t, first)
+
+	if first.State() != STOPPED {
+		t.Error("[err] first task state:", first.State())
+	}
+	if second.State() != STOPPED {
+		t.Error("[err] second task state:", second.State())
+	}
+	if atomic.LoadInt32(&ran) != 0 {
+		t.Error("[err] stopped task was run")
+	}
+}
+
+func TestStopRunning(t *testing.T) {
+	t.Log("[case] Test cancel a running task")
+
+	m := NewManager()
+	task := m.New(blockingRunner)
+	task.Start()
+	waitState(t, task, RUNNING)
+
+	task.Stop()
+	waitDone(t, task)
+
+	if task.State() != STOPPED {
+		t.Error("[err] task state:", task.State())
+	}
+}
+
+func TestFailedTask(t *testing.T) {
+	t.Log("[case] Test failed task")
+
+	m := NewManager()
+	task := m.New(func(ctx context.Context, pipe chan<- Pipe) error {
+		return errors.New("broken")
+	})
+	task.Start()
+	waitDone(t, task)
+
+	if s := task.Status(); s.State != FAILED || s.Error != "broken" {
+		t.Error("[err] task status:", s)
+	}
+}
+
+func TestFileStoreReload(t *testing.T) {
+	t.Log("[case] Test reload tasks after restart")
+
+	dir, err := ioutil.TempDir("", "tasks")
+	if err != nil {
+		t.Fatal("[err] temp dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal("[err] new store:", err)
+	}
+
+	m, err := NewPersistentManager(store)
+	if err != nil {
+		t.Fatal("[err] new manager:", err)
+	}
+
+	done := m.New(func(ctx context.Context, pipe chan<- Pipe) error {
+		pipe <- Pipe{Progress: 1, Data: map[string]string{"file": "image.bin"}}
+		return nil
+	})
+	done.Description = "download"
+	done.Start()
+	waitDone(t, done)
+
+	running := m.New(blockingRunner)
+	running.Start()
+	waitState(t, running, RUNNING)
+
+	// simulate a restart with the running task still in flight
+	m2, err := NewPersistentManager(store)
+	if err != nil {
+		t.Fatal("[err] reload manager:", err)
+	}
+	running.Stop()
+
+	reloaded := m2.Get(done.ID())
+	if reloaded == nil {
+		t.Fatal("[err] completed task not reloaded")
+	}
+	if reloaded.Description != "download" || !reloaded.IsCompleted() {
+		t.Error("[err] completed task reloaded as:", reloaded.Description, reloaded.Status())
+	}
+	if reloaded.Data() == nil {
+		t.Error("[err] completed task lost its data")
+	}
+
+	interrupted := m2.Get(running.ID())
+	if interrupted == nil {
+		t.Fatal("[err] running task not reloaded")
+	}
+	if s := interrupted.Status(); s.State != FAILED || s.Error != ErrInterrupted.Error() {
+		t.Error("[err] interrupted task status:", s)
+	}
+
+	if next := m2.New(blockingRunner); next.ID() == done.ID() || next.ID() == running.ID() {
+		t.Error("[err] task ID reused:", next.ID())
+	}
+}
+
+func TestDeleteRunning(t *testing.T) {
+	t.Log("[case] Test a deleted running task is not reloaded")
+
+	dir, err := ioutil.TempDir("", "tasks")
+	if err != nil {
+		t.Fatal("[err] temp dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal("[err] new store:", err)
+	}
+
+	m, err := NewPersistentManager(store)
+	if err != nil {
+		t.Fatal("[err] new manager:", err)
+	}
+
+	running := m.New(blockingRunner)
+	running.Start()
+	waitState(t, running, RUNNING)
+
+	running.Stop()
+	m.Delete(running.ID())
+	waitDone(t, running)
+
+	m2, err := NewPersistentManager(store)
+	if err != nil {
+		t.Fatal("[err] reload manager:", err)
+	}
+	if deleted := m2.Get(running.ID()); deleted != nil {
+		t.Error("[err] deleted task reloaded as:", deleted.Status())
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	t.Log("[case] Test subscribe to task events")
+
+	m := NewManager()
+	release := make(chan struct{})
+	task := m.New(func(ctx context.Context, pipe chan<- Pipe) error {
+		<-release
+		pipe <- Pipe{Progress: 0.5}
+		return nil
+	})
+
+	events, cancel := m.Subscribe(task.ID())
+	defer cancel()
+	all, cancelAll := m.Subscribe("")
+	defer cancelAll()
+
+	task.Start()
+	close(release)
+
+	var got []Event
+	timeout := time.After(waitTimeout)
+	for len(got) == 0 || !got[len(got)-1].IsFinal() {
+		select {
+		case e := <-events:
+			got = append(got, e)
+		case <-timeout:
+			t.Fatal("[err] no final event, got:", got)
+		}
+	}
+
+	want := []struct {
+		eventType string
+		state     State
+	}{
+		{EVENT_STATE, WAITING},
+		{EVENT_STATE, RUNNING},
+		{EVENT_PROGRESS, RUNNING},
+		{EVENT_STATE, COMPLETED},
+	}
+
+	if len(got) != len(want) {
+		t.Fatal("[err] events:", got)
+	}
+	for i, e := range got {
+		if e.Type != want[i].eventType || e.State != want[i].state {
+			t.Error("[err] event", i, e)
+		}
+	}
+
+	if len(all) != len(want) {
+		t.Error("[err] events for all tasks:", len(all))
+	}
+}
+
+func TestRetention(t *testing.T) {
+	t.Log("[case] Test reap finished tasks and list by filter")
+
+	m := NewManager()
+	quick := func(ctx context.Context, pipe chan<- Pipe) error { return nil }
+
+	finished := make([]*Task, 5)
+	for i := range finished {
+		finished[i] = m.New(quick)
+		finished[i].Description = "download"
+		finished[i].Start()
+		waitDone(t, finished[i])
+	}
+
+	running := m.New(blockingRunner)
+	running.Description = "upgrade"
+	running.Start()
+	waitState(t, running, RUNNING)
+	defer running.Stop()
+
+	if list := m.List(Filter{States: []State{COMPLETED}, Description: "down"}); len(list) != 5 {
+		t.Error("[err] list completed downloads:", len(list))
+	} else if list[0].ID() != finished[0].ID() {
+		t.Error("[err] list not ordered by ID:", list[0].ID())
+	}
+
+	if list := m.List(Filter{States: []State{RUNNING}}); len(list) != 1 || list[0] != running {
+		t.Error("[err] list running:", list)
+	}
+
+	m.SetRetention(Retention{MaxFinished: 2})
+	if n := m.Reap(); n != 3 {
+		t.Error("[err] reaped", n, "tasks, want 3")
+	}
+	if m.Get(finished[0].ID()) != nil || m.Get(finished[4].ID()) == nil {
+		t.Error("[err] reap should evict the oldest finished tasks")
+	}
+	if m.Get(running.ID()) == nil {
+		t.Error("[err] reap evicted a running task")
+	}
+
+	m.SetRetention(Retention{MaxAge: time.Nanosecond})
+	time.Sleep(time.Millisecond)
+	if n := m.Reap(); n != 2 {
+		t.Error("[err] reaped", n, "expired tasks, want 2")
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	t.Log("[case] Test pipeline success, failure and stop")
+
+	m := NewManager()
+	ok := func(ctx context.Context, pipe chan<- Pipe) error {
+		pipe <- Pipe{Progress: 0.5}
+		return nil
+	}
+	broken := func(ctx context.Context, pipe chan<- Pipe) error {
+		return errors.New("bad checksum")
+	}
+
+	task := m.New(Pipeline(
+		Step{Description: "download", Weight: 3, Run: ok},
+		Step{Description: "verify", Run: ok},
+	))
+	task.Start()
+	waitDone(t, task)
+
+	if s := task.Status(); s.State != COMPLETED || s.Progress != 1 {
+		t.Error("[err] pipeline status:", s)
+	}
+
+	task = m.New(Pipeline(
+		Step{Description: "download", Run: ok},
+		Step{Description: "verify", Run: broken},
+		Step{Description: "apply", Run: ok},
+	))
+	task.Start()
+	waitDone(t, task)
+
+	data, _ := task.Data().(PipelineData)
+	if s := task.Status(); s.State != FAILED || s.Error != "verify: bad checksum" {
+		t.Error("[err] failed pipeline status:", s)
+	}
+	if len(data.Steps) != 3 || data.Steps[1].State != FAILED || data.Steps[2].State != SKIPPED {
+		t.Error("[err] failed pipeline steps:", data)
+	}
+
+	task = m.New(Pipeline(
+		Step{Description: "download", Run: ok},
+		Step{Description: "stage", Run: blockingRunner},
+		Step{Description: "apply", Run: ok},
+	))
+	task.Start()
+	for {
+		if data, _ := task.Data().(PipelineData); data.Current == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	task.Stop()
+	waitDone(t, task)
+
+	data, _ = task.Data().(PipelineData)
+	if task.State() != STOPPED {
+		t.Error("[err] stopped pipeline state:", task.State())
+	}
+	if data.Steps[1].State != STOPPED || data.Steps[2].State != SKIPPED {
+		t.Error("[err] stopped pipeline steps:", data)
+	}
+}
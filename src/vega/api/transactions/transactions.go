@@ -0,0 +1,477 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+// Package transactions edits the running configuration through candidates.
+// A candidate is a copy of the running configuration owned by a login
+// session; it is changed at will and then committed as a whole. Every commit,
+// like every change made in place, is kept as a numbered revision that can be
+// rolled back to, and a confirmed commit is rolled back by itself unless
+// confirmed in time.
+package transactions
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/htbig/common/src/vega/api/locker"
+	"vega/core"
+	"github.com/htbig/common/src/vega/syslogger"
+)
+
+const (
+	DefaultKeep = 50
+
+	// longest time a confirmed commit waits for its confirmation
+	MaxConfirmTimeout = 24 * time.Hour
+
+	// client name of the configuration lock while rolling back by itself
+	lockClient = "rollback"
+	// delay before trying again to roll back while the lock is taken
+	lockRetry = time.Second
+)
+
+var (
+	ErrConflict   = errors.New("Running configuration changed since the candidate was created")
+	ErrNoRevision = errors.New("No such revision")
+	ErrNotPending = errors.New("No commit waiting for confirmation")
+	ErrPending    = errors.New("Commit waiting for confirmation, confirm it or commit again first")
+	ErrTimeout    = fmt.Errorf("Confirmation timeout must be between 1 and %d minutes", int(MaxConfirmTimeout/time.Minute))
+)
+
+// Revision is a committed configuration
+type Revision struct {
+	ID       int       `json:"id"`
+	Time     time.Time `json:"time"`
+	Username string    `json:"username"`
+	Comment  string    `json:"comment,omitempty"`
+	// revision restored by a rollback, 0 for a commit
+	Rollback int `json:"rollback,omitempty"`
+
+	config *core.Config
+}
+
+// Pending is a confirmed commit waiting for its confirmation
+type Pending struct {
+	Revision int       `json:"revision"`
+	Deadline time.Time `json:"deadline"`
+
+	// running configuration before the commit, restored at the deadline
+	previous *core.Config
+	// the committed configuration, to tell whether it changed since
+	committed []byte
+	timer     *time.Timer
+}
+
+type candidate struct {
+	config *core.Config
+	// running configuration the candidate was created from
+	base []byte
+}
+
+// Hook is told of the revisions the manager commits by itself and of the
+// running configuration they replaced, with the configuration lock held
+type Hook func(r Revision, replaced *core.Config)
+
+// Manager keeps the candidates, the revisions and the pending confirmed
+// commit of a running configuration. Commits and rollbacks must be called
+// with the configuration lock held.
+type Manager struct {
+	mu         sync.Mutex
+	config     *core.Config
+	lock       *locker.Lock
+	keep       int
+	revisions  []Revision
+	next       int
+	candidates map[string]*candidate
+	pending    *Pending
+	hook       Hook
+	// the running configuration when last recorded
+	recorded []byte
+
+	// saves a configuration over the old one, replaced by the tests
+	save func(config *core.Config, old core.Config) []error
+}
+
+// New returns a manager of config, locked by lock, keeping at most keep
+// revisions. The current configuration is the first revision.
+func New(config *core.Config, lock *locker.Lock, keep int) (*Manager, error) {
+	if keep < 1 {
+		keep = 1
+	}
+
+	m := &Manager{
+		config:     config,
+		lock:       lock,
+		keep:       keep,
+		next:       1,
+		candidates: make(map[string]*candidate),
+		save:       (*core.Config).Save,
+	}
+
+	clone, err := config.Clone()
+	if err != nil {
+		return nil, err
+	}
+	if m.recorded, err = fingerprint(config); err != nil {
+		return nil, err
+	}
+	m.record(Revision{Comment: "Startup configuration", config: clone})
+
+	return m, nil
+}
+
+// SessionOwner returns the owner of the candidate of a login session
+func SessionOwner(login string) string {
+	return "session:" + login
+}
+
+// UserOwner returns the owner of the candidate of a user without session
+func UserOwner(username string) string {
+	return "user:" + username
+}
+
+// SetHook sets the function told of the automatic rollbacks
+func (m *Manager) SetHook(h Hook) {
+	m.mu.Lock()
+	m.hook = h
+	m.mu.Unlock()
+}
+
+// Candidate returns a copy of the candidate of owner, created from the
+// running configuration if there is none
+func (m *Manager) Candidate(owner string) (*core.Config, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.candidates[owner]
+	if !ok {
+		base, err := fingerprint(m.config)
+		if err != nil {
+			return nil, err
+		}
+
+		clone, err := m.config.Clone()
+		if err != nil {
+			return nil, err
+		}
+
+		c = &candidate{config: clone, base: base}
+		m.candidates[owner] = c
+	}
+
+	return c.config.Clone()
+}
+
+// SetCandidate replaces the candidate of owner by config, a verified copy of
+// the one Candidate returned
+func (m *Manager) SetCandidate(owner string, config *core.Config) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.candidates[owner]
+	if !ok {
+		// discarded meanwhile, the changes were made to the running one
+		base, err := fingerprint(m.config)
+		if err != nil {
+			return err
+		}
+		c = &candidate{base: base}
+		m.candidates[owner] = c
+	}
+
+	c.config = config
+	return nil
+}
+
+// Discard drops the candidate of owner, it tells whether there was one
+func (m *Manager) Discard(owner string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.candidates[owner]
+	delete(m.candidates, owner)
+	return ok
+}
+
+// Commit applies the candidate of owner to the running configuration and
+// drops it. With a timeout the commit is rolled back unless confirmed before
+// it runs out. A commit confirms the pending one, if any. The verification
+// errors of the candidate are returned by field.
+func (m *Manager) Commit(owner, username, comment string, timeout time.Duration) (Revision, map[string][]error, error) {
+	if timeout < 0 || timeout > MaxConfirmTimeout {
+		return Revision{}, nil, ErrTimeout
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.candidates[owner]
+	if !ok {
+		// nothing edited, the running configuration is committed as is
+		clone, err := m.config.Clone()
+		if err != nil {
+			return Revision{}, nil, err
+		}
+		c = &candidate{config: clone}
+	} else {
+		running, err := fingerprint(m.config)
+		if err != nil {
+			return Revision{}, nil, err
+		}
+		if !bytes.Equal(running, c.base) {
+			return Revision{}, nil, ErrConflict
+		}
+	}
+
+	previous, err := m.config.Clone()
+	if err != nil {
+		return Revision{}, nil, err
+	}
+
+	revision, errorMap, err := m.apply(c.config, Revision{Username: username, Comment: comment})
+	if err != nil || len(errorMap) > 0 {
+		return revision, errorMap, err
+	}
+	delete(m.candidates, owner)
+
+	m.confirm()
+	if timeout > 0 {
+		committed, err := fingerprint(m.config)
+		if err != nil {
+			return revision, nil, err
+		}
+
+		p := &Pending{
+			Revision:  revision.ID,
+			Deadline:  revision.Time.Add(timeout),
+			previous:  previous,
+			committed: committed,
+		}
+		p.timer = time.AfterFunc(timeout, func() { m.expire(p) })
+		m.pending = p
+	}
+
+	return revision, nil, nil
+}
+
+// Confirm confirms the pending commit
+func (m *Manager) Confirm() (Pending, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.pending == nil {
+		return Pending{}, ErrNotPending
+	}
+
+	p := *m.pending
+	m.confirm()
+	return p, nil
+}
+
+// Pending returns the pending commit, if any
+func (m *Manager) Pending() (Pending, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.pending == nil {
+		return Pending{}, false
+	}
+
+	return *m.pending, true
+}
+
+// Revisions returns the revisions kept, oldest first
+func (m *Manager) Revisions() []Revision {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Revision{}, m.revisions...)
+}
+
+// Config returns a copy of the configuration of a revision
+func (m *Manager) Config(id int) (*core.Config, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	revision, ok := m.revision(id)
+	if !ok {
+		return nil, ErrNoRevision
+	}
+
+	return revision.config.Clone()
+}
+
+// Rollback applies the configuration of a revision as a new revision. It
+// confirms the pending commit, if any.
+func (m *Manager) Rollback(id int, username string) (Revision, map[string][]error, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	revision, ok := m.revision(id)
+	if !ok {
+		return Revision{}, nil, ErrNoRevision
+	}
+
+	target, err := revision.config.Clone()
+	if err != nil {
+		return Revision{}, nil, err
+	}
+
+	r, errorMap, err := m.apply(target, Revision{
+		Username: username,
+		Comment:  fmt.Sprintf("Rollback to revision %d", id),
+		Rollback: id,
+	})
+	if err == nil && len(errorMap) == 0 {
+		m.confirm()
+	}
+
+	return r, errorMap, err
+}
+
+// Record records the running configuration as a revision made by username,
+// for the changes made to it in place rather than committed. Nothing is
+// recorded unless it changed since the last revision; ok tells whether it
+// did.
+func (m *Manager) Record(username, comment string) (r Revision, ok bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	running, err := fingerprint(m.config)
+	if err != nil {
+		return Revision{}, false, err
+	}
+	if bytes.Equal(running, m.recorded) {
+		return Revision{}, false, nil
+	}
+
+	clone, err := m.config.Clone()
+	if err != nil {
+		return Revision{}, false, err
+	}
+
+	m.recorded = running
+	return m.record(Revision{Username: username, Comment: comment, config: clone}), true, nil
+}
+
+// apply verifies config and saves it as the running configuration, recording
+// it as a revision made of template. A configuration that failed to save is
+// not recorded, the running one is saved again; the save errors come under
+// "save".
+func (m *Manager) apply(config *core.Config, template Revision) (Revision, map[string][]error, error) {
+	if errorMap := config.Verify(); len(errorMap) > 0 {
+		return Revision{}, errorMap, nil
+	}
+
+	if errs := m.save(config, *m.config); len(errs) > 0 {
+		if restoreErrs := m.save(m.config, *config); len(restoreErrs) > 0 {
+			syslogger.Err("API restore of the running configuration failed:", restoreErrs)
+		}
+		return Revision{}, map[string][]error{"save": errs}, nil
+	}
+
+	if err := m.config.CopyFrom(*config); err != nil {
+		return Revision{}, nil, err
+	}
+
+	recorded, err := fingerprint(m.config)
+	if err != nil {
+		return Revision{}, nil, err
+	}
+	m.recorded = recorded
+
+	clone, err := config.Clone()
+	if err != nil {
+		return Revision{}, nil, err
+	}
+
+	template.config = clone
+	return m.record(template), nil, nil
+}
+
+func (m *Manager) record(r Revision) Revision {
+	r.ID = m.next
+	r.Time = time.Now().UTC()
+	m.next++
+
+	m.revisions = append(m.revisions, r)
+	if len(m.revisions) > m.keep {
+		m.revisions = m.revisions[len(m.revisions)-m.keep:]
+	}
+
+	return r
+}
+
+func (m *Manager) revision(id int) (Revision, bool) {
+	for _, r := range m.revisions {
+		if r.ID == id {
+			return r, true
+		}
+	}
+
+	return Revision{}, false
+}
+
+// confirm stops the timer of the pending commit
+func (m *Manager) confirm() {
+	if m.pending != nil {
+		m.pending.timer.Stop()
+		m.pending = nil
+	}
+}
+
+// expire rolls back the pending commit p at its deadline, unless it was
+// confirmed meanwhile
+func (m *Manager) expire(p *Pending) {
+	if ok, _ := m.lock.TryLock(lockClient); !ok {
+		m.mu.Lock()
+		if m.pending == p {
+			p.timer = time.AfterFunc(lockRetry, func() { m.expire(p) })
+		}
+		m.mu.Unlock()
+		return
+	}
+	defer m.lock.Unlock()
+
+	m.mu.Lock()
+	if m.pending != p {
+		m.mu.Unlock()
+		return
+	}
+	m.pending = nil
+
+	if running, err := fingerprint(m.config); err == nil && !bytes.Equal(running, p.committed) {
+		syslogger.Warning("API changes made since the unconfirmed revision", p.Revision, "are rolled back with it")
+	}
+
+	replaced, err := m.config.Clone()
+	if err != nil {
+		m.mu.Unlock()
+		syslogger.Err("API rollback of revision", p.Revision, "failed:", err)
+		return
+	}
+
+	revision, errorMap, err := m.apply(p.previous, Revision{
+		Comment: fmt.Sprintf("Automatic rollback of the unconfirmed revision %d", p.Revision),
+	})
+	hook := m.hook
+	m.mu.Unlock()
+
+	if err != nil || len(errorMap) > 0 {
+		syslogger.Err("API rollback of revision", p.Revision, "failed:", err, errorMap)
+		return
+	}
+
+	syslogger.Warning("API revision", p.Revision, "not confirmed, rolled back as revision", revision.ID)
+	if hook != nil {
+		hook(revision, replaced)
+	}
+}
+
+// fingerprint returns the configuration as JSON, to tell whether it changed
+func fingerprint(config *core.Config) ([]byte, error) {
+	return json.Marshal(config.Map())
+}
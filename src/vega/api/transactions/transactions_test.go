@@ -0,0 +1,229 @@
+package transactions
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/htbig/common/src/vega/api/locker"
+	"vega/core"
+	"vega/core/aaa"
+)
+
+func TestCandidate(t *testing.T) {
+	config := core.NewConfig()
+	m, err := New(config, locker.New(), 3)
+	if err != nil {
+		t.Fatal("[err] New:", err)
+	}
+
+	t.Log("[case] Test startup revision")
+	if revisions := m.Revisions(); len(revisions) != 1 || revisions[0].ID != 1 {
+		t.Error("[err] revisions:", revisions)
+	}
+	if _, err := m.Config(1); err != nil {
+		t.Error("[err] startup configuration:", err)
+	}
+	if _, err := m.Config(2); err != ErrNoRevision {
+		t.Error("[err] missing revision:", err)
+	}
+
+	t.Log("[case] Test candidate per owner")
+	owner := SessionOwner("a")
+	candidate, err := m.Candidate(owner)
+	if err != nil {
+		t.Fatal("[err] Candidate:", err)
+	}
+	candidate.AAA.Roles.Enabled = true
+	if err := m.SetCandidate(owner, candidate); err != nil {
+		t.Error("[err] SetCandidate:", err)
+	}
+	if config.AAA.Roles.Enabled {
+		t.Error("[err] candidate changed the running configuration")
+	}
+	if candidate, _ := m.Candidate(owner); !candidate.AAA.Roles.Enabled {
+		t.Error("[err] candidate change lost")
+	}
+	if candidate, _ := m.Candidate(UserOwner("b")); candidate.AAA.Roles.Enabled {
+		t.Error("[err] candidate shared between owners")
+	}
+
+	t.Log("[case] Test bad confirmation timeout")
+	if _, _, err := m.Commit(owner, "admin", "", -time.Minute); err != ErrTimeout {
+		t.Error("[err] negative timeout:", err)
+	}
+	if _, _, err := m.Commit(owner, "admin", "", MaxConfirmTimeout+time.Minute); err != ErrTimeout {
+		t.Error("[err] timeout too long:", err)
+	}
+
+	t.Log("[case] Test conflict with the running configuration")
+	config.AAA.Methods = append(config.AAA.Methods, aaa.Method{Name: "local"})
+	if _, _, err := m.Commit(owner, "admin", "", 0); err != ErrConflict {
+		t.Error("[err] commit over a changed running configuration:", err)
+	}
+
+	t.Log("[case] Test change made in place")
+	r, ok, err := m.Record("admin", "PATCH /aaa")
+	if err != nil || !ok || r.ID != 2 || r.Username != "admin" {
+		t.Error("[err] change not recorded:", r, ok, err)
+	}
+	if recorded, _ := m.Config(2); recorded == nil || len(recorded.AAA.Methods) != len(config.AAA.Methods) {
+		t.Error("[err] recorded configuration:", recorded)
+	}
+	if _, ok, err := m.Record("admin", "PATCH /aaa"); err != nil || ok {
+		t.Error("[err] unchanged configuration recorded:", ok, err)
+	}
+
+	t.Log("[case] Test discard")
+	if !m.Discard(owner) || m.Discard(owner) {
+		t.Error("[err] discard")
+	}
+
+	t.Log("[case] Test confirm without pending commit")
+	if _, err := m.Confirm(); err != ErrNotPending {
+		t.Error("[err] confirm:", err)
+	}
+	if _, ok := m.Pending(); ok {
+		t.Error("[err] pending commit")
+	}
+}
+
+// newTestManager returns a manager saving nothing to the system
+func newTestManager(t *testing.T) (*Manager, *core.Config) {
+	config := core.NewConfig()
+	config.AAA.Factory()
+
+	m, err := New(config, locker.New(), DefaultKeep)
+	if err != nil {
+		t.Fatal("[err] New:", err)
+	}
+	m.save = func(config *core.Config, old core.Config) []error { return nil }
+
+	return m, config
+}
+
+// edit commits a candidate of owner with the minimum password length
+func edit(t *testing.T, m *Manager, owner string, length int, timeout time.Duration) Revision {
+	candidate, err := m.Candidate(owner)
+	if err != nil {
+		t.Fatal("[err] Candidate:", err)
+	}
+	candidate.AAA.PasswordPolicy.MinLength = length
+	if err := m.SetCandidate(owner, candidate); err != nil {
+		t.Fatal("[err] SetCandidate:", err)
+	}
+
+	revision, errorMap, err := m.Commit(owner, "admin", "length", timeout)
+	if err != nil || len(errorMap) > 0 {
+		t.Fatal("[err] Commit:", errorMap, err)
+	}
+
+	return revision
+}
+
+// waitRollback returns the minimum password length of the configuration an
+// automatic rollback replaced
+func waitRollback(t *testing.T, rollbacks chan int) int {
+	select {
+	case length := <-rollbacks:
+		return length
+	case <-time.After(5 * time.Second):
+		t.Fatal("[err] commit not rolled back")
+	}
+
+	return 0
+}
+
+func TestCommit(t *testing.T) {
+	m, config := newTestManager(t)
+	owner := SessionOwner("a")
+
+	t.Log("[case] Test commit")
+	revision := edit(t, m, owner, 12, 0)
+	if revision.ID != 2 || revision.Username != "admin" || config.AAA.PasswordPolicy.MinLength != 12 {
+		t.Error("[err] commit:", revision, config.AAA.PasswordPolicy)
+	}
+	if m.Discard(owner) {
+		t.Error("[err] candidate kept after the commit")
+	}
+	if _, ok := m.Pending(); ok {
+		t.Error("[err] commit without timeout pending")
+	}
+
+	t.Log("[case] Test rollback by ID")
+	revision, errorMap, err := m.Rollback(1, "admin")
+	if err != nil || len(errorMap) > 0 || revision.ID != 3 || revision.Rollback != 1 {
+		t.Error("[err] rollback:", revision, errorMap, err)
+	}
+	if config.AAA.PasswordPolicy.MinLength != 8 {
+		t.Error("[err] rolled back configuration:", config.AAA.PasswordPolicy)
+	}
+	if _, _, err := m.Rollback(10, "admin"); err != ErrNoRevision {
+		t.Error("[err] rollback to a missing revision:", err)
+	}
+
+	t.Log("[case] Test failed save")
+	m.save = func(config *core.Config, old core.Config) []error {
+		if config.AAA.PasswordPolicy.MinLength == 20 {
+			return []error{errors.New("broken")}
+		}
+		return nil
+	}
+	candidate, _ := m.Candidate(owner)
+	candidate.AAA.PasswordPolicy.MinLength = 20
+	m.SetCandidate(owner, candidate)
+	if _, errorMap, err := m.Commit(owner, "admin", "", 0); err != nil || len(errorMap["save"]) != 1 {
+		t.Error("[err] failed save committed:", errorMap, err)
+	}
+	if config.AAA.PasswordPolicy.MinLength != 8 || len(m.Revisions()) != 3 {
+		t.Error("[err] failed save recorded:", config.AAA.PasswordPolicy, m.Revisions())
+	}
+}
+
+func TestConfirmedCommit(t *testing.T) {
+	m, config := newTestManager(t)
+	owner := UserOwner("admin")
+
+	rollbacks := make(chan int, 2)
+	m.SetHook(func(r Revision, replaced *core.Config) {
+		rollbacks <- replaced.AAA.PasswordPolicy.MinLength
+	})
+
+	t.Log("[case] Test unconfirmed commit rolled back")
+	revision := edit(t, m, owner, 12, 50*time.Millisecond)
+	if pending, ok := m.Pending(); !ok || pending.Revision != revision.ID {
+		t.Error("[err] pending commit:", pending, ok)
+	}
+	if replaced := waitRollback(t, rollbacks); replaced != 12 {
+		t.Error("[err] replaced configuration:", replaced)
+	}
+	if config.AAA.PasswordPolicy.MinLength != 8 {
+		t.Error("[err] unconfirmed commit kept:", config.AAA.PasswordPolicy)
+	}
+	if revisions := m.Revisions(); len(revisions) != 3 {
+		t.Error("[err] automatic rollback:", revisions)
+	}
+
+	t.Log("[case] Test confirmed commit kept")
+	edit(t, m, owner, 12, 50*time.Millisecond)
+	if _, err := m.Confirm(); err != nil {
+		t.Error("[err] confirm:", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if config.AAA.PasswordPolicy.MinLength != 12 || len(m.Revisions()) != 4 {
+		t.Error("[err] confirmed commit rolled back:", config.AAA.PasswordPolicy)
+	}
+
+	t.Log("[case] Test rollback while the lock is taken")
+	edit(t, m, owner, 14, 20*time.Millisecond)
+	m.lock.TryLock("test")
+	time.Sleep(50 * time.Millisecond)
+	if config.AAA.PasswordPolicy.MinLength != 14 {
+		t.Error("[err] rolled back under the lock")
+	}
+	m.lock.Unlock()
+	waitRollback(t, rollbacks)
+	if config.AAA.PasswordPolicy.MinLength != 12 {
+		t.Error("[err] not rolled back after the lock:", config.AAA.PasswordPolicy)
+	}
+}
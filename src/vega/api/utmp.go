@@ -0,0 +1,67 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+)
+
+// utmp as glibc lays it out on 64 bit Linux
+const (
+	utmpFile        = "/var/run/utmp"
+	utmpRecordSize  = 384
+	utmpUserProcess = 7
+
+	utmp_type = 0
+	utmp_pid  = 4
+	utmp_line = 8
+	utmp_user = 44
+	utmp_host = 76
+
+	utmp_line_size = 32
+	utmp_user_size = 32
+	utmp_host_size = 256
+)
+
+// loginSession is a login in utmp, its process is the session leader
+type loginSession struct {
+	PID  int
+	User string
+	Line string
+	Host string
+}
+
+// loginSessions returns the current logins
+func loginSessions() ([]loginSession, error) {
+	data, err := ioutil.ReadFile(utmpFile)
+	if err != nil {
+		return nil, err
+	}
+
+	logins := []loginSession{}
+	for off := 0; off+utmpRecordSize <= len(data); off += utmpRecordSize {
+		record := data[off : off+utmpRecordSize]
+		if binary.LittleEndian.Uint16(record[utmp_type:]) != utmpUserProcess {
+			continue
+		}
+
+		logins = append(logins, loginSession{
+			PID:  int(int32(binary.LittleEndian.Uint32(record[utmp_pid:]))),
+			User: cString(record[utmp_user : utmp_user+utmp_user_size]),
+			Line: cString(record[utmp_line : utmp_line+utmp_line_size]),
+			Host: cString(record[utmp_host : utmp_host+utmp_host_size]),
+		})
+	}
+
+	return logins, nil
+}
+
+func cString(b []byte) string {
+	if idx := bytes.IndexByte(b, 0); idx >= 0 {
+		b = b[:idx]
+	}
+
+	return string(b)
+}
@@ -1,23 +1,47 @@
 package aaa
 
 import (
+	"vega/core/aaa/ldap"
 	"vega/core/aaa/localusers"
 	"vega/core/aaa/radius"
+	"vega/core/aaa/roles"
+	"vega/core/aaa/tacacs"
+	"vega/core/aaa/trusted"
 )
 
 type Config struct {
+	Methods    []Method          `json:"methods"`
 	RADIUS     radius.Config     `json:"radius"`
+	TACACS     tacacs.Config     `json:"tacacs"`
+	LDAP       ldap.Config       `json:"ldap"`
 	LocalUsers localusers.Config `json:"localusers"`
+	Trusted    trusted.Config    `json:"trusted"`
+	Roles      roles.Config      `json:"roles"`
+	// password policy and lockout of the local users
+	PasswordPolicy localusers.Policy `json:"password_policy"`
 }
 
 func (config *Config) Legacy(legacyRoot string) {
+	config.Methods = []Method{}
 	config.RADIUS.Legacy(legacyRoot)
+	config.TACACS.Legacy(legacyRoot)
+	config.LDAP.Legacy(legacyRoot)
 	config.LocalUsers.Legacy(legacyRoot)
+	config.PasswordPolicy.Legacy(legacyRoot)
+	config.Trusted.Legacy(legacyRoot)
+	config.Roles.Legacy(legacyRoot)
 }
 
 func (config *Config) CopyFrom(otherConfig Config) {
+	config.Methods = make([]Method, len(otherConfig.Methods))
+	copy(config.Methods, otherConfig.Methods)
 	config.RADIUS.CopyFrom(otherConfig.RADIUS)
+	config.TACACS.CopyFrom(otherConfig.TACACS)
+	config.LDAP.CopyFrom(otherConfig.LDAP)
 	config.LocalUsers.CopyFrom(otherConfig.LocalUsers)
+	config.PasswordPolicy.CopyFrom(otherConfig.PasswordPolicy)
+	config.Trusted.CopyFrom(otherConfig.Trusted)
+	config.Roles.CopyFrom(otherConfig.Roles)
 }
 
 func (config *Config) CopyFromInterface(data interface{}) bool {
@@ -43,8 +67,14 @@ func (config *Config) Clone() *Config {
 
 func (config *Config) Factory() {
 	// set defaults
+	config.Methods = []Method{}
 	config.RADIUS.Factory()
+	config.TACACS.Factory()
+	config.LDAP.Factory()
 	config.LocalUsers.Factory()
+	config.PasswordPolicy.Factory()
+	config.Trusted.Factory()
+	config.Roles.Factory()
 }
 
 func (config *Config) SaveInterface(data interface{}) (bool, []error) {
@@ -60,7 +90,12 @@ func (config *Config) Save(oldConfig Config) []error {
 	errs := []error{}
 
 	errs = append(errs, config.RADIUS.Save(oldConfig.RADIUS)...)
+	errs = append(errs, config.TACACS.Save(oldConfig.TACACS)...)
+	errs = append(errs, config.LDAP.Save(oldConfig.LDAP)...)
 	errs = append(errs, config.LocalUsers.Save(oldConfig.LocalUsers)...)
+	errs = append(errs, config.PasswordPolicy.Save(oldConfig.PasswordPolicy)...)
+	errs = append(errs, config.Trusted.Save(oldConfig.Trusted)...)
+	errs = append(errs, config.Roles.Save(oldConfig.Roles)...)
 
 	return errs
 }
@@ -72,8 +107,14 @@ func (config *Config) Tag() string {
 func (config *Config) Verify() []error {
 	errs := []error{}
 
+	errs = append(errs, config.verifyMethods()...)
 	errs = append(errs, config.RADIUS.Verify()...)
+	errs = append(errs, config.TACACS.Verify()...)
+	errs = append(errs, config.LDAP.Verify()...)
 	errs = append(errs, config.LocalUsers.Verify()...)
+	errs = append(errs, config.PasswordPolicy.Verify()...)
+	errs = append(errs, config.Trusted.Verify()...)
+	errs = append(errs, config.Roles.Verify()...)
 
 	return errs
 }
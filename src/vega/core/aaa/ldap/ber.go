@@ -0,0 +1,178 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// BER tags used by the LDAP messages of this client
+const (
+	tagInteger    = 0x02
+	tagOctets     = 0x04
+	tagEnumerated = 0x0a
+	tagSequence   = 0x30
+	tagSet        = 0x31
+	tagBoolean    = 0x01
+
+	tagBindRequest      = 0x60
+	tagBindResponse     = 0x61
+	tagUnbindRequest    = 0x42
+	tagSearchRequest    = 0x63
+	tagSearchEntry      = 0x64
+	tagSearchDone       = 0x65
+	tagSearchReference  = 0x73
+	tagExtendedRequest  = 0x77
+	tagExtendedResponse = 0x78
+	tagSimpleAuth       = 0x80
+	tagExtendedName     = 0x80
+	tagFilterEquality   = 0xa3
+
+	// refuse messages larger than this
+	maxMessageLength = 1 << 20
+)
+
+var errMalformed = errors.New("Malformed LDAP message")
+
+// element is a decoded BER TLV
+type element struct {
+	tag     byte
+	content []byte
+}
+
+func encode(tag byte, content []byte) []byte {
+	n := len(content)
+	var length []byte
+
+	switch {
+	case n < 0x80:
+		length = []byte{byte(n)}
+	case n <= 0xff:
+		length = []byte{0x81, byte(n)}
+	case n <= 0xffff:
+		length = []byte{0x82, byte(n >> 8), byte(n)}
+	default:
+		length = []byte{0x83, byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+
+	out := make([]byte, 0, 1+len(length)+n)
+	out = append(out, tag)
+	out = append(out, length...)
+	return append(out, content...)
+}
+
+func encodeSequence(tag byte, parts ...[]byte) []byte {
+	var content []byte
+	for _, part := range parts {
+		content = append(content, part...)
+	}
+	return encode(tag, content)
+}
+
+func encodeString(tag byte, s string) []byte {
+	return encode(tag, []byte(s))
+}
+
+func encodeInt(tag byte, v int) []byte {
+	// minimal two's complement, big endian
+	content := []byte{byte(v)}
+	for v > 0x7f || v < -0x80 {
+		v >>= 8
+		content = append([]byte{byte(v)}, content...)
+	}
+	return encode(tag, content)
+}
+
+func encodeBool(v bool) []byte {
+	if v {
+		return encode(tagBoolean, []byte{0xff})
+	}
+	return encode(tagBoolean, []byte{0})
+}
+
+func decodeInt(content []byte) (int, error) {
+	if len(content) == 0 || len(content) > 4 {
+		return 0, errMalformed
+	}
+
+	v := int(int8(content[0]))
+	for _, b := range content[1:] {
+		v = v<<8 | int(b)
+	}
+	return v, nil
+}
+
+// parse splits the content of a constructed element into its children
+func parse(data []byte) ([]element, error) {
+	elements := []element{}
+
+	for len(data) > 0 {
+		if len(data) < 2 {
+			return nil, errMalformed
+		}
+
+		tag := data[0]
+		length, size := int(data[1]), 2
+		if length&0x80 != 0 {
+			octets := length & 0x7f
+			if octets == 0 || octets > 3 || len(data) < 2+octets {
+				return nil, errMalformed
+			}
+
+			length = 0
+			for _, b := range data[2 : 2+octets] {
+				length = length<<8 | int(b)
+			}
+			size += octets
+		}
+
+		if len(data) < size+length {
+			return nil, errMalformed
+		}
+
+		elements = append(elements, element{tag, data[size : size+length]})
+		data = data[size+length:]
+	}
+
+	return elements, nil
+}
+
+// read returns the next top level element of a stream
+func read(r *bufio.Reader) (element, error) {
+	var e element
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return e, err
+	}
+
+	e.tag = header[0]
+	length := int(header[1])
+	if length&0x80 != 0 {
+		octets := length & 0x7f
+		if octets == 0 || octets > 3 {
+			return e, errMalformed
+		}
+
+		buf := make([]byte, octets)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return e, err
+		}
+
+		length = 0
+		for _, b := range buf {
+			length = length<<8 | int(b)
+		}
+	}
+
+	if length > maxMessageLength {
+		return e, errMalformed
+	}
+
+	e.content = make([]byte, length)
+	if _, err := io.ReadFull(r, e.content); err != nil {
+		return e, err
+	}
+
+	return e, nil
+}
@@ -0,0 +1,427 @@
+package ldap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/htbig/common/src/vega/syslogger"
+)
+
+const LdapNoServerError = "LDAP: No LDAP server is set"
+const LdapAuthError = "LDAP: Failed to authenticate with any servers"
+
+const (
+	timeout = 5 * time.Second
+
+	protocolVersion = 3
+
+	resultSuccess            = 0
+	resultSizeLimitExceeded  = 4
+	resultNoSuchObject       = 32
+	resultInvalidCredentials = 49
+
+	scopeSubtree = 2
+	derefNever   = 0
+
+	startTLSOID = "1.3.6.1.4.1.1466.20037"
+)
+
+// entry is a search result
+type entry struct {
+	dn string
+	// values by lower case attribute name
+	attributes map[string][]string
+}
+
+// Authenticate looks the user up below the base DN and binds as the user,
+// moving to the next server only when one does not answer. On success it
+// returns whether the user is in an admin group and the user's group DNs.
+func (config *Config) Authenticate(username, password string) (privileged bool, groups []string, ok bool, errs []error) {
+	if len(config.Servers) == 0 {
+		errs = append(errs, errors.New(LdapNoServerError))
+		return
+	}
+
+	// an empty password would make an unauthenticated bind, which servers
+	// accept for any DN
+	if username == "" || password == "" {
+		return
+	}
+
+	var server_errs []error
+
+	for _, server := range config.Servers {
+		privileged, groups, ok, err := config.authenticate(server, username, password)
+		if err == nil {
+			return privileged, groups, ok, nil
+		}
+
+		err = fmt.Errorf("%s: %s", server.Host, err.Error())
+		server_errs = append(server_errs, err)
+		syslogger.Err("LDAP auth:", err)
+	}
+
+	errs = append(errs, errors.New(LdapAuthError))
+	errs = append(errs, server_errs...)
+
+	return
+}
+
+func (config *Config) authenticate(server Server, username, password string) (bool, []string, bool, error) {
+	c, err := config.dial(server)
+	if err != nil {
+		return false, nil, false, err
+	}
+	defer c.close()
+
+	if config.BindDN != "" {
+		code, message, err := c.bind(config.BindDN, config.BindPassword)
+		if err != nil {
+			return false, nil, false, err
+		}
+		if code != resultSuccess {
+			return false, nil, false, fmt.Errorf("Bind as %s: %s", config.BindDN, resultError(code, message))
+		}
+	}
+
+	userAttribute := config.UserAttribute
+	if userAttribute == "" {
+		userAttribute = default_user_attribute
+	}
+
+	groupAttribute := config.GroupAttribute
+	if groupAttribute == "" {
+		groupAttribute = default_group_attribute
+	}
+
+	entries, err := c.search(config.BaseDN, userAttribute, username, []string{groupAttribute})
+	if err != nil {
+		return false, nil, false, err
+	}
+
+	if len(entries) != 1 {
+		// unknown or ambiguous user
+		return false, nil, false, nil
+	}
+
+	code, message, err := c.bind(entries[0].dn, password)
+	if err != nil {
+		return false, nil, false, err
+	}
+
+	switch code {
+	case resultSuccess:
+	case resultInvalidCredentials:
+		return false, nil, false, nil
+	default:
+		return false, nil, false, resultError(code, message)
+	}
+
+	groups := entries[0].attributes[strings.ToLower(groupAttribute)]
+	privileged := memberOfAny(groups, config.AdminGroups)
+
+	if len(config.UserGroups) > 0 && !privileged && !memberOfAny(groups, config.UserGroups) {
+		return false, nil, false, nil
+	}
+
+	return privileged, groups, true, nil
+}
+
+func memberOfAny(groups, of []string) bool {
+	for _, group := range groups {
+		for _, dn := range of {
+			if strings.EqualFold(strings.TrimSpace(group), strings.TrimSpace(dn)) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func resultError(code int, message string) error {
+	if message != "" {
+		return fmt.Errorf("Result code %d: %s", code, message)
+	}
+	return fmt.Errorf("Result code %d", code)
+}
+
+func (config *Config) tlsConfig(server Server) (*tls.Config, error) {
+	tlsConfig := &tls.Config{ServerName: server.Host}
+
+	if config.CACert != "" {
+		pool, err := loadCACert(config.CACert)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// conn is an LDAP connection for a single login
+type conn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	id   int
+}
+
+func (config *Config) dial(server Server) (*conn, error) {
+	raw, err := net.DialTimeout("tcp", config.address(server), timeout)
+	if err != nil {
+		return nil, err
+	}
+	raw.SetDeadline(time.Now().Add(timeout))
+
+	c := &conn{conn: raw, r: bufio.NewReader(raw)}
+
+	if config.Security == SECURITY_LDAPS || config.Security == SECURITY_STARTTLS {
+		tlsConfig, err := config.tlsConfig(server)
+		if err == nil && config.Security == SECURITY_STARTTLS {
+			err = c.startTLS()
+		}
+		if err == nil {
+			err = c.upgrade(tlsConfig)
+		}
+		if err != nil {
+			raw.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func (c *conn) startTLS() error {
+	request := encodeSequence(tagExtendedRequest, encodeString(tagExtendedName, startTLSOID))
+
+	op, err := c.roundTrip(request, tagExtendedResponse)
+	if err != nil {
+		return err
+	}
+
+	code, message, err := result(op)
+	if err != nil {
+		return err
+	}
+	if code != resultSuccess {
+		return fmt.Errorf("StartTLS: %s", resultError(code, message))
+	}
+
+	return nil
+}
+
+func (c *conn) upgrade(tlsConfig *tls.Config) error {
+	tc := tls.Client(c.conn, tlsConfig)
+	if err := tc.Handshake(); err != nil {
+		return err
+	}
+
+	c.conn = tc
+	c.r = bufio.NewReader(tc)
+	return nil
+}
+
+func (c *conn) close() {
+	c.send(encode(tagUnbindRequest, nil))
+	c.conn.Close()
+}
+
+func (c *conn) send(op []byte) error {
+	c.id++
+	_, err := c.conn.Write(encodeSequence(tagSequence, encodeInt(tagInteger, c.id), op))
+	return err
+}
+
+func (c *conn) receive() (element, error) {
+	message, err := read(c.r)
+	if err != nil {
+		return element{}, err
+	}
+
+	if message.tag != tagSequence {
+		return element{}, errMalformed
+	}
+
+	parts, err := parse(message.content)
+	if err != nil {
+		return element{}, err
+	}
+
+	if len(parts) < 2 || parts[0].tag != tagInteger {
+		return element{}, errMalformed
+	}
+
+	id, err := decodeInt(parts[0].content)
+	if err != nil {
+		return element{}, err
+	}
+
+	if id != c.id {
+		// message ID 0 is the server's notice of disconnection
+		return element{}, fmt.Errorf("Unexpected message ID %d", id)
+	}
+
+	return parts[1], nil
+}
+
+func (c *conn) roundTrip(request []byte, responseTag byte) (element, error) {
+	if err := c.send(request); err != nil {
+		return element{}, err
+	}
+
+	op, err := c.receive()
+	if err != nil {
+		return op, err
+	}
+
+	if op.tag != responseTag {
+		return op, fmt.Errorf("Unexpected response 0x%02x", op.tag)
+	}
+
+	return op, nil
+}
+
+// result decodes the LDAPResult that starts a response
+func result(op element) (int, string, error) {
+	parts, err := parse(op.content)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if len(parts) < 3 || parts[0].tag != tagEnumerated {
+		return 0, "", errMalformed
+	}
+
+	code, err := decodeInt(parts[0].content)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return code, string(parts[2].content), nil
+}
+
+func (c *conn) bind(dn, password string) (int, string, error) {
+	request := encodeSequence(tagBindRequest,
+		encodeInt(tagInteger, protocolVersion),
+		encodeString(tagOctets, dn),
+		encodeString(tagSimpleAuth, password),
+	)
+
+	op, err := c.roundTrip(request, tagBindResponse)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return result(op)
+}
+
+// search returns the entries below base whose attribute equals value. The
+// filter is built from BER elements, so value needs no escaping.
+func (c *conn) search(base, attribute, value string, attributes []string) ([]entry, error) {
+	var requested [][]byte
+	for _, a := range attributes {
+		requested = append(requested, encodeString(tagOctets, a))
+	}
+
+	request := encodeSequence(tagSearchRequest,
+		encodeString(tagOctets, base),
+		encodeInt(tagEnumerated, scopeSubtree),
+		encodeInt(tagEnumerated, derefNever),
+		// two entries are enough to tell an ambiguous user
+		encodeInt(tagInteger, 2),
+		encodeInt(tagInteger, int(timeout/time.Second)),
+		encodeBool(false),
+		encodeSequence(tagFilterEquality,
+			encodeString(tagOctets, attribute),
+			encodeString(tagOctets, value),
+		),
+		encodeSequence(tagSequence, requested...),
+	)
+
+	if err := c.send(request); err != nil {
+		return nil, err
+	}
+
+	entries := []entry{}
+	for {
+		op, err := c.receive()
+		if err != nil {
+			return nil, err
+		}
+
+		switch op.tag {
+		case tagSearchEntry:
+			e, err := parseEntry(op)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, e)
+		case tagSearchReference:
+			// referrals are not followed
+		case tagSearchDone:
+			code, message, err := result(op)
+			if err != nil {
+				return nil, err
+			}
+
+			switch code {
+			case resultSuccess, resultSizeLimitExceeded:
+				return entries, nil
+			case resultNoSuchObject:
+				return []entry{}, nil
+			default:
+				return nil, fmt.Errorf("Search: %s", resultError(code, message))
+			}
+		default:
+			return nil, fmt.Errorf("Unexpected response 0x%02x", op.tag)
+		}
+	}
+}
+
+func parseEntry(op element) (entry, error) {
+	e := entry{attributes: make(map[string][]string)}
+
+	parts, err := parse(op.content)
+	if err != nil {
+		return e, err
+	}
+
+	if len(parts) < 2 || parts[0].tag != tagOctets {
+		return e, errMalformed
+	}
+	e.dn = string(parts[0].content)
+
+	attributes, err := parse(parts[1].content)
+	if err != nil {
+		return e, err
+	}
+
+	for _, attribute := range attributes {
+		fields, err := parse(attribute.content)
+		if err != nil {
+			return e, err
+		}
+		if len(fields) < 2 {
+			return e, errMalformed
+		}
+
+		values, err := parse(fields[1].content)
+		if err != nil {
+			return e, err
+		}
+
+		name := strings.ToLower(string(fields[0].content))
+		for _, value := range values {
+			e.attributes[name] = append(e.attributes[name], string(value.content))
+		}
+	}
+
+	return e, nil
+}
@@ -0,0 +1,234 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+// Package ldap provide APIs for getting or setting the LDAP authentication
+package ldap
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"strconv"
+)
+
+const (
+	SECURITY_NONE     = "none"
+	SECURITY_STARTTLS = "starttls"
+	SECURITY_LDAPS    = "ldaps"
+
+	ldap_port  = 389
+	ldaps_port = 636
+
+	default_user_attribute  = "uid"
+	default_group_attribute = "memberOf"
+)
+
+var hostnameRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?)*$`)
+
+type (
+	Config struct {
+		Fallback bool     `json:"fallback"`
+		Enabled  bool     `json:"enable"`
+		Servers  []Server `json:"servers"`
+		Security string   `json:"security"`
+		// PEM file of the CAs trusted for the servers, the system ones if empty
+		CACert string `json:"ca_cert"`
+
+		BaseDN string `json:"base_dn"`
+		// account used to look users up, anonymous if empty
+		BindDN       string `json:"bind_dn"`
+		BindPassword string `json:"bind_password"`
+
+		UserAttribute  string `json:"user_attribute"`
+		GroupAttribute string `json:"group_attribute"`
+		// members of these groups are administrators
+		AdminGroups []string `json:"admin_groups"`
+		// when set, only members of these groups or the admin groups may log in
+		UserGroups []string `json:"user_groups"`
+	}
+
+	Server struct {
+		Host string `json:"host"`
+		Port uint16 `json:"port"`
+	}
+)
+
+// Legacy has nothing to read, LDAP did not exist in the legacy system
+func (cfg *Config) Legacy(legacyRoot string) {
+	cfg.Factory()
+}
+
+func (config *Config) CopyFrom(otherConfig Config) {
+	config.Enabled = otherConfig.Enabled
+	config.Fallback = otherConfig.Fallback
+	config.Security = otherConfig.Security
+	config.CACert = otherConfig.CACert
+	config.BaseDN = otherConfig.BaseDN
+	config.BindDN = otherConfig.BindDN
+	config.BindPassword = otherConfig.BindPassword
+	config.UserAttribute = otherConfig.UserAttribute
+	config.GroupAttribute = otherConfig.GroupAttribute
+
+	config.Servers = make([]Server, len(otherConfig.Servers))
+	copy(config.Servers, otherConfig.Servers)
+
+	config.AdminGroups = make([]string, len(otherConfig.AdminGroups))
+	copy(config.AdminGroups, otherConfig.AdminGroups)
+
+	config.UserGroups = make([]string, len(otherConfig.UserGroups))
+	copy(config.UserGroups, otherConfig.UserGroups)
+}
+
+func (config *Config) CopyFromInterface(data interface{}) bool {
+	otherConfig, ok := data.(*Config)
+	if !ok {
+		return false
+	}
+
+	config.CopyFrom(*otherConfig)
+	return true
+}
+
+func (config *Config) CloneInterface() interface{} {
+	return config.Clone()
+}
+
+func (config *Config) Clone() *Config {
+	newConfig := new(Config)
+	newConfig.CopyFrom(*config)
+
+	return newConfig
+}
+
+func (config *Config) SaveInterface(data interface{}) (bool, []error) {
+	oldConfig, ok := data.(*Config)
+	if !ok {
+		return false, nil
+	}
+
+	return true, config.Save(*oldConfig)
+}
+
+// Save only fills in the defaults, the API talks to the servers directly and
+// shell logins do not use LDAP
+func (cfg *Config) Save(oldConfig Config) (errors []error) {
+
+	if cfg.Security == "" {
+		cfg.Security = SECURITY_NONE
+	}
+
+	if cfg.UserAttribute == "" {
+		cfg.UserAttribute = default_user_attribute
+	}
+
+	if cfg.GroupAttribute == "" {
+		cfg.GroupAttribute = default_group_attribute
+	}
+
+	for idx := range cfg.Servers {
+		if cfg.Servers[idx].Port == 0 {
+			cfg.Servers[idx].Port = cfg.defaultPort()
+		}
+	}
+
+	return
+}
+
+func (cfg *Config) Verify() (errs []error) {
+
+	if len(cfg.Servers) == 0 && cfg.Enabled {
+		err := fmt.Errorf("Enable LDAP service requires at least 1 server")
+		errs = append(errs, err)
+		return
+	}
+
+	if cfg.BaseDN == "" && cfg.Enabled {
+		errs = append(errs, fmt.Errorf("Enable LDAP service requires a base DN"))
+	}
+
+	switch cfg.Security {
+	case "", SECURITY_NONE, SECURITY_STARTTLS, SECURITY_LDAPS:
+	default:
+		errs = append(errs, fmt.Errorf("Bad LDAP security: %s", cfg.Security))
+	}
+
+	if cfg.CACert != "" {
+		if _, err := loadCACert(cfg.CACert); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if cfg.BindDN == "" && cfg.BindPassword != "" {
+		errs = append(errs, fmt.Errorf("Can not have bind password without bind DN"))
+	}
+
+	for idx, server := range cfg.Servers {
+		if net.ParseIP(server.Host) == nil && !hostnameRegexp.MatchString(server.Host) {
+			err := fmt.Errorf("Bad LDAP server host: %s", server.Host)
+			errs = append(errs, err)
+		}
+
+		for i := idx + 1; i < len(cfg.Servers); i++ {
+			if server.Host == cfg.Servers[i].Host && server.Port == cfg.Servers[i].Port {
+				err := fmt.Errorf("Duplicate LDAP server: %s", server.Host)
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	for _, group := range append(cfg.AdminGroups, cfg.UserGroups...) {
+		if group == "" {
+			errs = append(errs, fmt.Errorf("Can not have empty group DN"))
+		}
+	}
+
+	return
+}
+
+func (cfg *Config) Factory() {
+	cfg.Servers = []Server{}
+	cfg.Enabled = false
+	cfg.Fallback = false
+	cfg.Security = SECURITY_STARTTLS
+	cfg.CACert = ""
+	cfg.BaseDN = ""
+	cfg.BindDN = ""
+	cfg.BindPassword = ""
+	cfg.UserAttribute = default_user_attribute
+	cfg.GroupAttribute = default_group_attribute
+	cfg.AdminGroups = []string{}
+	cfg.UserGroups = []string{}
+
+	return
+}
+
+func (cfg *Config) defaultPort() uint16 {
+	if cfg.Security == SECURITY_LDAPS {
+		return ldaps_port
+	}
+	return ldap_port
+}
+
+func (cfg *Config) address(server Server) string {
+	port := server.Port
+	if port == 0 {
+		port = cfg.defaultPort()
+	}
+
+	return net.JoinHostPort(server.Host, strconv.Itoa(int(port)))
+}
+
+func loadCACert(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("No certificate in LDAP CA file: %s", path)
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,304 @@
+package ldap
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const (
+	test_base    = "dc=example,dc=com"
+	test_bind_dn = "cn=svc,dc=example,dc=com"
+	test_bind_pw = "svcpass"
+
+	test_admins = "cn=admins,ou=groups,dc=example,dc=com"
+	test_users  = "cn=users,ou=groups,dc=example,dc=com"
+)
+
+type test_user struct {
+	dn       string
+	password string
+	groups   []string
+}
+
+var test_directory = map[string]test_user{
+	"alice": {"uid=alice,ou=people,dc=example,dc=com", "alicepass", []string{test_users, test_admins}},
+	"bob":   {"uid=bob,ou=people,dc=example,dc=com", "bobpass", []string{test_users}},
+	"carol": {"uid=carol,ou=people,dc=example,dc=com", "carolpass", []string{}},
+}
+
+// standIn is a minimal LDAP server answering simple binds, equality searches
+// on uid and StartTLS from test_directory. Searches need the service bind.
+type standIn struct {
+	listener net.Listener
+	tls      *tls.Config
+	ldaps    bool
+}
+
+func newStandIn(t *testing.T, tlsConfig *tls.Config, ldaps bool) *standIn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("[err] listen:", err)
+	}
+
+	s := &standIn{listener: listener, tls: tlsConfig, ldaps: ldaps}
+	go s.serve()
+	return s
+}
+
+func (s *standIn) server() Server {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return Server{Host: host, Port: uint16(p)}
+}
+
+func (s *standIn) close() {
+	s.listener.Close()
+}
+
+func (s *standIn) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		if s.ldaps {
+			conn = tls.Server(conn, s.tls)
+		}
+		go s.handle(conn)
+	}
+}
+
+func reply(id int, op []byte) []byte {
+	return encodeSequence(tagSequence, encodeInt(tagInteger, id), op)
+}
+
+func ldapResult(tag byte, code int) []byte {
+	return encodeSequence(tag, encodeInt(tagEnumerated, code), encodeString(tagOctets, ""), encodeString(tagOctets, ""))
+}
+
+func (s *standIn) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+
+	r := bufio.NewReader(conn)
+	service := false
+
+	for {
+		message, err := read(r)
+		if err != nil {
+			return
+		}
+
+		parts, _ := parse(message.content)
+		id, _ := decodeInt(parts[0].content)
+		op := parts[1]
+		fields, _ := parse(op.content)
+
+		switch op.tag {
+		case tagBindRequest:
+			dn, password := string(fields[1].content), string(fields[2].content)
+			code := resultInvalidCredentials
+			if dn == test_bind_dn && password == test_bind_pw {
+				service = true
+				code = resultSuccess
+			}
+			for _, user := range test_directory {
+				if dn == user.dn && password == user.password {
+					code = resultSuccess
+				}
+			}
+			conn.Write(reply(id, ldapResult(tagBindResponse, code)))
+
+		case tagSearchRequest:
+			if !service {
+				conn.Write(reply(id, ldapResult(tagSearchDone, 50)))
+				continue
+			}
+
+			filter, _ := parse(fields[6].content)
+			if user, ok := test_directory[string(filter[1].content)]; ok && string(filter[0].content) == "uid" {
+				var values [][]byte
+				for _, group := range user.groups {
+					values = append(values, encodeString(tagOctets, group))
+				}
+				attribute := encodeSequence(tagSequence, encodeString(tagOctets, "memberOf"), encodeSequence(tagSet, values...))
+				entry := encodeSequence(tagSearchEntry, encodeString(tagOctets, user.dn), encodeSequence(tagSequence, attribute))
+				conn.Write(reply(id, entry))
+			}
+			conn.Write(reply(id, ldapResult(tagSearchDone, resultSuccess)))
+
+		case tagExtendedRequest:
+			conn.Write(reply(id, ldapResult(tagExtendedResponse, resultSuccess)))
+			tc := tls.Server(conn, s.tls)
+			conn, r = tc, bufio.NewReader(tc)
+
+		default:
+			return
+		}
+	}
+}
+
+// newCertificate writes a self-signed certificate for 127.0.0.1 to dir and
+// returns the server side TLS config with the path of the PEM file
+func newCertificate(t *testing.T, dir string) (*tls.Config, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("[err] key:", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ldap stand-in"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal("[err] certificate:", err)
+	}
+
+	path := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal("[err] write certificate:", err)
+	}
+
+	certificate := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	return &tls.Config{Certificates: []tls.Certificate{certificate}}, path
+}
+
+func testConfig(security string, servers ...Server) Config {
+	var cfg Config
+	cfg.Factory()
+	cfg.Enabled = true
+	cfg.Security = security
+	cfg.Servers = servers
+	cfg.BaseDN = test_base
+	cfg.BindDN = test_bind_dn
+	cfg.BindPassword = test_bind_pw
+	cfg.AdminGroups = []string{test_admins}
+	return cfg
+}
+
+func TestAuthenticate(t *testing.T) {
+	server := newStandIn(t, nil, false)
+	defer server.close()
+
+	cfg := testConfig(SECURITY_NONE, server.server())
+
+	t.Log("[case] Test admin group member")
+	privileged, groups, ok, errs := cfg.Authenticate("alice", "alicepass")
+	if len(errs) > 0 || !ok || !privileged || len(groups) != 2 {
+		t.Error("[err] alice:", privileged, groups, ok, errs)
+	}
+
+	t.Log("[case] Test user")
+	privileged, _, ok, errs = cfg.Authenticate("bob", "bobpass")
+	if len(errs) > 0 || !ok || privileged {
+		t.Error("[err] bob:", privileged, ok, errs)
+	}
+
+	t.Log("[case] Test rejected logins")
+	for _, login := range [][2]string{{"alice", "wrong"}, {"alice", ""}, {"mallory", "alicepass"}} {
+		if _, _, ok, errs := cfg.Authenticate(login[0], login[1]); ok || len(errs) > 0 {
+			t.Error("[err]", login[0], ok, errs)
+		}
+	}
+
+	t.Log("[case] Test user groups")
+	cfg.UserGroups = []string{test_users}
+	if _, _, ok, errs := cfg.Authenticate("carol", "carolpass"); ok || len(errs) > 0 {
+		t.Error("[err] carol not in user groups:", ok, errs)
+	}
+	if _, _, ok, errs := cfg.Authenticate("bob", "bobpass"); !ok || len(errs) > 0 {
+		t.Error("[err] bob in user groups:", ok, errs)
+	}
+
+	t.Log("[case] Test bad service account")
+	cfg.BindPassword = "wrong"
+	if _, _, ok, errs := cfg.Authenticate("bob", "bobpass"); ok || len(errs) != 2 || errs[0].Error() != LdapAuthError {
+		t.Error("[err] expected errors, got:", ok, errs)
+	}
+}
+
+func TestTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ldap")
+	if err != nil {
+		t.Fatal("[err] temp dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tlsConfig, caCert := newCertificate(t, dir)
+
+	starttls := newStandIn(t, tlsConfig, false)
+	defer starttls.close()
+
+	ldaps := newStandIn(t, tlsConfig, true)
+	defer ldaps.close()
+
+	t.Log("[case] Test StartTLS")
+	cfg := testConfig(SECURITY_STARTTLS, starttls.server())
+	cfg.CACert = caCert
+	if privileged, _, ok, errs := cfg.Authenticate("alice", "alicepass"); !ok || !privileged || len(errs) > 0 {
+		t.Error("[err] StartTLS:", ok, errs)
+	}
+
+	t.Log("[case] Test LDAPS")
+	cfg = testConfig(SECURITY_LDAPS, ldaps.server())
+	cfg.CACert = caCert
+	if _, _, ok, errs := cfg.Authenticate("bob", "bobpass"); !ok || len(errs) > 0 {
+		t.Error("[err] LDAPS:", ok, errs)
+	}
+
+	t.Log("[case] Test untrusted certificate")
+	cfg.CACert = ""
+	if _, _, ok, errs := cfg.Authenticate("bob", "bobpass"); ok || len(errs) == 0 {
+		t.Error("[err] expected errors, got:", ok)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	t.Log("[case] Test verify LDAP config")
+
+	var cfg Config
+	cfg.Factory()
+	if errs := cfg.Verify(); len(errs) > 0 {
+		t.Error("[err] factory config:", errs)
+	}
+
+	cfg.Enabled = true
+	if errs := cfg.Verify(); len(errs) != 1 {
+		t.Error("[err] expected 1 error, got:", errs)
+	}
+
+	cfg.Security = "ssl"
+	cfg.CACert = "/nonexistent/ca.pem"
+	cfg.BindPassword = "secret"
+	cfg.AdminGroups = []string{""}
+	cfg.Servers = []Server{
+		{Host: "ldap.example.com"},
+		{Host: "ldap.example.com"},
+		{Host: "bad host"},
+	}
+	if errs := cfg.Verify(); len(errs) != 7 {
+		t.Error("[err] expected 7 errors, got:", errs)
+	}
+}
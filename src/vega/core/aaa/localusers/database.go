@@ -0,0 +1,229 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+package localusers
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// taken by lckpwdf(3) of glibc, shadow-utils takes it too
+	lock_file    = "/etc/.pwd.lock"
+	lock_timeout = 15 * time.Second
+	lock_retry   = 100 * time.Millisecond
+
+	passwd_fields = 7
+	shadow_fields = 9
+	group_fields  = 4
+
+	passwd_mode = 0644
+	shadow_mode = 0600
+	group_mode  = 0644
+)
+
+// root is the directory the user databases are under, "" for the system
+var root = ""
+
+// SetRoot makes the users to be read from and written to the databases
+// under rootPath
+func SetRoot(rootPath string) {
+	root = rootPath
+}
+
+// lockDatabase takes the locks shadow-utils takes before it edits the user
+// databases, the lckpwdf(3) lock and a lock file per database, so that
+// useradd, userdel, gpasswd and chpasswd wait for the edit to be done
+func lockDatabase(rootPath string) (unlock func(), err error) {
+	file, err := os.OpenFile(rootPath+lock_file, os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := syscall.Flock_t{Type: syscall.F_WRLCK}
+	deadline := time.Now().Add(lock_timeout)
+	for {
+		err = syscall.FcntlFlock(file.Fd(), syscall.F_SETLK, &lock)
+		if err == nil {
+			break
+		}
+
+		if (err != syscall.EAGAIN && err != syscall.EACCES) || time.Now().After(deadline) {
+			file.Close()
+			return nil, fmt.Errorf("Can not lock the user databases: %s", err)
+		}
+		time.Sleep(lock_retry)
+	}
+
+	locked := []string{}
+	unlock = func() {
+		for _, path := range locked {
+			os.Remove(path)
+		}
+		// closing releases the lckpwdf(3) lock
+		file.Close()
+	}
+
+	for _, database := range []string{user_file, pass_file, group_file} {
+		path := rootPath + database + ".lock"
+		if err = lockFile(path, deadline); err != nil {
+			unlock()
+			return nil, err
+		}
+		locked = append(locked, path)
+	}
+
+	return unlock, nil
+}
+
+// lockFile creates the lock file path holding the pid, linked from a
+// temporary file like shadow-utils does so that only one process gets it.
+// The lock of a process that is gone is taken over.
+func lockFile(path string, deadline time.Time) error {
+	pid := strconv.Itoa(os.Getpid())
+	temp := strings.TrimSuffix(path, ".lock") + "." + pid
+	if err := ioutil.WriteFile(temp, []byte(pid), 0600); err != nil {
+		return err
+	}
+	defer os.Remove(temp)
+
+	for {
+		err := os.Link(temp, path)
+		if err == nil {
+			return nil
+		} else if !os.IsExist(err) {
+			return err
+		}
+
+		if stale(path) {
+			os.Remove(path)
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Can not lock the user databases: %s exists", path)
+		}
+		time.Sleep(lock_retry)
+	}
+}
+
+// stale tells whether the process of a lock file is gone
+func stale(path string) bool {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return false
+	}
+
+	return syscall.Kill(pid, 0) == syscall.ESRCH
+}
+
+// readDatabase returns the entries of a colon separated database, every
+// entry must have the number of fields
+func readDatabase(path string, fields int) ([][]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := [][]string{}
+	for idx, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+
+		entry := strings.Split(line, ":")
+		if len(entry) != fields {
+			return nil, fmt.Errorf("Bad line %d of %s: %d fields, expected %d", idx+1, path, len(entry), fields)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// writeDatabase replaces the database at path by entries. They are written
+// to a temporary file with the mode and owner of the database, synced and
+// renamed over it, so a reader sees either the old or the new database.
+// mode is for a database that does not exist yet.
+func writeDatabase(path string, entries [][]string, fields int, mode os.FileMode) (err error) {
+	var buf bytes.Buffer
+	for idx, entry := range entries {
+		if len(entry) != fields {
+			return fmt.Errorf("Bad entry %d of %s: %d fields, expected %d", idx+1, path, len(entry), fields)
+		}
+
+		for _, field := range entry {
+			if strings.ContainsAny(field, ":\n") {
+				return fmt.Errorf("Bad entry %d of %s: field %q", idx+1, path, field)
+			}
+		}
+
+		buf.WriteString(strings.Join(entry, ":"))
+		buf.WriteByte('\n')
+	}
+
+	uid, gid := os.Getuid(), os.Getgid()
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			uid, gid = int(stat.Uid), int(stat.Gid)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	// the name shadow-utils writes to
+	temp := path + "+"
+	file, err := os.OpenFile(temp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(temp)
+		}
+	}()
+
+	// the mode given to open is masked by the umask
+	if err = file.Chmod(mode); err != nil {
+		return err
+	}
+	if err = file.Chown(uid, gid); err != nil {
+		return err
+	}
+	if _, err = file.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(temp, path); err != nil {
+		return err
+	}
+
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
@@ -0,0 +1,109 @@
+package localusers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "database")
+	if err != nil {
+		t.Fatal("[err] temp dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Mkdir(filepath.Join(dir, "etc"), 0755); err != nil {
+		t.Fatal("[err] mkdir:", err)
+	}
+
+	files := map[string]string{
+		user_file:  "root:x:0:0:root:/root:/bin/bash\n",
+		pass_file:  "root:*:17000:0:99999:7:::\n",
+		group_file: "root:x:0:\nwheel:x:10:root\nusers:x:100:\n",
+	}
+	for file, data := range files {
+		if err := ioutil.WriteFile(dir+file, []byte(data), 0640); err != nil {
+			t.Fatal("[err] write:", err)
+		}
+	}
+
+	t.Log("[case] Test lock")
+	unlock, err := lockDatabase(dir)
+	if err != nil {
+		t.Fatal("[err] lock:", err)
+	}
+	if _, err := os.Stat(dir + pass_file + ".lock"); err != nil {
+		t.Error("[err] no lock file:", err)
+	}
+
+	t.Log("[case] Test write")
+	users := []User{
+		{Username: "test", Password: default_password, Privilege: PRIVILEGE_ADMIN},
+	}
+	if err := add_to_passwd(dir, users); err != nil {
+		t.Error("[err] passwd:", err)
+	}
+	if err := add_to_shadow(dir, users); err != nil {
+		t.Error("[err] shadow:", err)
+	}
+	if err := add_to_group(dir, users); err != nil {
+		t.Error("[err] group:", err)
+	}
+
+	unlock()
+	if _, err := os.Stat(dir + pass_file + ".lock"); !os.IsNotExist(err) {
+		t.Error("[err] lock file left:", err)
+	}
+
+	entries, err := readDatabase(dir+pass_file, shadow_fields)
+	if err != nil || len(entries) != 2 || entries[1][1] != default_password {
+		t.Error("[err] shadow entries:", entries, err)
+	}
+
+	data, _ := ioutil.ReadFile(dir + group_file)
+	if !strings.Contains(string(data), "wheel:x:10:root,admin,test\n") {
+		t.Error("[err] group:", string(data))
+	}
+
+	for file := range files {
+		info, err := os.Stat(dir + file)
+		if err != nil || info.Mode().Perm() != 0640 {
+			t.Error("[err] mode of", file, "not kept:", info.Mode(), err)
+		}
+		if _, err := os.Stat(dir + file + "+"); !os.IsNotExist(err) {
+			t.Error("[err] temporary file left:", file)
+		}
+	}
+
+	t.Log("[case] Test remove")
+	if err := remove_from_databases(dir, []string{"test"}); err != nil {
+		t.Error("[err] remove:", err)
+	}
+	for file := range files {
+		data, _ := ioutil.ReadFile(dir + file)
+		if strings.Contains(string(data), "test") {
+			t.Error("[err] user left in", file, ":", string(data))
+		}
+	}
+	data, _ = ioutil.ReadFile(dir + group_file)
+	if !strings.Contains(string(data), "wheel:x:10:root,admin\n") {
+		t.Error("[err] group:", string(data))
+	}
+	if err := remove_from_databases(dir, []string{"root"}); err == nil {
+		t.Error("[err] reserved user removed")
+	}
+
+	t.Log("[case] Test bad entries")
+	if err := ioutil.WriteFile(dir+user_file, []byte("root:x:0:0\n"), 0644); err != nil {
+		t.Fatal("[err] write:", err)
+	}
+	if err := add_to_passwd(dir, users); err == nil {
+		t.Error("[err] short line accepted")
+	}
+	if err := writeDatabase(dir+group_file, [][]string{{"bad:", "x", "1", ""}}, group_fields, group_mode); err == nil {
+		t.Error("[err] colon in field accepted")
+	}
+}
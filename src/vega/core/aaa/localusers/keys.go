@@ -0,0 +1,474 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+package localusers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// all the local users share their home directory, so their keys are
+	// kept per user and sshd is configured to read them there
+	keys_dir  = "/etc/ssh/authorized_keys"
+	keys_mode = 0600
+
+	sshd_config      = "/etc/ssh/sshd_config"
+	sshd_pid_file    = "/var/run/sshd.pid"
+	sshd_keys_option = "AuthorizedKeysFile"
+	// files sshd reads the keys from when the option is not set
+	sshd_keys_default = ".ssh/authorized_keys .ssh/authorized_keys2"
+
+	rsa_min_bits       = 2048
+	fingerprint_prefix = "SHA256:"
+)
+
+const (
+	KEY_RSA        = "ssh-rsa"
+	KEY_ED25519    = "ssh-ed25519"
+	KEY_ECDSA256   = "ecdsa-sha2-nistp256"
+	KEY_ECDSA384   = "ecdsa-sha2-nistp384"
+	KEY_ECDSA521   = "ecdsa-sha2-nistp521"
+	KEY_SK_ED25519 = "sk-ssh-ed25519@openssh.com"
+	KEY_SK_ECDSA   = "sk-ecdsa-sha2-nistp256@openssh.com"
+)
+
+var errKeyFormat = errors.New("Bad SSH public key")
+
+// curves of the ECDSA key types
+var ecdsaCurves = map[string]string{
+	KEY_ECDSA256: "nistp256",
+	KEY_ECDSA384: "nistp384",
+	KEY_ECDSA521: "nistp521",
+	KEY_SK_ECDSA: "nistp256",
+}
+
+// Key is an SSH public key of a user
+type Key struct {
+	Type        string `json:"type"`
+	Bits        int    `json:"bits"`
+	Fingerprint string `json:"fingerprint"`
+	Comment     string `json:"comment,omitempty"`
+	// the authorized_keys line of the key
+	Key string `json:"key"`
+}
+
+// ParseKey parses a public key in the authorized_keys format
+// "type base64 [comment]", without options
+func ParseKey(line string) (key Key, err error) {
+	line = strings.TrimSpace(line)
+	if strings.ContainsAny(line, "\r\n") {
+		return key, errKeyFormat
+	}
+
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) < 2 {
+		return key, errKeyFormat
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return key, errKeyFormat
+	}
+
+	key.Type = fields[0]
+	key.Bits, err = keyBits(key.Type, blob)
+	if err != nil {
+		return key, err
+	}
+
+	sum := sha256.Sum256(blob)
+	key.Fingerprint = fingerprint_prefix + base64.RawStdEncoding.EncodeToString(sum[:])
+
+	key.Key = key.Type + " " + fields[1]
+	if len(fields) == 3 {
+		key.Comment = strings.TrimSpace(fields[2])
+		if key.Comment != "" {
+			key.Key += " " + key.Comment
+		}
+	}
+
+	return key, nil
+}
+
+// keyBits checks the wire format blob of a public key of keyType and
+// returns its length in bits
+func keyBits(keyType string, blob []byte) (int, error) {
+	name, blob, ok := readString(blob)
+	if !ok || string(name) != keyType {
+		return 0, errKeyFormat
+	}
+
+	switch keyType {
+	case KEY_RSA:
+		e, blob, ok := readString(blob)
+		if !ok {
+			return 0, errKeyFormat
+		}
+		n, blob, ok := readString(blob)
+		if !ok || len(blob) != 0 || len(e) == 0 || e[len(e)-1]&1 == 0 {
+			return 0, errKeyFormat
+		}
+
+		bits := new(big.Int).SetBytes(n).BitLen()
+		if bits < rsa_min_bits {
+			return 0, fmt.Errorf("RSA key of %d bits, expected at least %d", bits, rsa_min_bits)
+		}
+		return bits, nil
+	case KEY_ED25519, KEY_SK_ED25519:
+		pub, blob, ok := readString(blob)
+		if !ok || len(pub) != 32 {
+			return 0, errKeyFormat
+		}
+		if keyType == KEY_SK_ED25519 {
+			if _, blob, ok = readString(blob); !ok {
+				return 0, errKeyFormat
+			}
+		}
+		if len(blob) != 0 {
+			return 0, errKeyFormat
+		}
+		return 256, nil
+	case KEY_ECDSA256, KEY_ECDSA384, KEY_ECDSA521, KEY_SK_ECDSA:
+		curve, blob, ok := readString(blob)
+		if !ok || string(curve) != ecdsaCurves[keyType] {
+			return 0, errKeyFormat
+		}
+		bits, err := strconv.Atoi(strings.TrimPrefix(string(curve), "nistp"))
+		if err != nil {
+			return 0, errKeyFormat
+		}
+
+		// an uncompressed point
+		point, blob, ok := readString(blob)
+		if !ok || len(point) != 1+2*((bits+7)/8) || point[0] != 4 {
+			return 0, errKeyFormat
+		}
+		if keyType == KEY_SK_ECDSA {
+			if _, blob, ok = readString(blob); !ok {
+				return 0, errKeyFormat
+			}
+		}
+		if len(blob) != 0 {
+			return 0, errKeyFormat
+		}
+		return bits, nil
+	}
+
+	return 0, fmt.Errorf("Unsupported SSH key type: %s", keyType)
+}
+
+// readString reads a string of the SSH wire format
+func readString(data []byte) (value, rest []byte, ok bool) {
+	if len(data) < 4 {
+		return nil, nil, false
+	}
+
+	length := binary.BigEndian.Uint32(data)
+	if uint64(length) > uint64(len(data)-4) {
+		return nil, nil, false
+	}
+
+	return data[4 : 4+length], data[4+length:], true
+}
+
+// verifyKeys checks the keys of a user
+func verifyKeys(user User) (errs []error) {
+	fingerprints := make(map[string]bool)
+
+	for idx, line := range user.Keys {
+		key, err := ParseKey(line)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Bad SSH key %d of the user %s: %s", idx+1, user.Username, err))
+			continue
+		}
+
+		if fingerprints[key.Fingerprint] {
+			errs = append(errs, fmt.Errorf("Duplicate SSH key %s of the user: %s", key.Fingerprint, user.Username))
+		}
+		fingerprints[key.Fingerprint] = true
+	}
+
+	return errs
+}
+
+// ParsedKeys returns the parsed SSH keys of the user
+func (user User) ParsedKeys() (keys []Key) {
+	keys = []Key{}
+	for _, line := range user.Keys {
+		if key, err := ParseKey(line); err == nil {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// FindKey returns the index of the key of the user with the fingerprint,
+// -1 if it has none. The "SHA256:" prefix of the fingerprint is optional.
+func (user User) FindKey(fingerprint string) int {
+	fingerprint = fingerprint_prefix + strings.TrimPrefix(fingerprint, fingerprint_prefix)
+
+	for idx, line := range user.Keys {
+		if key, err := ParseKey(line); err == nil && key.Fingerprint == fingerprint {
+			return idx
+		}
+	}
+
+	return -1
+}
+
+// readKeys returns the keys of the authorized_keys file of username
+func readKeys(rootPath, username string) ([]string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(rootPath+keys_dir, username))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+
+	return keys, nil
+}
+
+// SetKeys replaces the SSH keys of a user on the system
+func SetKeys(username string, keys []string) (err error) {
+	for _, line := range keys {
+		if _, err = ParseKey(line); err != nil {
+			return
+		}
+	}
+
+	entries, err := readDatabase(root+user_file, passwd_fields)
+	if err != nil {
+		return
+	}
+
+	for _, fields := range entries {
+		if fields[0] == username {
+			return writeKeys(root, fields, keys)
+		}
+	}
+
+	return errors.New("User not found")
+}
+
+// writeKeys writes the authorized_keys file of the passwd entry of a user,
+// owned by the user and only readable by it
+func writeKeys(rootPath string, entry []string, keys []string) (err error) {
+	path := filepath.Join(rootPath+keys_dir, entry[0])
+	if len(keys) == 0 {
+		err = os.Remove(path)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	uid, err := strconv.Atoi(entry[2])
+	if err != nil {
+		return
+	}
+	gid, err := strconv.Atoi(entry[3])
+	if err != nil {
+		return
+	}
+
+	if err = os.MkdirAll(rootPath+keys_dir, 0755); err != nil {
+		return
+	}
+
+	var buf bytes.Buffer
+	for _, line := range keys {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+
+	temp := path + "+"
+	file, err := os.OpenFile(temp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, keys_mode)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(temp)
+		}
+	}()
+
+	if err = file.Chmod(keys_mode); err != nil {
+		return
+	}
+	if err = file.Chown(uid, gid); err != nil {
+		return
+	}
+	if _, err = file.Write(buf.Bytes()); err != nil {
+		return
+	}
+	if err = file.Sync(); err != nil {
+		return
+	}
+	if err = file.Close(); err != nil {
+		return
+	}
+
+	return os.Rename(temp, path)
+}
+
+// save_keys writes the authorized_keys files of users and removes those of
+// the local users not in users
+func save_keys(rootPath string, users []User) error {
+	if err := configure_sshd(rootPath); err != nil {
+		return err
+	}
+
+	entries, err := readDatabase(rootPath+user_file, passwd_fields)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string][]string)
+	for _, user := range users {
+		keys[user.Username] = user.Keys
+	}
+
+	// the files of the removed users
+	exist := make(map[string]bool)
+	for _, entry := range entries {
+		exist[entry[0]] = true
+	}
+	files, err := ioutil.ReadDir(rootPath + keys_dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, file := range files {
+		if !exist[file.Name()] {
+			os.Remove(filepath.Join(rootPath+keys_dir, file.Name()))
+		}
+	}
+
+	for _, entry := range entries {
+		uid, _ := strconv.Atoi(entry[2])
+		if entry[0] == RADIUS_USER || uid < uid_local {
+			continue
+		}
+
+		if err = writeKeys(rootPath, entry, keys[entry[0]]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// configure_sshd points sshd to the files of keys_dir too. The files the
+// option already named are kept, on the same line; it goes first, before any
+// Match block, so that it applies to every login. sshd reloads its
+// configuration when the option changed.
+func configure_sshd(rootPath string) error {
+	path := rootPath + sshd_config
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		// no sshd, no keys to read
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// sshd uses the first value given, its default without any
+	var files []string
+	lines := []string{""}
+	old := strings.Split(string(data), "\n")
+	for idx, line := range old {
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ' ' || r == '\t' || r == '='
+		})
+		if len(fields) > 0 && strings.EqualFold(fields[0], "Match") {
+			lines = append(lines, old[idx:]...)
+			break
+		}
+		if len(fields) > 0 && strings.EqualFold(fields[0], sshd_keys_option) {
+			if files == nil {
+				files = fields[1:]
+			}
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	if files == nil {
+		files = strings.Fields(sshd_keys_default)
+	}
+
+	keys := keys_dir + "/%u"
+	value := []string{}
+	for _, file := range files {
+		if file != keys && file != "none" {
+			value = append(value, file)
+		}
+	}
+	lines[0] = strings.Join(append([]string{sshd_keys_option}, append(value, keys)...), " ")
+
+	config := []byte(strings.Join(lines, "\n"))
+	if bytes.Equal(config, data) {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	temp := path + "+"
+	if err = ioutil.WriteFile(temp, config, info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err = os.Rename(temp, path); err != nil {
+		os.Remove(temp)
+		return err
+	}
+
+	return reload_sshd(rootPath)
+}
+
+// reload_sshd tells the running sshd to read its configuration again
+func reload_sshd(rootPath string) error {
+	data, err := ioutil.ReadFile(rootPath + sshd_pid_file)
+	if os.IsNotExist(err) {
+		// not running, it reads the configuration when started
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 1 {
+		return fmt.Errorf("Bad sshd pid file: %s", sshd_pid_file)
+	}
+
+	err = syscall.Kill(pid, syscall.SIGHUP)
+	if err == syscall.ESRCH {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,155 @@
+package localusers
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+)
+
+const (
+	testKeyEd25519 = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOHR1qf3LiGKmGk+DfvyV/fwQuqr/i+JYQ998cHjuaLl test@ed25519"
+	testKeyRSA     = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABgQDJou0XmfyvczPgq2jYU0b+47RkSkFaWQuNDS9qIv1APRDazMv0t7APIOFWZILxMs2dJ77PeZyhXGVOhIZXY9vIUl+SGe9Y6a+hW4sRI3ouZ99dvsNmA7FxPORyWMJWMtf6dmoFS+irn7EwWHe3e82L+Tf/4phTH0psXl+0V8Qnf5v5/CmuVfmJ/x/NE7HNb3TVnF60A+wDXwp7x+hdxWsZXZwDz/xLB0vBvCjhbZVsHt7ftTDZ+3ykUuyy2V7hGrZZDgyxJEhot8lcnNudAjLQJy4sdBGWABXfC1Dv10cjtj3G9zjCl6byB1/Ne9QIrlkX5/GA2WSiKw1XsChmVnmvavXIugzVE/p2kg41ogZRHpdmUpYLqgOj7w/VnOl9lQWu8ziBWyqiHuIxNrUPRbbDKfsyq7NkSz+uXjgUkj+EhEbtJb/nQErSJQkqC1yLkUEDo8xUpJc76VsOWDpQyC6uc4ipY8T96+tvcr2PlhBGKg0sUu/yaL+nJUlmgvgYOUc= test@rsa3072"
+	testKeyRSA1024 = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAAgQDVPZLme2QvAtlwClCIS3+vKeNOe+Oc78YJeufIKxXMv44KBy+BPb2MAmvenpLAaUrmPAiLsWulzsBLhWK8rIBOH65bvekYRlmdD100jpHHaGRq7nbKdwZNU33cZ5dd5thziOAe/DYuAqwtMugUxEh8BSMY5viyH44wG6gfl/nmGQ== test@rsa1024"
+	testKeyECDSA   = "ecdsa-sha2-nistp384 AAAAE2VjZHNhLXNoYTItbmlzdHAzODQAAAAIbmlzdHAzODQAAABhBBFU0rT7geQJb2AXm3/3+FmZWmWl7fWNcOeYYHhRyC02RSB3E571j2+7ym0bYYvtWTTs1m1nGlC4eet6TIISrum3pKjGeFkBFYFrhIGqSisLRE5fxZNEjwGAnzrNU1AjCA=="
+)
+
+func TestParseKey(t *testing.T) {
+	t.Log("[case] Test good keys")
+	// fingerprints of ssh-keygen -l
+	keys := []struct {
+		line        string
+		bits        int
+		fingerprint string
+	}{
+		{testKeyEd25519, 256, "SHA256:FNQmRdX6YQAJudPxF7/sO7HV1rh/QWv5ZXJG+vhSCK8"},
+		{testKeyRSA, 3072, "SHA256:Cag5hjlIfK3hDWEKsaGg/GspFktSKKMLATNBXtJn3CE"},
+		{testKeyECDSA, 384, "SHA256:zF6RJhDXH8103+dzWQCjl2ubjUr6uqDe8tpnZGu5Lwk"},
+	}
+	for _, test := range keys {
+		key, err := ParseKey(test.line)
+		if err != nil {
+			t.Error("[err] parse:", err)
+		} else if key.Bits != test.bits || key.Fingerprint != test.fingerprint || key.Key != test.line {
+			t.Error("[err] key:", key)
+		}
+	}
+
+	t.Log("[case] Test bad keys")
+	bad := []string{
+		"",
+		"ssh-ed25519",
+		"ssh-ed25519 !!!",
+		"ssh-dss AAAAC3NzaC1lZDI1NTE5AAAAIOHR1qf3LiGKmGk+DfvyV/fwQuqr/i+JYQ998cHjuaLl",
+		"ssh-rsa AAAAC3NzaC1lZDI1NTE5AAAAIOHR1qf3LiGKmGk+DfvyV/fwQuqr/i+JYQ998cHjuaLl",
+		"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOHR1qf3LiGKmGk+DfvyV/fwQuqr/i+JYQ998cHjua==",
+		testKeyRSA1024,
+		testKeyEd25519 + "\nssh-ed25519 AAAA",
+	}
+	for _, line := range bad {
+		if _, err := ParseKey(line); err == nil {
+			t.Error("[err] bad key accepted:", line)
+		}
+	}
+}
+
+func TestVerifyKeys(t *testing.T) {
+	t.Log("[case] Test duplicate keys")
+	user := User{Username: "test", Keys: []string{testKeyEd25519, testKeyRSA, testKeyEd25519 + " again"}}
+	if errs := verifyKeys(user); len(errs) != 1 {
+		t.Error("[err] expected 1 error, got:", errs)
+	}
+
+	if idx := user.FindKey("Cag5hjlIfK3hDWEKsaGg/GspFktSKKMLATNBXtJn3CE"); idx != 1 {
+		t.Error("[err] find key:", idx)
+	}
+	if idx := user.FindKey("SHA256:none"); idx != -1 {
+		t.Error("[err] find missing key:", idx)
+	}
+}
+
+func TestWriteKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal("[err] temp dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	t.Log("[case] Test write and read keys")
+	entry := []string{"test", "x", strconv.Itoa(os.Getuid()), strconv.Itoa(os.Getgid()), "", home_dir, shell_path}
+	if err := writeKeys(dir, entry, []string{testKeyEd25519, testKeyECDSA}); err != nil {
+		t.Fatal("[err] write:", err)
+	}
+
+	info, err := os.Stat(dir + keys_dir + "/test")
+	if err != nil || info.Mode().Perm() != keys_mode {
+		t.Error("[err] mode:", info.Mode(), err)
+	}
+
+	keys, err := readKeys(dir, "test")
+	if err != nil || len(keys) != 2 || keys[1] != testKeyECDSA {
+		t.Error("[err] read:", keys, err)
+	}
+
+	t.Log("[case] Test remove keys")
+	if err := writeKeys(dir, entry, nil); err != nil {
+		t.Error("[err] remove:", err)
+	}
+	if keys, err := readKeys(dir, "test"); err != nil || len(keys) != 0 {
+		t.Error("[err] keys left:", keys, err)
+	}
+}
+
+func TestConfigureSSHD(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sshd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	t.Log("[case] Test no sshd")
+	if err := configure_sshd(dir); err != nil {
+		t.Error("[err] configure without sshd_config:", err)
+	}
+
+	if err := os.MkdirAll(dir+"/etc/ssh", 0755); err != nil {
+		t.Fatal(err)
+	}
+	config := "# sshd\nPort 22\nauthorizedkeysfile=.ssh/authorized_keys\nMatch User backup\n\tAuthorizedKeysFile /srv/backup/keys\n"
+	if err := ioutil.WriteFile(dir+sshd_config, []byte(config), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("[case] Test option set before the Match blocks")
+	if err := configure_sshd(dir); err != nil {
+		t.Error("[err] configure:", err)
+	}
+	expected := "AuthorizedKeysFile .ssh/authorized_keys /etc/ssh/authorized_keys/%u\n# sshd\nPort 22\nMatch User backup\n\tAuthorizedKeysFile /srv/backup/keys\n"
+	data, _ := ioutil.ReadFile(dir + sshd_config)
+	if string(data) != expected {
+		t.Error("[err] sshd_config:", string(data))
+	}
+	if info, err := os.Stat(dir + sshd_config); err != nil || info.Mode().Perm() != 0640 {
+		t.Error("[err] sshd_config mode:", info, err)
+	}
+
+	t.Log("[case] Test option already set")
+	if err := configure_sshd(dir); err != nil {
+		t.Error("[err] configure again:", err)
+	}
+	if data, _ := ioutil.ReadFile(dir + sshd_config); string(data) != expected {
+		t.Error("[err] sshd_config changed again:", string(data))
+	}
+
+	t.Log("[case] Test option not set")
+	if err := ioutil.WriteFile(dir+sshd_config, []byte("Port 22\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := configure_sshd(dir); err != nil {
+		t.Error("[err] configure without the option:", err)
+	}
+	expected = "AuthorizedKeysFile .ssh/authorized_keys .ssh/authorized_keys2 /etc/ssh/authorized_keys/%u\nPort 22\n"
+	if data, _ := ioutil.ReadFile(dir + sshd_config); string(data) != expected {
+		t.Error("[err] sshd_config without the option:", string(data))
+	}
+}
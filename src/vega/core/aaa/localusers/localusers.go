@@ -12,6 +12,8 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
+	"github.com/htbig/common/src/vega/core/util/crypt"
 	"github.com/htbig/common/src/vega/syslogger"
 )
 
@@ -57,9 +59,11 @@ type Config []User
 
 type (
 	User struct {
-		Username  string `json:"username"`
-		Password  string `json:"password"`
-		Privilege int    `json:"privilege"`
+		Username  string   `json:"username"`
+		Password  string   `json:"password,omitempty"`
+		Privilege int      `json:"privilege"`
+		Keys      []string `json:"keys,omitempty"`
+		TwoFactor bool     `json:"two_factor"`
 		uID       uint16
 	}
 )
@@ -129,6 +133,15 @@ func (config *Config) SaveInterface(data interface{}) (bool, []error) {
 }
 
 func (config *Config) Save(oldConfig Config) (errs []error) {
+	for _, user := range *config {
+		if user.Password != "" && !Hashed(user.Password) {
+			errs = append(errs, fmt.Errorf("Plaintext password of the user: %s", user.Username))
+		}
+	}
+	if len(errs) > 0 {
+		return
+	}
+
 	for idx, user := range *config {
 		if user.Privilege == 0 {
 			(*config)[idx].Privilege = PRIVILEGE_USER
@@ -154,37 +167,65 @@ func (config *Config) Save(oldConfig Config) (errs []error) {
 
 	var err error
 
-	users, err := parse_passwd("")
+	users, err := parse_passwd(root)
 	if err != nil {
 		errs = append(errs, err)
 		return
 	}
 
+	// the users left out are removed from the databases under root, not
+	// by userdel that knows only the system ones
+	removed := []string{}
 	for _, user := range users {
-		if user.Username == RADIUS_USER || user.Username == default_user {
+		if user.Username == RADIUS_USER || user.Username == default_user || config.has(user.Username) {
 			continue
 		}
 
-		err = RemoveUser(user.Username)
-		if err != nil {
-			errs = append(errs, err)
+		if root == "" && in_use(user.Username) {
+			errs = append(errs, fmt.Errorf("Cannot delete because user %s is logged in", user.Username))
 			return
 		}
+		removed = append(removed, user.Username)
+	}
+
+	unlock, err := lockDatabase(root)
+	if err != nil {
+		errs = append(errs, err)
+		return
+	}
+	defer unlock()
+
+	err = remove_from_databases(root, removed)
+	if err != nil {
+		errs = append(errs, err)
+		return
 	}
 
-	err = add_to_passwd(*config)
+	err = add_to_passwd(root, *config)
 	if err != nil {
 		errs = append(errs, err)
 		return
 	}
 
-	err = add_to_shadow(*config)
+	err = add_to_shadow(root, *config)
 	if err != nil {
 		errs = append(errs, err)
 		return
 	}
 
-	err = add_to_group(*config)
+	err = add_to_group(root, *config)
+	if err != nil {
+		errs = append(errs, err)
+		return
+	}
+
+	err = save_keys(root, *config)
+	if err != nil {
+		errs = append(errs, err)
+		return
+	}
+
+	err = save_two_factor(*config)
 	if err != nil {
 		errs = append(errs, err)
 		return
@@ -193,6 +234,17 @@ func (config *Config) Save(oldConfig Config) (errs []error) {
 	return
 }
 
+// has tells whether config has a user named username
+func (config *Config) has(username string) bool {
+	for _, user := range *config {
+		if user.Username == username {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (config *Config) Verify() (errs []error) {
 	errs = VerifyUsers(*config)
 
@@ -212,6 +264,41 @@ func (config *Config) Factory() {
 	return
 }
 
+// Redacted returns the users without their password hashes
+func (config *Config) Redacted() Config {
+	users := make(Config, len(*config))
+	copy(users, *config)
+
+	for idx := range users {
+		users[idx].Password = ""
+	}
+
+	return users
+}
+
+// KeepPasswords sets the users without a password to their password in
+// oldConfig, as the users are read without it
+func (config *Config) KeepPasswords(oldConfig Config) {
+	for idx, user := range *config {
+		if user.Password != "" {
+			continue
+		}
+
+		for _, oldUser := range oldConfig {
+			if oldUser.Username == user.Username {
+				(*config)[idx].Password = oldUser.Password
+				break
+			}
+		}
+	}
+}
+
+// Hashed tells whether password is a crypt hash, or locked
+func Hashed(password string) bool {
+	password = strings.TrimPrefix(password, PASSWD_LOCKED)
+	return password == "" || password == PASSWD_NOLOGIN || crypt.Valid(password)
+}
+
 // Get users from the config
 func (config *Config) LoadUsers() (err error) {
 
@@ -261,6 +348,8 @@ func VerifyUsers(users []User) (errs []error) {
 			err := fmt.Errorf("Bad user privilege level %d of user: %s", user.Privilege, user.Username)
 			errs = append(errs, err)
 		}
+
+		errs = append(errs, verifyKeys(user)...)
 	}
 
 	return errs
@@ -329,7 +418,7 @@ func legacyGetUsers(rootPath string) ([]User, error) {
 // Get users from the system
 func GetUsers() (users []User, err error) {
 
-	users, err = parse_passwd("")
+	users, err = parse_passwd(root)
 	if err != nil {
 		return
 	}
@@ -489,11 +578,21 @@ func GetPrivilege(username string) (level int, err error) {
 	return
 }
 
+// SetPassword sets the password of username, hashing a plaintext one with
+// SHA-512 crypt
 func SetPassword(username string, password string) (err error) {
-	pair := username + ":" + password
+	hash := password
+	if !crypt.Valid(hash) {
+		hash, err = crypt.Hash(crypt.SHA512, password)
+		if err != nil {
+			return
+		}
+	}
+
+	pair := username + ":" + hash
 	reader := strings.NewReader(pair)
 
-	cmd := exec.Command("chpasswd")
+	cmd := exec.Command("chpasswd", "-e")
 	cmd.Stdin = reader
 
 	output, err := cmd.CombinedOutput()
@@ -551,7 +650,7 @@ func in_use(username string) bool {
 }
 
 func check_exist(username string) (exist bool, err error) {
-	users, err := parse_passwd("")
+	users, err := parse_passwd(root)
 	if err != nil {
 		return
 	}
@@ -578,6 +677,11 @@ func parse_passwd(rootPath string) (users []User, err error) {
 		return
 	}
 
+	factors, err := readTwoFactor()
+	if err != nil {
+		return
+	}
+
 	lines := strings.Split(string(data), "\n")
 
 	for _, line := range lines {
@@ -612,6 +716,15 @@ func parse_passwd(rootPath string) (users []User, err error) {
 		default:
 		}
 
+		user.Keys, err = readKeys(rootPath, user.Username)
+		if err != nil {
+			return
+		}
+
+		if factor, ok := factors[user.Username]; ok {
+			user.TwoFactor = factor.Required
+		}
+
 		user.uID = uint16(uid)
 
 		users = append(users, user)
@@ -620,24 +733,14 @@ func parse_passwd(rootPath string) (users []User, err error) {
 	return
 }
 
-func add_to_passwd(users []User) (err error) {
-
-	if len(users) == 0 {
-		err = errors.New("No users inputed")
-	}
-
-	data, err := ioutil.ReadFile(user_file)
+func add_to_passwd(rootPath string, users []User) (err error) {
+	entries, err := readDatabase(rootPath+user_file, passwd_fields)
 	if err != nil {
 		return
 	}
 
 	var found bool
 
-	lines := strings.Split(string(data), "\n")
-	if lines[len(lines)-1] == "" {
-		lines = lines[:len(lines)-1]
-	}
-
 	for idx, user := range users {
 		if user.Username == default_user || user.Username == "" {
 			continue
@@ -647,12 +750,7 @@ func add_to_passwd(users []User) (err error) {
 		}
 
 		// check if there is already a user
-		for _, line := range lines {
-			fields := strings.Split(line, ":")
-			if len(fields) < 3 {
-				continue
-			}
-
+		for _, fields := range entries {
 			if user.Username == fields[0] {
 				uid, _ := strconv.Atoi(fields[2])
 				// exclude reserved users
@@ -666,41 +764,100 @@ func add_to_passwd(users []User) (err error) {
 		}
 
 		if !found {
-			new_line := user.Username + ":" + PASSWD_SHADOW + ":" + strconv.Itoa(uid_local_start+idx) + ":" +
-				strconv.Itoa(GID_USER) + "::" + home_dir + ":" + shell_path
-			lines = append(lines, new_line)
+			entries = append(entries, []string{user.Username, PASSWD_SHADOW, strconv.Itoa(uid_local_start + idx),
+				strconv.Itoa(GID_USER), "", home_dir, shell_path})
 		} else {
 			found = false
 		}
 	}
 
-	err = ioutil.WriteFile(user_file, []byte(strings.Join(lines, "\n")), 600)
+	return writeDatabase(rootPath+user_file, entries, passwd_fields, passwd_mode)
+}
+
+// remove_from_databases drops users from /etc/passwd, /etc/shadow and the
+// member lists of /etc/group, as userdel does
+func remove_from_databases(rootPath string, usernames []string) (err error) {
+	if len(usernames) == 0 {
+		return
+	}
+
+	removed := make(map[string]bool)
+	for _, username := range usernames {
+		if username == default_user || username == RADIUS_USER {
+			return fmt.Errorf("Cannot delete the reserved user: %s", username)
+		}
+		removed[username] = true
+	}
+
+	entries, err := readDatabase(rootPath+user_file, passwd_fields)
 	if err != nil {
 		return
 	}
 
-	return
-}
+	kept := [][]string{}
+	for _, fields := range entries {
+		if removed[fields[0]] {
+			// exclude reserved users
+			if uid, _ := strconv.Atoi(fields[2]); uid < uid_local {
+				return errors.New("Could not modify reserved user information")
+			}
+			continue
+		}
+		kept = append(kept, fields)
+	}
 
-// add password to /etc/shadow
-func add_to_shadow(users []User) (err error) {
+	err = writeDatabase(rootPath+user_file, kept, passwd_fields, passwd_mode)
+	if err != nil {
+		return
+	}
 
-	if len(users) == 0 {
-		err = errors.New("No users inputed")
+	entries, err = readDatabase(rootPath+pass_file, shadow_fields)
+	if err != nil {
+		return
 	}
 
-	data, err := ioutil.ReadFile(pass_file)
+	kept = [][]string{}
+	for _, fields := range entries {
+		if !removed[fields[0]] {
+			kept = append(kept, fields)
+		}
+	}
+
+	err = writeDatabase(rootPath+pass_file, kept, shadow_fields, shadow_mode)
 	if err != nil {
 		return
 	}
 
-	var found bool
+	entries, err = readDatabase(rootPath+group_file, group_fields)
+	if err != nil {
+		return
+	}
 
-	lines := strings.Split(string(data), "\n")
-	if lines[len(lines)-1] == "" {
-		lines = lines[:len(lines)-1]
+	for _, fields := range entries {
+		members := []string{}
+		for _, member := range strings.Split(fields[3], ",") {
+			if member != "" && !removed[member] {
+				members = append(members, member)
+			}
+		}
+		fields[3] = strings.Join(members, ",")
 	}
 
+	return writeDatabase(rootPath+group_file, entries, group_fields, group_mode)
+}
+
+// add password to /etc/shadow
+func add_to_shadow(rootPath string, users []User) (err error) {
+	entries, err := readDatabase(rootPath+pass_file, shadow_fields)
+	if err != nil {
+		return
+	}
+
+	var found bool
+
+	// the day of the last password change
+	today := strconv.FormatInt(time.Now().Unix()/(24*60*60), 10)
+
 	for _, user := range users {
 		if user.Username == RADIUS_USER {
 			err = errors.New("Could not modify reserved user password")
@@ -708,56 +865,37 @@ func add_to_shadow(users []User) (err error) {
 		}
 
 		// check if there is already a user
-		for idx, line := range lines {
-			fields := strings.Split(line, ":")
-			if len(fields) < 3 {
-				continue
-			}
-
+		for _, fields := range entries {
 			if user.Username == fields[0] {
-				fields[1] = user.Password
+				if fields[1] != user.Password {
+					fields[1] = user.Password
+					fields[2] = today
+				}
 				found = true
-				lines[idx] = strings.Join(fields, ":")
 			}
 		}
 
 		if !found {
-			new_line := user.Username + ":" + user.Password + ":99999:0:99999:7:::"
-			lines = append(lines, new_line)
+			entries = append(entries, []string{user.Username, user.Password, today, "0", "99999", "7", "", "", ""})
 		} else {
 			found = false
 		}
 
 	}
 
-	err = ioutil.WriteFile(pass_file, []byte(strings.Join(lines, "\n")), 600)
-	if err != nil {
-		return
-	}
-
-	return
+	return writeDatabase(rootPath+pass_file, entries, shadow_fields, shadow_mode)
 }
 
 // group format
 // users:x:100:vgl,radius
 
 // add privilege to /etc/group
-func add_to_group(users []User) (err error) {
-
-	if len(users) == 0 {
-		err = errors.New("No users inputed")
-	}
-
-	data, err := ioutil.ReadFile(group_file)
+func add_to_group(rootPath string, users []User) (err error) {
+	entries, err := readDatabase(rootPath+group_file, group_fields)
 	if err != nil {
 		return
 	}
 
-	lines := strings.Split(string(data), "\n")
-	if lines[len(lines)-1] == "" {
-		lines = lines[:len(lines)-1]
-	}
-
 	var users_normal []string
 	var users_admin []string
 	var admin_with_root []string
@@ -777,28 +915,16 @@ func add_to_group(users []User) (err error) {
 	}
 
 	// check if there is already a user
-	for idx, line := range lines {
-		fields := strings.Split(line, ":")
-		if len(fields) < 3 {
-			continue
-		}
-
+	for _, fields := range entries {
 		if fields[0] == default_group {
 			users_normal = append(users_normal, users_admin...)
 			fields[3] = strings.Join(users_normal, ",")
-			lines[idx] = strings.Join(fields, ":")
 		} else if fields[0] == admin_group {
 			admin_with_root = append(admin_with_root, "root")
 			admin_with_root = append(admin_with_root, users_admin...)
 			fields[3] = strings.Join(admin_with_root, ",")
-			lines[idx] = strings.Join(fields, ":")
 		}
 	}
 
-	err = ioutil.WriteFile(group_file, []byte(strings.Join(lines, "\n")), 600)
-	if err != nil {
-		return
-	}
-
-	return
+	return writeDatabase(rootPath+group_file, entries, group_fields, group_mode)
 }
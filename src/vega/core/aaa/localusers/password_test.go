@@ -0,0 +1,59 @@
+package localusers
+
+import (
+	"testing"
+)
+
+const testHash = "$6$saltsalt$qFmFH.bQmmtXzyBY0s9v7Oicd2z4XSIecDzlB5KiA2/jctKu9YterLp8wwnSq.qc.eoxqOmSuNp2xS0ktL3nh/"
+
+func TestHashed(t *testing.T) {
+	t.Log("[case] Test hashed passwords")
+	for _, password := range []string{testHash, "!" + testHash, "!", "*", default_password} {
+		if !Hashed(password) {
+			t.Error("[err] not hashed:", password)
+		}
+	}
+
+	for _, password := range []string{"password", "$6$salt$plain", "!password"} {
+		if Hashed(password) {
+			t.Error("[err] hashed:", password)
+		}
+	}
+}
+
+func TestSavePlaintext(t *testing.T) {
+	t.Log("[case] Test save refuses plaintext")
+	config := Config{
+		{Username: "admin", Password: testHash, Privilege: PRIVILEGE_ADMIN},
+		{Username: "test", Password: "password", Privilege: PRIVILEGE_USER},
+	}
+
+	if errs := config.Save(config); len(errs) != 1 {
+		t.Error("[err] expected 1 error, got:", errs)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	config := Config{
+		{Username: "admin", Password: testHash, Privilege: PRIVILEGE_ADMIN},
+		{Username: "test", Password: testHash, Privilege: PRIVILEGE_USER},
+	}
+
+	t.Log("[case] Test users without passwords")
+	users := config.Redacted()
+	for _, user := range users {
+		if user.Password != "" {
+			t.Error("[err] password of", user.Username)
+		}
+	}
+	if config[0].Password != testHash {
+		t.Error("[err] config changed")
+	}
+
+	t.Log("[case] Test keep passwords")
+	users = append(users, User{Username: "new", Password: "$2b$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW", Privilege: PRIVILEGE_USER})
+	users.KeepPasswords(config)
+	if users[0].Password != testHash || users[1].Password != testHash || users[2].Password == testHash {
+		t.Error("[err] passwords:", users)
+	}
+}
@@ -0,0 +1,339 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+package localusers
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"github.com/htbig/common/src/vega/core/util/crypt"
+)
+
+const (
+	max_password_length = 128
+	max_history_depth   = 24
+	max_age_days        = 99999
+	max_lockout_seconds = 86400
+)
+
+// historyFile keeps the hashes of the previous passwords of each user
+var (
+	historyFile = "/etc/security/vega-opasswd"
+	// serializes the edits of the history within the process
+	historyMutex sync.Mutex
+)
+
+// Policy is what a new local password must satisfy and how failed logins
+// lock an account. Zero values turn a check off.
+type Policy struct {
+	MinLength int `json:"min_length"`
+	// of lowercase letters, uppercase letters, digits and others
+	MinClasses int `json:"min_classes"`
+	// number of previous passwords that can not be used again
+	HistoryDepth int `json:"history_depth"`
+	// days until a password expires
+	MaxAgeDays int `json:"max_age_days"`
+	// failed logins in a row that lock an account
+	MaxFailures int `json:"max_failures"`
+	// how long a locked account stays locked
+	LockoutSeconds int `json:"lockout_seconds"`
+	// crypt method of new passwords, SHA-512 if not set
+	HashMethod string `json:"hash_method"`
+}
+
+// Legacy sets no policy, passwords were never checked
+func (policy *Policy) Legacy(legacyRoot string) {
+	*policy = Policy{}
+}
+
+func (policy *Policy) CopyFrom(otherPolicy Policy) {
+	*policy = otherPolicy
+}
+
+func (policy *Policy) CopyFromInterface(data interface{}) bool {
+	otherPolicy, ok := data.(*Policy)
+	if !ok {
+		return false
+	}
+
+	policy.CopyFrom(*otherPolicy)
+	return true
+}
+
+func (policy *Policy) CloneInterface() interface{} {
+	return policy.Clone()
+}
+
+func (policy *Policy) Clone() *Policy {
+	newPolicy := new(Policy)
+	newPolicy.CopyFrom(*policy)
+
+	return newPolicy
+}
+
+func (policy *Policy) SaveInterface(data interface{}) (bool, []error) {
+	oldPolicy, ok := data.(*Policy)
+	if !ok {
+		return false, nil
+	}
+
+	return true, policy.Save(*oldPolicy)
+}
+
+// Save forgets the passwords beyond the new history depth
+func (policy *Policy) Save(oldPolicy Policy) []error {
+	if policy.HistoryDepth >= oldPolicy.HistoryDepth {
+		return nil
+	}
+
+	err := editHistory(func(history map[string][]string) {
+		for username, hashes := range history {
+			history[username] = policy.trim(hashes)
+		}
+	})
+	if err != nil {
+		return []error{err}
+	}
+
+	return nil
+}
+
+func (policy *Policy) Verify() (errs []error) {
+	if policy.MinLength < 0 || policy.MinLength > max_password_length {
+		errs = append(errs, fmt.Errorf("Bad password minimum length: %d", policy.MinLength))
+	}
+
+	if policy.MinClasses < 0 || policy.MinClasses > 4 {
+		errs = append(errs, fmt.Errorf("Bad number of password character classes: %d", policy.MinClasses))
+	}
+
+	if policy.HistoryDepth < 0 || policy.HistoryDepth > max_history_depth {
+		errs = append(errs, fmt.Errorf("Bad password history depth: %d", policy.HistoryDepth))
+	}
+
+	if policy.MaxAgeDays < 0 || policy.MaxAgeDays > max_age_days {
+		errs = append(errs, fmt.Errorf("Bad password maximum age: %d", policy.MaxAgeDays))
+	}
+
+	if policy.MaxFailures < 0 {
+		errs = append(errs, fmt.Errorf("Bad maximum login failures: %d", policy.MaxFailures))
+	}
+
+	if policy.LockoutSeconds < 0 || policy.LockoutSeconds > max_lockout_seconds {
+		errs = append(errs, fmt.Errorf("Bad lockout period: %d", policy.LockoutSeconds))
+	} else if policy.MaxFailures > 0 && policy.LockoutSeconds == 0 {
+		errs = append(errs, errors.New("Lockout period needed with maximum login failures"))
+	}
+
+	switch policy.HashMethod {
+	case "", crypt.SHA512, crypt.BCRYPT, crypt.YESCRYPT:
+	default:
+		errs = append(errs, fmt.Errorf("Bad password hash method: %s", policy.HashMethod))
+	}
+
+	return
+}
+
+func (policy *Policy) Factory() {
+	*policy = Policy{
+		MinLength:      8,
+		MinClasses:     2,
+		MaxFailures:    5,
+		LockoutSeconds: 300,
+		HashMethod:     crypt.SHA512,
+	}
+}
+
+// Hash returns the crypt hash of the plaintext password with the method of
+// the policy
+func (policy *Policy) Hash(password string) (string, error) {
+	method := policy.HashMethod
+	if method == "" {
+		method = crypt.SHA512
+	}
+
+	return crypt.Hash(method, password)
+}
+
+// Lockout returns how long an account stays locked, 0 without lockout
+func (policy *Policy) Lockout() time.Duration {
+	if policy.MaxFailures == 0 {
+		return 0
+	}
+	return time.Duration(policy.LockoutSeconds) * time.Second
+}
+
+func classes(password string) int {
+	var lower, upper, digit, other int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+
+	return lower + upper + digit + other
+}
+
+// Check returns what is wrong with password as the new password of username.
+// The password must be plaintext, a hash would escape the policy; hashes are
+// only taken from a saved configuration.
+func (policy *Policy) Check(username, password string) (errs []error) {
+	if crypt.Valid(password) {
+		return []error{fmt.Errorf("Password of user %s must be given in plaintext", username)}
+	}
+
+	if len(password) < policy.MinLength {
+		errs = append(errs, fmt.Errorf("Password of user %s shorter than %d characters", username, policy.MinLength))
+	}
+
+	if classes(password) < policy.MinClasses {
+		errs = append(errs, fmt.Errorf("Password of user %s needs %d of lowercase, uppercase, digits and other characters", username, policy.MinClasses))
+	}
+
+	if policy.HistoryDepth > 0 {
+		history, err := readHistory()
+		if err != nil {
+			return append(errs, err)
+		}
+
+		for _, hash := range policy.trim(history[username]) {
+			if crypt.Verify(hash, password) {
+				errs = append(errs, fmt.Errorf("Password of user %s used in the last %d passwords", username, policy.HistoryDepth))
+				break
+			}
+		}
+	}
+
+	return
+}
+
+// Remember adds the hash of a new password to the history of username
+func (policy *Policy) Remember(username, hash string) error {
+	if policy.HistoryDepth == 0 {
+		return nil
+	}
+
+	return editHistory(func(history map[string][]string) {
+		history[username] = policy.trim(append([]string{hash}, history[username]...))
+	})
+}
+
+// trim keeps the newest hashes of the history depth
+func (policy *Policy) trim(hashes []string) []string {
+	if len(hashes) > policy.HistoryDepth {
+		return hashes[:policy.HistoryDepth]
+	}
+	return hashes
+}
+
+// Expired tells whether the password of username is older than the
+// maximum age
+func (policy *Policy) Expired(username string) bool {
+	if policy.MaxAgeDays == 0 {
+		return false
+	}
+
+	changed, err := passwordChanged(root, username)
+	if err != nil {
+		return false
+	}
+
+	return time.Since(changed) > time.Duration(policy.MaxAgeDays)*24*time.Hour
+}
+
+// passwordChanged returns the day of the last password change of username
+// from the third field of '/etc/shadow'
+func passwordChanged(rootPath, username string) (changed time.Time, err error) {
+	data, err := ioutil.ReadFile(rootPath + pass_file)
+	if err != nil {
+		return
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 || fields[0] != username {
+			continue
+		}
+
+		days, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return changed, fmt.Errorf("No password change date of user: %s", username)
+		}
+
+		return time.Unix(int64(days)*24*60*60, 0), nil
+	}
+
+	return changed, errors.New("No User found")
+}
+
+// history format
+// username:hash,hash
+// newest first
+
+func readHistory() (map[string][]string, error) {
+	history := make(map[string][]string)
+
+	data, err := ioutil.ReadFile(historyFile)
+	if os.IsNotExist(err) {
+		return history, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.SplitN(line, ":", 2)
+		if len(fields) < 2 || fields[1] == "" {
+			continue
+		}
+
+		history[fields[0]] = strings.Split(fields[1], ",")
+	}
+
+	return history, nil
+}
+
+// editHistory changes the password history with its lock file held and
+// writes it back the way the user databases are written
+func editHistory(edit func(history map[string][]string)) error {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+
+	lock := historyFile + ".lock"
+	if err := lockFile(lock, time.Now().Add(lock_timeout)); err != nil {
+		return err
+	}
+	defer os.Remove(lock)
+
+	history, err := readHistory()
+	if err != nil {
+		return err
+	}
+
+	edit(history)
+
+	return writeHistory(history)
+}
+
+func writeHistory(history map[string][]string) error {
+	entries := [][]string{}
+	for username, hashes := range history {
+		if len(hashes) > 0 {
+			entries = append(entries, []string{username, strings.Join(hashes, ",")})
+		}
+	}
+
+	return writeDatabase(historyFile, entries, 2, 0600)
+}
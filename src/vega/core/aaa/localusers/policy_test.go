@@ -0,0 +1,103 @@
+package localusers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPolicyVerify(t *testing.T) {
+	t.Log("[case] Test verify policy")
+	var policy Policy
+	policy.Factory()
+	if errs := policy.Verify(); len(errs) > 0 {
+		t.Error("[err] factory policy:", errs)
+	}
+
+	policy = Policy{MinLength: -1, MinClasses: 5, HistoryDepth: 25, MaxFailures: 3}
+	if errs := policy.Verify(); len(errs) != 4 {
+		t.Error("[err] expected 4 errors, got:", errs)
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal("[err] temp dir:", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(file string) { historyFile = file }(historyFile)
+	historyFile = filepath.Join(dir, "opasswd")
+
+	policy := Policy{MinLength: 8, MinClasses: 3, HistoryDepth: 2}
+
+	t.Log("[case] Test length and character classes")
+	if errs := policy.Check("test", "Passw0rd"); len(errs) > 0 {
+		t.Error("[err] good password:", errs)
+	}
+	if errs := policy.Check("test", "Pa0"); len(errs) != 1 {
+		t.Error("[err] short password:", errs)
+	}
+	if errs := policy.Check("test", "password"); len(errs) != 1 {
+		t.Error("[err] one class password:", errs)
+	}
+
+	t.Log("[case] Test password history")
+	for _, password := range []string{"Passw0rd1", "Passw0rd2", "Passw0rd3"} {
+		hash, err := policy.Hash(password)
+		if err != nil {
+			t.Fatal("[err] hash:", err)
+		}
+		if err := policy.Remember("test", hash); err != nil {
+			t.Fatal("[err] remember:", err)
+		}
+	}
+	if errs := policy.Check("test", "Passw0rd3"); len(errs) != 1 {
+		t.Error("[err] last password accepted:", errs)
+	}
+	if errs := policy.Check("test", "Passw0rd1"); len(errs) > 0 {
+		t.Error("[err] password beyond the history depth refused:", errs)
+	}
+	if errs := policy.Check("other", "Passw0rd3"); len(errs) > 0 {
+		t.Error("[err] history of another user:", errs)
+	}
+
+	t.Log("[case] Test hashed password")
+	if hash, _ := policy.Hash("short"); len(policy.Check("test", hash)) != 1 {
+		t.Error("[err] hashed password accepted")
+	}
+
+	t.Log("[case] Test lower history depth")
+	policy.HistoryDepth = 1
+	if errs := policy.Save(Policy{HistoryDepth: 2}); len(errs) > 0 {
+		t.Error("[err] save:", errs)
+	}
+	history, _ := readHistory()
+	if len(history["test"]) != 1 {
+		t.Error("[err] history not trimmed:", history)
+	}
+}
+
+func TestPasswordChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shadow")
+	if err != nil {
+		t.Fatal("[err] temp dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "etc"), 0755)
+	shadow := "root:*:17000:0:99999:7:::\ntest:$6$x$y:17532:0:99999:7:::\n"
+	ioutil.WriteFile(filepath.Join(dir, pass_file), []byte(shadow), 0600)
+
+	t.Log("[case] Test password change date")
+	changed, err := passwordChanged(dir, "test")
+	if err != nil || !changed.Equal(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Error("[err] change date:", changed, err)
+	}
+
+	if _, err := passwordChanged(dir, "missing"); err == nil {
+		t.Error("[err] no error for a missing user")
+	}
+}
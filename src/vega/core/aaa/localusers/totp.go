@@ -0,0 +1,528 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+package localusers
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"github.com/htbig/common/src/vega/core/util/crypt"
+)
+
+// TOTP of RFC 6238 with the defaults of the authenticator apps
+const (
+	totp_issuer = "Vega"
+	totp_digits = 6
+	totp_period = 30
+	// steps accepted before and after the current one, for clock drift
+	totp_skew = 1
+
+	totp_secret_bytes = 20
+	totp_key_bytes    = 32
+
+	recovery_codes  = 10
+	recovery_length = 10
+	// without the characters that look alike
+	recovery_alphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+var (
+	// second factors of the users
+	twoFactorFile = "/etc/security/vega-totp"
+	// key the TOTP secrets are encrypted with, created on first use
+	twoFactorKeyFile = "/etc/security/vega-totp.key"
+
+	twoFactorLock sync.Mutex
+
+	// time of the TOTP codes, replaced by tests
+	now = time.Now
+)
+
+// twoFactor is the second factor of a user as stored in twoFactorFile
+type twoFactor struct {
+	// the login needs a second factor, also without enrollment
+	Required bool `json:"required,omitempty"`
+	// encrypted TOTP secret
+	Secret string `json:"secret,omitempty"`
+	// crypt hashes of the unused recovery codes
+	Recovery []string `json:"recovery,omitempty"`
+	// last time step used, a code is not accepted twice
+	LastStep int64 `json:"last_step,omitempty"`
+	// enrollment waiting for a first code, it replaces the one above then
+	PendingSecret   string   `json:"pending_secret,omitempty"`
+	PendingRecovery []string `json:"pending_recovery,omitempty"`
+}
+
+// Enrollment answers a TOTP enrollment, it is the only time the secret and
+// the recovery codes are shown
+type Enrollment struct {
+	Secret        string   `json:"secret"`
+	URI           string   `json:"uri"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// TwoFactorStatus tells the second factor of a user
+type TwoFactorStatus struct {
+	Enrolled bool `json:"enrolled"`
+	Pending  bool `json:"pending"`
+	Required bool `json:"required"`
+	// unused recovery codes
+	RecoveryCodes int `json:"recovery_codes"`
+}
+
+// Needed tells whether the login needs a second factor
+func (status TwoFactorStatus) Needed() bool {
+	return status.Enrolled || status.Required
+}
+
+// TwoFactorOf returns the second factor of a user
+func TwoFactorOf(username string) (status TwoFactorStatus, err error) {
+	twoFactorLock.Lock()
+	defer twoFactorLock.Unlock()
+
+	factors, err := readTwoFactor()
+	if err != nil {
+		return
+	}
+
+	if factor, ok := factors[username]; ok {
+		status.Enrolled = factor.Secret != ""
+		status.Pending = factor.PendingSecret != ""
+		status.Required = factor.Required
+		status.RecoveryCodes = len(factor.Recovery)
+	}
+
+	return
+}
+
+// SetTwoFactor sets whether the login of a user needs a second factor
+func SetTwoFactor(username string, required bool) error {
+	twoFactorLock.Lock()
+	defer twoFactorLock.Unlock()
+
+	factors, err := readTwoFactor()
+	if err != nil {
+		return err
+	}
+
+	factor(factors, username).Required = required
+	return writeTwoFactor(factors)
+}
+
+// Enroll starts a TOTP enrollment of a user. It takes effect, replacing the
+// one before, once ConfirmEnrollment is given a code of the new secret.
+func Enroll(username string) (enrollment Enrollment, err error) {
+	secret := make([]byte, totp_secret_bytes)
+	if _, err = rand.Read(secret); err != nil {
+		return
+	}
+
+	key, err := twoFactorKey()
+	if err != nil {
+		return
+	}
+
+	sealed, err := seal(key, username, secret)
+	if err != nil {
+		return
+	}
+
+	var hashes []string
+	for i := 0; i < recovery_codes; i++ {
+		code, err := recoveryCode()
+		if err != nil {
+			return enrollment, err
+		}
+
+		hash, err := crypt.Hash(crypt.SHA512, code)
+		if err != nil {
+			return enrollment, err
+		}
+
+		enrollment.RecoveryCodes = append(enrollment.RecoveryCodes, code)
+		hashes = append(hashes, hash)
+	}
+
+	enrollment.Secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret)
+	enrollment.URI = totpURI(username, enrollment.Secret)
+
+	twoFactorLock.Lock()
+	defer twoFactorLock.Unlock()
+
+	factors, err := readTwoFactor()
+	if err != nil {
+		return
+	}
+
+	user := factor(factors, username)
+	user.PendingSecret = sealed
+	user.PendingRecovery = hashes
+
+	return enrollment, writeTwoFactor(factors)
+}
+
+// ConfirmEnrollment ends the enrollment of a user with a code of the new
+// secret, it tells whether the code was right
+func ConfirmEnrollment(username, code string) (bool, error) {
+	twoFactorLock.Lock()
+	defer twoFactorLock.Unlock()
+
+	factors, err := readTwoFactor()
+	if err != nil {
+		return false, err
+	}
+
+	user, ok := factors[username]
+	if !ok || user.PendingSecret == "" {
+		return false, errors.New("No TOTP enrollment of the user: " + username)
+	}
+
+	step, ok, err := checkTOTP(username, user.PendingSecret, 0, code)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	user.Secret, user.Recovery, user.LastStep = user.PendingSecret, user.PendingRecovery, step
+	user.PendingSecret, user.PendingRecovery = "", nil
+
+	return true, writeTwoFactor(factors)
+}
+
+// Disenroll removes the TOTP secret and the recovery codes of a user, it
+// tells whether there were any
+func Disenroll(username string) (bool, error) {
+	twoFactorLock.Lock()
+	defer twoFactorLock.Unlock()
+
+	factors, err := readTwoFactor()
+	if err != nil {
+		return false, err
+	}
+
+	user, ok := factors[username]
+	if !ok || (user.Secret == "" && user.PendingSecret == "") {
+		return false, nil
+	}
+
+	factors[username] = &twoFactor{Required: user.Required}
+	return true, writeTwoFactor(factors)
+}
+
+// VerifySecondFactor checks code, a TOTP code or an unused recovery code of
+// a user. Neither is accepted twice.
+func VerifySecondFactor(username, code string) (bool, error) {
+	twoFactorLock.Lock()
+	defer twoFactorLock.Unlock()
+
+	factors, err := readTwoFactor()
+	if err != nil {
+		return false, err
+	}
+
+	user, ok := factors[username]
+	if !ok || user.Secret == "" {
+		return false, nil
+	}
+
+	step, ok, err := checkTOTP(username, user.Secret, user.LastStep, code)
+	if err != nil {
+		return false, err
+	} else if ok {
+		user.LastStep = step
+		return true, writeTwoFactor(factors)
+	}
+
+	code = normalizeRecoveryCode(code)
+	if code == "" {
+		return false, nil
+	}
+
+	for idx, hash := range user.Recovery {
+		if crypt.Verify(hash, code) {
+			user.Recovery = append(user.Recovery[:idx], user.Recovery[idx+1:]...)
+			return true, writeTwoFactor(factors)
+		}
+	}
+
+	return false, nil
+}
+
+// save_two_factor sets the flags of users and removes the second factors of
+// the users not in users
+func save_two_factor(users []User) error {
+	twoFactorLock.Lock()
+	defer twoFactorLock.Unlock()
+
+	factors, err := readTwoFactor()
+	if err != nil {
+		return err
+	}
+
+	exist := make(map[string]bool)
+	for _, user := range users {
+		exist[user.Username] = true
+		factor(factors, user.Username).Required = user.TwoFactor
+	}
+
+	for username := range factors {
+		if !exist[username] {
+			delete(factors, username)
+		}
+	}
+
+	return writeTwoFactor(factors)
+}
+
+// checkTOTP checks a TOTP code of the sealed secret against the steps
+// around now after lastStep, it returns the step of the code
+func checkTOTP(username, sealed string, lastStep int64, code string) (int64, bool, error) {
+	if len(code) != totp_digits {
+		return 0, false, nil
+	}
+
+	key, err := twoFactorKey()
+	if err != nil {
+		return 0, false, err
+	}
+
+	secret, err := unseal(key, username, sealed)
+	if err != nil {
+		return 0, false, err
+	}
+
+	current := now().Unix() / totp_period
+	for step := current - totp_skew; step <= current+totp_skew; step++ {
+		if step <= lastStep {
+			continue
+		}
+
+		if subtle.ConstantTimeCompare([]byte(totp(secret, step)), []byte(code)) == 1 {
+			return step, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+// totp is the code of secret at a time step, HOTP of RFC 4226 with SHA-1
+func totp(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < totp_digits; i++ {
+		modulo *= 10
+	}
+
+	return fmt.Sprintf("%0*d", totp_digits, value%modulo)
+}
+
+// totpURI returns the otpauth URI of the Key Uri Format that the
+// authenticator apps read from a QR code
+func totpURI(username, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", totp_issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totp_digits))
+	query.Set("period", fmt.Sprint(totp_period))
+
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + totp_issuer + ":" + username,
+		RawQuery: query.Encode(),
+	}
+
+	return uri.String()
+}
+
+// recoveryCode returns a random code as "xxxxx-xxxxx"
+func recoveryCode() (string, error) {
+	buf := make([]byte, recovery_length)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	code := make([]byte, 0, recovery_length+1)
+	for i, b := range buf {
+		if i == recovery_length/2 {
+			code = append(code, '-')
+		}
+		// the bias of the modulo does not matter much with 31 characters
+		code = append(code, recovery_alphabet[int(b)%len(recovery_alphabet)])
+	}
+
+	return string(code), nil
+}
+
+// normalizeRecoveryCode makes the code typed by a user match its form
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.Replace(strings.Replace(code, " ", "", -1), "-", "", -1))
+	if len(code) != recovery_length {
+		return ""
+	}
+
+	return code[:recovery_length/2] + "-" + code[recovery_length/2:]
+}
+
+// twoFactorKey returns the key of the TOTP secrets, creating it if needed
+func twoFactorKey() ([]byte, error) {
+	key, err := ioutil.ReadFile(twoFactorKeyFile)
+	if os.IsNotExist(err) {
+		key = make([]byte, totp_key_bytes)
+		if _, err = rand.Read(key); err != nil {
+			return nil, err
+		}
+
+		file, err := os.OpenFile(twoFactorKeyFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			// created meanwhile
+			return twoFactorKey()
+		} else if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		if _, err = file.Write(key); err != nil {
+			return nil, err
+		}
+		return key, file.Sync()
+	} else if err != nil {
+		return nil, err
+	}
+
+	if len(key) != totp_key_bytes {
+		return nil, errors.New("Bad TOTP key file: " + twoFactorKeyFile)
+	}
+
+	return key, nil
+}
+
+// seal encrypts the secret of a user with AES-GCM, the username is
+// authenticated so that a secret can not be moved to another user
+func seal(key []byte, username string, secret []byte) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, secret, []byte(username))), nil
+}
+
+func unseal(key []byte, username, sealed string) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < aead.NonceSize() {
+		return nil, errors.New("Bad TOTP secret of the user: " + username)
+	}
+
+	secret, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(username))
+	if err != nil {
+		return nil, errors.New("Bad TOTP secret of the user: " + username)
+	}
+
+	return secret, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// factor returns the second factor of a user, adding it if needed
+func factor(factors map[string]*twoFactor, username string) *twoFactor {
+	user, ok := factors[username]
+	if !ok {
+		user = &twoFactor{}
+		factors[username] = user
+	}
+
+	return user
+}
+
+func readTwoFactor() (map[string]*twoFactor, error) {
+	factors := make(map[string]*twoFactor)
+
+	data, err := ioutil.ReadFile(twoFactorFile)
+	if os.IsNotExist(err) {
+		return factors, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, &factors); err != nil {
+		return nil, fmt.Errorf("Bad two-factor file %s: %s", twoFactorFile, err)
+	}
+
+	return factors, nil
+}
+
+// writeTwoFactor replaces twoFactorFile like writeDatabase does, a used
+// code or recovery code must not come back with a crash
+func writeTwoFactor(factors map[string]*twoFactor) error {
+	for username, user := range factors {
+		if !user.Required && user.Secret == "" && user.PendingSecret == "" {
+			delete(factors, username)
+		}
+	}
+
+	data, err := json.Marshal(factors)
+	if err != nil {
+		return err
+	}
+
+	temp := twoFactorFile + "+"
+	if err = ioutil.WriteFile(temp, data, 0600); err != nil {
+		return err
+	}
+
+	file, err := os.Open(temp)
+	if err == nil {
+		err = file.Sync()
+		file.Close()
+	}
+	if err == nil {
+		err = os.Rename(temp, twoFactorFile)
+	}
+	if err != nil {
+		os.Remove(temp)
+	}
+
+	return err
+}
@@ -0,0 +1,124 @@
+package localusers
+
+import (
+	"encoding/base32"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTOTP(t *testing.T) {
+	t.Log("[case] Test RFC 6238 vectors")
+	secret := []byte("12345678901234567890")
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, code := range vectors {
+		if got := totp(secret, unix/totp_period); got != code {
+			t.Error("[err] code at", unix, ":", got, "expected", code)
+		}
+	}
+}
+
+func TestTwoFactor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "totp")
+	if err != nil {
+		t.Fatal("[err] temp dir:", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(file, key string) { twoFactorFile, twoFactorKeyFile = file, key }(twoFactorFile, twoFactorKeyFile)
+	twoFactorFile = filepath.Join(dir, "totp")
+	twoFactorKeyFile = filepath.Join(dir, "totp.key")
+	defer func() { now = time.Now }()
+	clock := time.Unix(1500000000, 0)
+	now = func() time.Time { return clock }
+
+	t.Log("[case] Test enrollment")
+	enrollment, err := Enroll("test")
+	if err != nil {
+		t.Fatal("[err] enroll:", err)
+	}
+	if len(enrollment.RecoveryCodes) != recovery_codes {
+		t.Error("[err] recovery codes:", enrollment.RecoveryCodes)
+	}
+	uri, err := url.Parse(enrollment.URI)
+	if err != nil || uri.Scheme != "otpauth" || uri.Query().Get("secret") != enrollment.Secret {
+		t.Error("[err] uri:", enrollment.URI, err)
+	}
+
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatal("[err] secret:", err)
+	}
+
+	if status, _ := TwoFactorOf("test"); status.Enrolled || !status.Pending || status.Needed() {
+		t.Error("[err] pending enrollment in effect:", status)
+	}
+	code := totp(secret, clock.Unix()/totp_period)
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	if ok, _ := ConfirmEnrollment("test", wrong); ok {
+		t.Error("[err] wrong code confirmed")
+	}
+	if ok, err := ConfirmEnrollment("test", code); !ok || err != nil {
+		t.Fatal("[err] confirm:", err)
+	}
+	if status, _ := TwoFactorOf("test"); !status.Enrolled || status.Pending || status.RecoveryCodes != recovery_codes {
+		t.Error("[err] status:", status)
+	}
+
+	data, _ := ioutil.ReadFile(twoFactorFile)
+	if len(data) == 0 || string(data) == enrollment.Secret {
+		t.Error("[err] two-factor file:", string(data))
+	}
+
+	t.Log("[case] Test codes")
+	clock = clock.Add(totp_period * time.Second)
+	code = totp(secret, clock.Unix()/totp_period)
+	if ok, err := VerifySecondFactor("test", code); !ok || err != nil {
+		t.Error("[err] code refused:", err)
+	}
+	if ok, _ := VerifySecondFactor("test", code); ok {
+		t.Error("[err] code replayed")
+	}
+	if ok, _ := VerifySecondFactor("other", code); ok {
+		t.Error("[err] code of another user")
+	}
+
+	t.Log("[case] Test recovery codes")
+	recovery := enrollment.RecoveryCodes[0]
+	if ok, err := VerifySecondFactor("test", " "+recovery[:5]+recovery[6:]); !ok || err != nil {
+		t.Error("[err] recovery code refused:", err)
+	}
+	if ok, _ := VerifySecondFactor("test", recovery); ok {
+		t.Error("[err] recovery code used twice")
+	}
+	if status, _ := TwoFactorOf("test"); status.RecoveryCodes != recovery_codes-1 {
+		t.Error("[err] recovery codes left:", status.RecoveryCodes)
+	}
+
+	t.Log("[case] Test flags and disenrollment")
+	if err := SetTwoFactor("test", true); err != nil {
+		t.Error("[err] set:", err)
+	}
+	if ok, err := Disenroll("test"); !ok || err != nil {
+		t.Error("[err] disenroll:", err)
+	}
+	if status, _ := TwoFactorOf("test"); status.Enrolled || !status.Required || !status.Needed() {
+		t.Error("[err] status:", status)
+	}
+	if err := save_two_factor([]User{{Username: "admin"}}); err != nil {
+		t.Error("[err] save:", err)
+	}
+	if status, _ := TwoFactorOf("test"); status.Required {
+		t.Error("[err] removed user kept:", status)
+	}
+}
@@ -0,0 +1,126 @@
+package aaa
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	METHOD_RADIUS = "radius"
+	METHOD_TACACS = "tacacs"
+	METHOD_LDAP   = "ldap"
+	METHOD_LOCAL  = "local"
+
+	// try the next method when this one rejects the user or can not answer
+	CONTINUE_REJECT = "reject"
+	// try the next method only when this one can not answer
+	CONTINUE_UNREACHABLE = "unreachable"
+)
+
+// Method is an entry of the authentication method list, tried in order like
+// "aaa authentication login" on a router
+type Method struct {
+	Name     string `json:"name"`
+	Continue string `json:"continue"`
+}
+
+// Next reports whether the method after m is tried once m gave this outcome
+func (m Method) Next(authenticated bool, errs []error) bool {
+	switch {
+	case authenticated:
+		return false
+	case len(errs) > 0:
+		return true
+	default:
+		return m.Continue == CONTINUE_REJECT
+	}
+}
+
+// MethodEnabled reports whether a method can be used; disabled methods in
+// the list are skipped
+func (config *Config) MethodEnabled(name string) bool {
+	switch name {
+	case METHOD_RADIUS:
+		return config.RADIUS.Enabled
+	case METHOD_TACACS:
+		return config.TACACS.Enabled
+	case METHOD_LDAP:
+		return config.LDAP.Enabled
+	case METHOD_LOCAL:
+		return true
+	}
+
+	return false
+}
+
+// MethodList returns the methods in the order they are tried. Without a
+// method list the fallback flags of the servers decide, as they did before
+// the list existed: the enabled servers in turn, each passing on to the next
+// one only if its fallback is set, and the local users last.
+func (config *Config) MethodList() []Method {
+	if len(config.Methods) > 0 {
+		return config.Methods
+	}
+
+	remotes := []struct {
+		name     string
+		enabled  bool
+		fallback bool
+	}{
+		{METHOD_RADIUS, config.RADIUS.Enabled, config.RADIUS.Fallback},
+		{METHOD_TACACS, config.TACACS.Enabled, config.TACACS.Fallback},
+		{METHOD_LDAP, config.LDAP.Enabled, config.LDAP.Fallback},
+	}
+
+	methods := []Method{}
+	for _, remote := range remotes {
+		if !remote.enabled {
+			continue
+		}
+
+		methods = append(methods, Method{Name: remote.name, Continue: CONTINUE_REJECT})
+		if !remote.fallback {
+			methods[len(methods)-1].Continue = CONTINUE_UNREACHABLE
+			// nothing after it was ever tried
+			return methods
+		}
+	}
+
+	return append(methods, Method{Name: METHOD_LOCAL, Continue: CONTINUE_UNREACHABLE})
+}
+
+func (config *Config) verifyMethods() (errs []error) {
+	if len(config.Methods) == 0 {
+		return
+	}
+
+	seen := make(map[string]bool)
+	usable := false
+
+	for _, method := range config.Methods {
+		switch method.Name {
+		case METHOD_RADIUS, METHOD_TACACS, METHOD_LDAP, METHOD_LOCAL:
+		default:
+			errs = append(errs, fmt.Errorf("Unknown authentication method: %s", method.Name))
+		}
+
+		switch method.Continue {
+		case CONTINUE_REJECT, CONTINUE_UNREACHABLE:
+		default:
+			errs = append(errs, fmt.Errorf("Bad continue condition of authentication method %s: %s", method.Name, method.Continue))
+		}
+
+		if seen[method.Name] {
+			errs = append(errs, fmt.Errorf("Duplicate authentication method: %s", method.Name))
+		}
+		seen[method.Name] = true
+
+		usable = usable || config.MethodEnabled(method.Name)
+	}
+
+	if !usable {
+		errs = append(errs, errors.New("Authentication method list has no enabled method"))
+	}
+
+	return
+}
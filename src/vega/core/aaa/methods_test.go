@@ -0,0 +1,90 @@
+package aaa
+
+import (
+	"errors"
+	"testing"
+)
+
+func names(methods []Method) []string {
+	list := []string{}
+	for _, method := range methods {
+		list = append(list, method.Name+"/"+method.Continue)
+	}
+	return list
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMethodList(t *testing.T) {
+	t.Log("[case] Test method list derived from fallback flags")
+
+	var cfg Config
+
+	if got := names(cfg.MethodList()); !equal(got, []string{"local/unreachable"}) {
+		t.Error("[err] nothing enabled:", got)
+	}
+
+	cfg.RADIUS.Enabled = true
+	if got := names(cfg.MethodList()); !equal(got, []string{"radius/unreachable"}) {
+		t.Error("[err] RADIUS without fallback:", got)
+	}
+
+	cfg.RADIUS.Fallback = true
+	cfg.TACACS.Enabled = true
+	cfg.TACACS.Fallback = true
+	if got := names(cfg.MethodList()); !equal(got, []string{"radius/reject", "tacacs/reject", "local/unreachable"}) {
+		t.Error("[err] RADIUS and TACACS+ with fallback:", got)
+	}
+
+	t.Log("[case] Test configured method list")
+
+	cfg.Methods = []Method{{METHOD_LOCAL, CONTINUE_REJECT}, {METHOD_RADIUS, CONTINUE_UNREACHABLE}}
+	if got := names(cfg.MethodList()); !equal(got, []string{"local/reject", "radius/unreachable"}) {
+		t.Error("[err] configured:", got)
+	}
+}
+
+func TestNext(t *testing.T) {
+	t.Log("[case] Test continue conditions")
+
+	unreachable := []error{errors.New("timeout")}
+
+	reject := Method{METHOD_RADIUS, CONTINUE_REJECT}
+	if reject.Next(true, nil) || !reject.Next(false, nil) || !reject.Next(false, unreachable) {
+		t.Error("[err] continue on reject")
+	}
+
+	strict := Method{METHOD_RADIUS, CONTINUE_UNREACHABLE}
+	if strict.Next(true, nil) || strict.Next(false, nil) || !strict.Next(false, unreachable) {
+		t.Error("[err] continue when unreachable")
+	}
+}
+
+func TestVerifyMethods(t *testing.T) {
+	t.Log("[case] Test verify method list")
+
+	var cfg Config
+	if errs := cfg.verifyMethods(); len(errs) > 0 {
+		t.Error("[err] empty list:", errs)
+	}
+
+	cfg.Methods = []Method{{METHOD_RADIUS, CONTINUE_REJECT}, {METHOD_LOCAL, CONTINUE_UNREACHABLE}}
+	if errs := cfg.verifyMethods(); len(errs) > 0 {
+		t.Error("[err] valid list:", errs)
+	}
+
+	cfg.Methods = []Method{{"kerberos", CONTINUE_REJECT}, {METHOD_RADIUS, "always"}, {METHOD_RADIUS, CONTINUE_REJECT}}
+	if errs := cfg.verifyMethods(); len(errs) != 4 {
+		t.Error("[err] expected 4 errors, got:", errs)
+	}
+}
@@ -0,0 +1,170 @@
+package radius
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/htbig/common/src/vega/syslogger"
+)
+
+const RadiusAcctError = "Radius: Failed to send accounting to any servers"
+
+// Acct-Status-Type values
+const (
+	ACCT_START   = 1
+	ACCT_STOP    = 2
+	ACCT_INTERIM = 3
+)
+
+// Acct-Terminate-Cause values
+const (
+	TERMINATE_USER_REQUEST    = 1
+	TERMINATE_SESSION_TIMEOUT = 5
+	TERMINATE_ADMIN_RESET     = 6
+)
+
+// Service-Type values
+const (
+	serviceLogin          = 1
+	serviceAdministrative = 6
+)
+
+const (
+	acctserver_port = 1813
+)
+
+// Accounting is an accounting record of a login session
+type Accounting struct {
+	Status     int
+	SessionID  string
+	Username   string
+	Privileged bool
+	// address of the user, sent as Calling-Station-Id
+	RemoteAddress string
+	// time since the session started, for stop and interim records
+	SessionTime time.Duration
+	// why the session stopped, for stop records
+	TerminateCause int
+	// configuration change, sent as Cisco-AVPair "cmd=..."
+	Command string
+}
+
+// Account sends record to the configured servers in turn until one
+// acknowledges it. Every server gets its retry count of attempts.
+func (config *Config) Account(record Accounting) (errs []error) {
+	if len(config.Servers) == 0 {
+		errs = append(errs, errors.New(RadiusNoServerError))
+		return
+	}
+
+	start := time.Now()
+	var server_errs []error
+
+	for _, server := range config.Servers {
+		err := account(server, record, start)
+		if err == nil {
+			return nil
+		}
+
+		err = fmt.Errorf("%s: %s", server.IPaddr, err.Error())
+		server_errs = append(server_errs, err)
+		syslogger.Err("Radius accounting:", err)
+	}
+
+	errs = append(errs, errors.New(RadiusAcctError))
+	errs = append(errs, server_errs...)
+
+	return
+}
+
+func (record Accounting) packet(delay time.Duration) (*packet, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &packet{code: codeAccountingRequest, id: id}
+	p.addInt(attrAcctStatusType, uint32(record.Status))
+	p.addString(attrAcctSessionID, record.SessionID)
+	p.addString(attrUserName, record.Username)
+
+	if record.Privileged {
+		p.addInt(attrServiceType, serviceAdministrative)
+	} else {
+		p.addInt(attrServiceType, serviceLogin)
+	}
+
+	if hostname, err := os.Hostname(); err == nil {
+		p.addString(attrNASIdentifier, hostname)
+	}
+
+	if record.RemoteAddress != "" {
+		p.addString(attrCallingStationID, record.RemoteAddress)
+	}
+
+	if record.Status != ACCT_START {
+		p.addInt(attrAcctSessionTime, uint32(record.SessionTime/time.Second))
+	}
+
+	if record.Status == ACCT_STOP && record.TerminateCause != 0 {
+		p.addInt(attrAcctTerminateCause, uint32(record.TerminateCause))
+	}
+
+	if record.Command != "" {
+		command := "cmd=" + record.Command
+		if len(command) > maxAttributeLength-6 {
+			command = command[:maxAttributeLength-6]
+		}
+		p.addVendor(vendorCisco, ciscoAVPair, []byte(command))
+	}
+
+	p.addInt(attrEventTimestamp, uint32(time.Now().Unix()))
+	p.addInt(attrAcctDelayTime, uint32(delay/time.Second))
+
+	return p, nil
+}
+
+func account(server Server, record Accounting, start time.Time) error {
+	conn, err := server.dial(server.acctPort())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for i := 0; i < server.attempts(); i++ {
+		// a retry is a new request telling how late it is
+		p, err := record.packet(time.Since(start))
+		if err != nil {
+			return err
+		}
+
+		data, err := p.encode()
+		if err != nil {
+			return err
+		}
+		signAccounting(data, server.secret())
+
+		var request [authenticator]byte
+		copy(request[:], data[4:headerLength])
+
+		if _, err := conn.Write(data); err != nil {
+			return err
+		}
+
+		reply, err := awaitResponse(conn, p.id, request, server.secret(), server.timeout(), false)
+		if err == nil {
+			if reply.code != codeAccountingResponse {
+				return fmt.Errorf("Unexpected RADIUS reply code %d", reply.code)
+			}
+			return nil
+		}
+		if e, ok := err.(net.Error); !ok || !e.Timeout() {
+			return err
+		}
+	}
+
+	return errors.New(GatewayTimeoutError)
+}
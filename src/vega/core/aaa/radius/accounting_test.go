@@ -0,0 +1,143 @@
+package radius
+
+import (
+	"crypto/md5"
+	"net"
+	"testing"
+	"time"
+)
+
+// standIn answers Accounting-Requests signed with secret, sending forged
+// answers signed with another secret ahead of the real one
+func standIn(t *testing.T, secret string, forged int) (*net.UDPConn, uint16, chan *packet) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal("[err] listen:", err)
+	}
+
+	received := make(chan *packet, 8)
+	go func() {
+		buf := make([]byte, maxPacketLength)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+
+			data := append([]byte{}, buf[:n]...)
+			check := append([]byte{}, data...)
+			signAccounting(check, secret)
+			if string(check[4:headerLength]) != string(data[4:headerLength]) {
+				continue
+			}
+
+			request, _ := decodePacket(data)
+			received <- request
+
+			for i := 0; i <= forged; i++ {
+				reply := []byte{codeAccountingResponse, request.id, 0, headerLength}
+				reply = append(reply, request.authenticator[:]...)
+				key := secret
+				if i < forged {
+					key = "forged"
+				}
+				sum := md5.Sum(append(append([]byte{}, reply...), key...))
+				copy(reply[4:], sum[:])
+				conn.WriteToUDP(reply, addr)
+			}
+		}
+	}()
+
+	return conn, uint16(conn.LocalAddr().(*net.UDPAddr).Port), received
+}
+
+func TestAccount(t *testing.T) {
+	conn, port, received := standIn(t, "secret", 1)
+	defer conn.Close()
+
+	record := Accounting{
+		Status:         ACCT_STOP,
+		SessionID:      "0123456789abcdef",
+		Username:       "admin",
+		Privileged:     true,
+		RemoteAddress:  "192.0.2.1",
+		SessionTime:    90 * time.Second,
+		TerminateCause: TERMINATE_USER_REQUEST,
+		Command:        "PUT /aaa/radius/enable",
+	}
+
+	t.Log("[case] Test accounting request past a forged answer")
+	cfg := Config{Servers: []Server{{IPaddr: "127.0.0.1", Secret: "secret", AcctPort: port}}}
+	if errs := cfg.Account(record); len(errs) > 0 {
+		t.Fatal("[err] Account:", errs)
+	}
+
+	p := <-received
+	if v, _ := p.get(attrUserName); string(v) != "admin" {
+		t.Error("[err] User-Name:", string(v))
+	}
+	if v, _ := p.get(attrAcctStatusType); len(v) != 4 || v[3] != ACCT_STOP {
+		t.Error("[err] Acct-Status-Type:", v)
+	}
+	if v, _ := p.get(attrAcctSessionTime); len(v) != 4 || v[3] != 90 {
+		t.Error("[err] Acct-Session-Time:", v)
+	}
+	if v, _ := p.get(attrVendorSpecific); len(v) < 6 || string(v[6:]) != "cmd=PUT /aaa/radius/enable" {
+		t.Error("[err] Cisco-AVPair:", v)
+	}
+
+	t.Log("[case] Test failover to the second server")
+	dead, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal("[err] listen:", err)
+	}
+	deadPort := dead.LocalAddr().(*net.UDPAddr).Port
+	// nothing listens there any more, so the request is refused
+	dead.Close()
+
+	cfg.Servers = []Server{
+		{IPaddr: "127.0.0.1", Secret: "secret", AcctPort: uint16(deadPort)},
+		{IPaddr: "127.0.0.1", Secret: "secret", AcctPort: port},
+	}
+	if errs := cfg.Account(record); len(errs) > 0 {
+		t.Error("[err] failover:", errs)
+	}
+
+	t.Log("[case] Test no server")
+	cfg.Servers = nil
+	if errs := cfg.Account(record); len(errs) != 1 || errs[0].Error() != RadiusNoServerError {
+		t.Error("[err] no server:", errs)
+	}
+
+	t.Log("[case] Test wrong secret")
+	cfg.Servers = []Server{{IPaddr: "127.0.0.1", Secret: "wrong", AcctPort: port}}
+	if testing.Short() {
+		return
+	}
+	if errs := cfg.Account(record); len(errs) != 2 || errs[0].Error() != RadiusAcctError {
+		t.Error("[err] wrong secret:", errs)
+	}
+}
+
+func TestVerifyResponse(t *testing.T) {
+	t.Log("[case] Test response authenticator")
+
+	var request [authenticator]byte
+	copy(request[:], "0123456789abcdef")
+
+	reply := append([]byte{codeAccountingResponse, 7, 0, headerLength}, request[:]...)
+	sum := md5.Sum(append(append([]byte{}, reply...), "secret"...))
+	copy(reply[4:], sum[:])
+
+	if !verifyResponse(reply, request, "secret") {
+		t.Error("[err] valid response rejected")
+	}
+	if verifyResponse(reply, request, "other") {
+		t.Error("[err] response of another secret accepted")
+	}
+
+	reply[1] = 8
+	if verifyResponse(reply, request, "secret") {
+		t.Error("[err] altered response accepted")
+	}
+}
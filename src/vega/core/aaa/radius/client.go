@@ -1,12 +1,16 @@
 package radius
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"net"
+	"os"
 	"strconv"
-	"github.com/htbig/common/src/vega/syslogger"
+	"sync"
+	"time"
 
-	"github.com/kirves/goradius"
+	"github.com/htbig/common/src/vega/syslogger"
 )
 
 const RadiusCfgError = "Radius: Failed to read radius config"
@@ -14,44 +18,252 @@ const RadiusNoServerError = "Radius: No Radius server is set"
 const RadiusAuthError = "Radius: Failed to authenticate with any servers"
 const GatewayTimeoutError = "Timed out while waiting for an answer"
 
-func RadiusAuthenticate(username, password string) (privileged, ok bool, errs []error) {
-	var err error
+// privilege attribute value of administrators
+const PRIVILEGE_ADMIN = 2
 
-	servers, err := read_server_list("")
-	if err != nil {
-		errs = append(errs, errors.New(RadiusCfgError))
-		errs = append(errs, err)
+// Order the servers are tried in
+const (
+	// always start with the first server
+	FAILOVER_ORDERED = "ordered"
+	// start with the server after the one the last login started with
+	FAILOVER_ROUND_ROBIN = "round-robin"
+)
+
+const (
+	// seconds to wait for an answer
+	defaultTimeout = 3
+	maxTimeout     = 60
+
+	// requests sent to a server before giving up on it
+	defaultRetries = 2
+	maxRetries     = 10
+
+	// seconds a server that did not answer is tried last
+	defaultDeadTime = 30
+)
+
+// servers holds the state shared by every copy of the configuration
+var servers = struct {
+	sync.Mutex
+	// when servers that did not answer are tried again
+	dead map[string]time.Time
+	// first server of the next round-robin login
+	next int
+	// outcome of the last probes
+	status map[string]*Status
+}{dead: make(map[string]time.Time), status: make(map[string]*Status)}
+
+func (server Server) address(port uint16) string {
+	return net.JoinHostPort(server.IPaddr, strconv.Itoa(int(port)))
+}
+
+func (server Server) timeout() time.Duration {
+	if server.Timeout == 0 {
+		return defaultTimeout * time.Second
+	}
+	return time.Duration(server.Timeout) * time.Second
+}
+
+func (server Server) retries() int {
+	if server.Retries == 0 {
+		return defaultRetries
+	}
+	return int(server.Retries)
+}
+
+// order returns the servers in the order to try them, the ones in hold-down
+// or failing their probes last so a login still succeeds when every server
+// was marked dead
+func (config *Config) order() []Server {
+	list := make([]Server, len(config.Servers))
+	copy(list, config.Servers)
+
+	servers.Lock()
+	defer servers.Unlock()
+
+	if config.Failover == FAILOVER_ROUND_ROBIN && len(list) > 0 {
+		start := servers.next % len(list)
+		servers.next = start + 1
+		list = append(list[start:], list[:start]...)
+	}
+
+	alive := []Server{}
+	dead := []Server{}
+	now := time.Now()
+	for _, server := range list {
+		if until, ok := servers.dead[server.address(server.Port)]; ok && now.Before(until) || unhealthy(server) {
+			dead = append(dead, server)
+		} else {
+			alive = append(alive, server)
+		}
+	}
+
+	return append(alive, dead...)
+}
+
+// markDead holds a server down for the configured dead time
+func (config *Config) markDead(server Server) {
+	if config.DeadTime == 0 {
 		return
 	}
 
-	if len(servers) == 0 {
+	servers.Lock()
+	servers.dead[server.address(server.Port)] = time.Now().Add(time.Duration(config.DeadTime) * time.Second)
+	servers.Unlock()
+}
+
+func markAlive(server Server) {
+	address := server.address(server.Port)
+
+	servers.Lock()
+	delete(servers.dead, address)
+	if status, ok := servers.status[address]; ok {
+		status.Reachable = true
+		status.LastSeen = time.Now()
+		status.LastError = ""
+	}
+	servers.Unlock()
+}
+
+// unreachable tells whether err means the server did not answer
+func unreachable(err error) bool {
+	_, ok := err.(net.Error)
+	return ok || err.Error() == GatewayTimeoutError
+}
+
+// Authenticate sends an Access-Request to the servers in failover order until
+// one answers. privilege comes from the first mapping matching the
+// Access-Accept, returned as rule, or else from the privilege attribute. It is
+// -1 without either.
+func (config *Config) Authenticate(username, password string) (privilege int, rule *Mapping, ok bool, errs []error) {
+	privilege = -1
+
+	if len(config.Servers) == 0 {
 		errs = append(errs, errors.New(RadiusNoServerError))
 		return
 	}
 
 	var server_errs []error
 
-	for _, server := range servers {
-		address := server.IPaddr
-		port := strconv.Itoa(int(server.Port))
-		secret := server.Secret
+	for _, server := range config.order() {
+		reply, err := config.access(server, username, password)
+		if err == nil {
+			markAlive(server)
+			if reply.code != codeAccessAccept {
+				// rejected or challenged, we don't do challenges
+				return -1, nil, false, nil
+			}
 
-		auth := goradius.Authenticator(address, port, secret)
-		privilege, ok, err := auth.AuthenticateWithPrivilege(username, password)
+			privilege, rule = config.mapPrivilege(reply)
+			return privilege, rule, true, nil
+		}
 
-		if err == nil {
-			return privilege == 2, ok, nil
-		} else {
-			err = fmt.Errorf("%s: %s", address, err.Error())
-			server_errs = append(server_errs, err)
-			syslogger.Err("Radius auth:", err)
+		if unreachable(err) {
+			config.markDead(server)
 		}
-	}
 
-	if len(server_errs) > 0 {
-		errs = append(errs, errors.New(RadiusAuthError))
-		errs = append(errs, server_errs...)
+		err = fmt.Errorf("%s: %s", server.IPaddr, err.Error())
+		server_errs = append(server_errs, err)
+		syslogger.Err("Radius auth:", err)
 	}
 
+	errs = append(errs, errors.New(RadiusAuthError))
+	errs = append(errs, server_errs...)
+
 	return
 }
+
+// access sends an Access-Request for username to server
+func (config *Config) access(server Server, username, password string) (*packet, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := newAuthenticator()
+	if err != nil {
+		return nil, err
+	}
+
+	hidden, err := hidePassword(password, request, server.secret())
+	if err != nil {
+		return nil, err
+	}
+
+	p := &packet{code: codeAccessRequest, id: id, authenticator: request}
+	p.addString(attrUserName, username)
+	p.add(attrUserPassword, hidden)
+	if hostname, err := os.Hostname(); err == nil {
+		p.addString(attrNASIdentifier, hostname)
+	}
+	p.add(attrMessageAuthenticator, make([]byte, authenticator))
+
+	data, err := p.encode()
+	if err != nil {
+		return nil, err
+	}
+	signMessage(data, server.secret())
+
+	return config.send(server, server.attempts(), p.id, request, data)
+}
+
+// send sends an encoded request to the authentication port of server and
+// waits for its answer, sending it again up to retries times in all
+func (config *Config) send(server Server, retries int, id byte, request [authenticator]byte, data []byte) (*packet, error) {
+	conn, err := server.dial(server.authPort())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// retries resend the same request, RFC 2865 section 2.5
+	for i := 0; i < retries; i++ {
+		if _, err := conn.Write(data); err != nil {
+			return nil, err
+		}
+
+		reply, err := awaitResponse(conn, id, request, server.secret(), server.timeout(), config.RequireMessageAuthenticator)
+		if err == nil {
+			return reply, nil
+		}
+		if e, ok := err.(net.Error); !ok || !e.Timeout() {
+			return nil, err
+		}
+	}
+
+	return nil, errors.New(GatewayTimeoutError)
+}
+
+// awaitResponse reads until the reply to request id arrives or timeout
+// passes, dropping stray and forged datagrams. require drops replies without
+// a Message-Authenticator.
+func awaitResponse(conn net.Conn, id byte, request [authenticator]byte, secret string, timeout time.Duration, require bool) (*packet, error) {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, maxPacketLength)
+
+	for {
+		n, err := readPacket(conn, buf)
+		if err != nil {
+			return nil, err
+		}
+
+		reply, err := decodePacket(buf[:n])
+		if err != nil || reply.id != id {
+			continue
+		}
+
+		data := buf[:int(binary.BigEndian.Uint16(buf[2:]))]
+		if !verifyResponse(data, request, secret) {
+			syslogger.Err("Radius: dropped forged or corrupted answer from", conn.RemoteAddr())
+			continue
+		}
+
+		present, valid := verifyMessage(data, request, secret)
+		if present && !valid || !present && require {
+			syslogger.Err("Radius: dropped answer with bad or missing Message-Authenticator from", conn.RemoteAddr())
+			continue
+		}
+
+		return reply, nil
+	}
+}
@@ -0,0 +1,224 @@
+package radius
+
+import (
+	"bytes"
+	"crypto/md5"
+	"net"
+	"testing"
+	"time"
+)
+
+// answer encodes a reply to request, with a Message-Authenticator signed
+// with messageSecret unless it is empty
+func answer(request *packet, code byte, secret, messageSecret string, privilege uint32) []byte {
+	p := &packet{code: code, id: request.id, authenticator: request.authenticator}
+	if privilege > 0 {
+		p.addInt(attrPrivilege, privilege)
+	}
+	if messageSecret != "" {
+		p.add(attrMessageAuthenticator, make([]byte, authenticator))
+	}
+
+	data, _ := p.encode()
+	if messageSecret != "" {
+		offset := attributeOffset(data, attrMessageAuthenticator)
+		copy(data[offset:], messageAuthenticator(data, request.authenticator, messageSecret, offset))
+	}
+
+	sum := md5.Sum(append(append([]byte{}, data...), secret...))
+	copy(data[4:headerLength], sum[:])
+	return data
+}
+
+// accessStandIn accepts user "admin" with password "password" and rejects
+// anyone else. With forge set it sends an answer with a bad
+// Message-Authenticator ahead of the real one, without sign the real one has
+// no Message-Authenticator.
+func accessStandIn(t *testing.T, secret string, forge, sign bool) (*net.UDPConn, uint16, chan string) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal("[err] listen:", err)
+	}
+
+	users := make(chan string, 16)
+	go func() {
+		buf := make([]byte, maxPacketLength)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+
+			data := append([]byte{}, buf[:n]...)
+			request, err := decodePacket(data)
+			if err != nil || request.code != codeAccessRequest {
+				continue
+			}
+
+			if present, valid := verifyMessage(data, request.authenticator, secret); !present || !valid {
+				t.Error("[err] stand-in: bad Message-Authenticator in request")
+				continue
+			}
+
+			username, _ := request.get(attrUserName)
+			hidden, _ := request.get(attrUserPassword)
+			// hiding is its own inverse on the first 16 octets
+			password, _ := hidePassword(string(hidden[:16]), request.authenticator, secret)
+			users <- string(username)
+
+			code := byte(codeAccessReject)
+			if string(username) == "admin" && string(bytes.TrimRight(password, "\x00")) == "password" {
+				code = codeAccessAccept
+			}
+
+			if forge {
+				conn.WriteToUDP(answer(request, codeAccessAccept, secret, "forged", PRIVILEGE_ADMIN), addr)
+			}
+			messageSecret := secret
+			if !sign {
+				messageSecret = ""
+			}
+			conn.WriteToUDP(answer(request, code, secret, messageSecret, PRIVILEGE_ADMIN), addr)
+		}
+	}()
+
+	return conn, uint16(conn.LocalAddr().(*net.UDPAddr).Port), users
+}
+
+// deadPort returns a local UDP port nothing listens on
+func deadPort(t *testing.T) uint16 {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal("[err] listen:", err)
+	}
+	defer conn.Close()
+
+	return uint16(conn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+func TestAuthenticate(t *testing.T) {
+	conn, port, _ := accessStandIn(t, "secret", true, true)
+	defer conn.Close()
+
+	cfg := Config{Servers: []Server{{IPaddr: "127.0.0.1", Secret: "secret", Port: port, Timeout: 1}}}
+
+	t.Log("[case] Test accept past a forged answer")
+	privilege, _, ok, errs := cfg.Authenticate("admin", "password")
+	if len(errs) > 0 || !ok || privilege != PRIVILEGE_ADMIN {
+		t.Error("[err] accept:", privilege, ok, errs)
+	}
+
+	t.Log("[case] Test reject")
+	privilege, _, ok, errs = cfg.Authenticate("admin", "wrong")
+	if len(errs) > 0 || ok || privilege != -1 {
+		t.Error("[err] reject:", privilege, ok, errs)
+	}
+
+	t.Log("[case] Test answer without Message-Authenticator")
+	plain, plainPort, _ := accessStandIn(t, "secret", false, false)
+	defer plain.Close()
+	cfg.Servers[0].Port = plainPort
+	cfg.Servers[0].Retries = 1
+	if _, _, ok, errs := cfg.Authenticate("admin", "password"); !ok || len(errs) > 0 {
+		t.Error("[err] answer without Message-Authenticator:", ok, errs)
+	}
+
+	cfg.RequireMessageAuthenticator = true
+	if _, _, ok, errs := cfg.Authenticate("admin", "password"); ok || len(errs) != 2 || errs[0].Error() != RadiusAuthError {
+		t.Error("[err] missing Message-Authenticator accepted:", ok, errs)
+	}
+
+	t.Log("[case] Test no server")
+	cfg.Servers = nil
+	if _, _, _, errs := cfg.Authenticate("admin", "password"); len(errs) != 1 || errs[0].Error() != RadiusNoServerError {
+		t.Error("[err] no server:", errs)
+	}
+}
+
+func TestFailover(t *testing.T) {
+	first, firstPort, firstUsers := accessStandIn(t, "secret", false, true)
+	defer first.Close()
+	second, secondPort, secondUsers := accessStandIn(t, "secret", false, true)
+	defer second.Close()
+
+	dead := deadPort(t)
+
+	t.Log("[case] Test ordered failover past a dead server")
+	cfg := Config{
+		Failover: FAILOVER_ORDERED,
+		DeadTime: 60,
+		Servers: []Server{
+			{IPaddr: "127.0.0.1", Secret: "secret", Port: dead, Timeout: 1},
+			{IPaddr: "127.0.0.1", Secret: "secret", Port: firstPort, Timeout: 1},
+		},
+	}
+	if _, _, ok, errs := cfg.Authenticate("admin", "password"); !ok || len(errs) > 0 {
+		t.Error("[err] failover:", ok, errs)
+	}
+	<-firstUsers
+
+	servers.Lock()
+	_, held := servers.dead[cfg.Servers[0].address(dead)]
+	servers.Unlock()
+	if !held {
+		t.Error("[err] dead server not held down")
+	}
+
+	t.Log("[case] Test held down server tried last")
+	if order := cfg.order(); order[0].Port != firstPort || order[1].Port != dead {
+		t.Error("[err] order:", order)
+	}
+
+	t.Log("[case] Test round-robin")
+	cfg = Config{
+		Failover: FAILOVER_ROUND_ROBIN,
+		Servers: []Server{
+			{IPaddr: "127.0.0.1", Secret: "secret", Port: firstPort, Timeout: 1},
+			{IPaddr: "127.0.0.1", Secret: "secret", Port: secondPort, Timeout: 1},
+		},
+	}
+	for i := 0; i < 4; i++ {
+		if _, _, ok, errs := cfg.Authenticate("user", "password"); ok || len(errs) > 0 {
+			t.Error("[err] round-robin:", ok, errs)
+		}
+	}
+
+	timeout := time.After(time.Second)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-firstUsers:
+		case <-timeout:
+			t.Fatal("[err] first server did not get 2 requests")
+		}
+		select {
+		case <-secondUsers:
+		case <-timeout:
+			t.Fatal("[err] second server did not get 2 requests")
+		}
+	}
+}
+
+func TestHidePassword(t *testing.T) {
+	t.Log("[case] Test User-Password hiding")
+
+	var request [authenticator]byte
+	copy(request[:], "0123456789abcdef")
+
+	hidden, err := hidePassword("a password longer than 16", request, "secret")
+	if err != nil || len(hidden) != 32 {
+		t.Fatal("[err] hide:", len(hidden), err)
+	}
+
+	b := md5.Sum(append([]byte("secret"), request[:]...))
+	if hidden[0]^b[0] != 'a' {
+		t.Error("[err] first block")
+	}
+	b = md5.Sum(append([]byte("secret"), hidden[:16]...))
+	if hidden[16]^b[0] != 'r' {
+		t.Error("[err] second block")
+	}
+
+	if _, err := hidePassword(string(make([]byte, 129)), request, "secret"); err == nil {
+		t.Error("[err] too long password accepted")
+	}
+}
@@ -0,0 +1,207 @@
+package radius
+
+import (
+	"encoding/binary"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/htbig/common/src/vega/syslogger"
+)
+
+const coa_port = 3799
+
+// Error-Cause values, RFC 5176 section 3.5
+const (
+	errorUnsupportedAttribute = 401
+	errorMissingAttribute     = 402
+	errorSessionNotFound      = 503
+	errorResourcesUnavailable = 506
+)
+
+// requests with an Event-Timestamp further off are taken as replays
+const coaWindow = 300 * time.Second
+
+// Change is a Disconnect-Request or CoA-Request from a RADIUS server
+type Change struct {
+	Disconnect bool
+	// sessions of the user, all of them without SessionID
+	Username string
+	// Acct-Session-Id of the session
+	SessionID string
+	// new privilege of a CoA-Request, mapped like an Access-Accept
+	Privilege int
+	Rule      *Mapping
+}
+
+// ChangeHandler applies a change, answering how many sessions it matched
+type ChangeHandler func(change Change) (matched int, err error)
+
+// Listener answers Disconnect-Requests and CoA-Requests, RFC 5176
+type Listener struct {
+	conn    *net.UDPConn
+	config  func() *Config
+	handler ChangeHandler
+}
+
+// Listen starts answering requests on port, 0 for the default. config gives
+// the servers trusted to send requests and is asked for every request.
+func Listen(port uint16, config func() *Config, handler ChangeHandler) (*Listener, error) {
+	if port == 0 {
+		port = coa_port
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort("", strconv.Itoa(int(port))))
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Listener{conn: conn, config: config, handler: handler}
+	go l.serve()
+
+	return l, nil
+}
+
+// Addr returns the address the listener is bound to
+func (l *Listener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+func (l *Listener) Close() error {
+	return l.conn.Close()
+}
+
+func (l *Listener) serve() {
+	buf := make([]byte, maxPacketLength)
+
+	for {
+		n, addr, err := l.conn.ReadFromUDP(buf)
+		if err != nil {
+			// closed
+			return
+		}
+
+		data := append([]byte{}, buf[:n]...)
+		if reply := l.answer(data, addr); reply != nil {
+			l.conn.WriteToUDP(reply, addr)
+		}
+	}
+}
+
+// secretOf returns the secret of the configured server at addr
+func secretOf(config *Config, addr *net.UDPAddr) (string, bool) {
+	for _, server := range config.Servers {
+		if server.tls() || server.Secret == "" {
+			// RadSec servers send requests over their own connection
+			continue
+		}
+		if ip := net.ParseIP(server.IPaddr); ip != nil && ip.Equal(addr.IP) {
+			return server.Secret, true
+		}
+	}
+	return "", false
+}
+
+// answer returns the encoded reply to a request, nil to drop it
+func (l *Listener) answer(data []byte, addr *net.UDPAddr) []byte {
+	request, err := decodePacket(data)
+	if err != nil || request.code != codeDisconnectRequest && request.code != codeCoARequest {
+		return nil
+	}
+	data = data[:binary.BigEndian.Uint16(data[2:])]
+
+	config := l.config()
+	secret, ok := secretOf(config, addr)
+	if !ok {
+		syslogger.Err("Radius CoA: dropped request from unknown client", addr)
+		return nil
+	}
+
+	if !verifyRequest(data, secret) {
+		syslogger.Err("Radius CoA: dropped forged or corrupted request from", addr)
+		return nil
+	}
+
+	// the Message-Authenticator of a request is signed over a zero authenticator
+	var zero [authenticator]byte
+	present, valid := verifyMessage(data, zero, secret)
+	if present && !valid || !present && config.RequireMessageAuthenticator {
+		syslogger.Err("Radius CoA: dropped request with bad or missing Message-Authenticator from", addr)
+		return nil
+	}
+
+	if v, found := request.get(attrEventTimestamp); found && len(v) == 4 {
+		sent := time.Unix(int64(binary.BigEndian.Uint32(v)), 0)
+		if d := time.Since(sent); d > coaWindow || d < -coaWindow {
+			syslogger.Err("Radius CoA: dropped stale request from", addr)
+			return nil
+		}
+	}
+
+	change := Change{Disconnect: request.code == codeDisconnectRequest, Privilege: -1}
+	if v, found := request.get(attrUserName); found {
+		change.Username = string(v)
+	}
+	if v, found := request.get(attrAcctSessionID); found {
+		change.SessionID = string(v)
+	}
+
+	cause := 0
+	switch {
+	case change.Username == "" && change.SessionID == "":
+		cause = errorMissingAttribute
+	case !change.Disconnect:
+		change.Privilege, change.Rule = config.mapPrivilege(request)
+		if change.Privilege < 0 {
+			// nothing we can change
+			cause = errorUnsupportedAttribute
+		}
+	}
+
+	if cause == 0 {
+		matched, err := l.handler(change)
+		switch {
+		case err != nil:
+			syslogger.Err("Radius CoA:", err)
+			cause = errorResourcesUnavailable
+		case matched == 0:
+			cause = errorSessionNotFound
+		default:
+			syslogger.Info("Radius CoA: applied", change.Username, change.SessionID, "from", addr, "to", matched, "sessions")
+		}
+	}
+
+	return reply(request, cause, secret)
+}
+
+// reply encodes the ACK to request, or a NAK with an Error-Cause
+func reply(request *packet, cause int, secret string) []byte {
+	code := byte(codeDisconnectACK)
+	if request.code == codeCoARequest {
+		code = codeCoAACK
+	}
+
+	p := &packet{code: code, id: request.id, authenticator: request.authenticator}
+	if cause != 0 {
+		// the NAK follows the ACK
+		p.code++
+		p.addInt(attrErrorCause, uint32(cause))
+	}
+	p.add(attrMessageAuthenticator, make([]byte, authenticator))
+
+	data, err := p.encode()
+	if err != nil {
+		return nil
+	}
+
+	offset := attributeOffset(data, attrMessageAuthenticator)
+	copy(data[offset:], messageAuthenticator(data, request.authenticator, secret, offset))
+
+	signResponse(data, secret)
+	return data
+}
@@ -0,0 +1,149 @@
+package radius
+
+import (
+	"encoding/binary"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// coaRequest encodes a signed Disconnect-Request or CoA-Request
+func coaRequest(code byte, secret string, attributes ...attribute) []byte {
+	p := &packet{code: code, id: 42, attributes: attributes}
+	p.addInt(attrEventTimestamp, uint32(time.Now().Unix()))
+	p.add(attrMessageAuthenticator, make([]byte, authenticator))
+
+	data, _ := p.encode()
+	var zero [authenticator]byte
+	offset := attributeOffset(data, attrMessageAuthenticator)
+	copy(data[offset:], messageAuthenticator(data, zero, secret, offset))
+	signAccounting(data, secret)
+	return data
+}
+
+func TestCoA(t *testing.T) {
+	cfg := &Config{
+		Servers:  []Server{{IPaddr: "127.0.0.1", Secret: "secret"}},
+		Mappings: []Mapping{{Attribute: ciscoAVPair, Vendor: vendorCisco, Match: "shell:priv-lvl=15", Privilege: PRIVILEGE_ADMIN}},
+	}
+
+	var changes []Change
+	matched := 1
+	l := &Listener{
+		config: func() *Config { return cfg },
+		handler: func(change Change) (int, error) {
+			changes = append(changes, change)
+			return matched, nil
+		},
+	}
+
+	server := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1700}
+	admin := attribute{attrUserName, []byte("admin")}
+
+	check := func(request, data []byte, code byte, cause uint32) {
+		if data == nil {
+			t.Error("[err] request dropped")
+			return
+		}
+
+		var auth [authenticator]byte
+		copy(auth[:], request[4:headerLength])
+		if !verifyResponse(data, auth, "secret") {
+			t.Error("[err] bad response authenticator")
+		}
+		if present, valid := verifyMessage(data, auth, "secret"); !present || !valid {
+			t.Error("[err] bad Message-Authenticator")
+		}
+
+		reply, _ := decodePacket(data)
+		if reply.code != code {
+			t.Error("[err] reply code:", reply.code, "expected", code)
+		}
+		if v, found := reply.get(attrErrorCause); cause != 0 && (!found || binary.BigEndian.Uint32(v) != cause) {
+			t.Error("[err] Error-Cause:", v, "expected", cause)
+		}
+	}
+
+	t.Log("[case] Test Disconnect-Request")
+	request := coaRequest(codeDisconnectRequest, "secret", admin)
+	check(request, l.answer(request, server), codeDisconnectACK, 0)
+	if len(changes) != 1 || !changes[0].Disconnect || changes[0].Username != "admin" {
+		t.Error("[err] change:", changes)
+	}
+
+	t.Log("[case] Test CoA-Request with a mapped privilege")
+	avpair := make([]byte, 6)
+	binary.BigEndian.PutUint32(avpair, vendorCisco)
+	avpair[4], avpair[5] = ciscoAVPair, byte(2+len("shell:priv-lvl=15"))
+	avpair = append(avpair, "shell:priv-lvl=15"...)
+
+	request = coaRequest(codeCoARequest, "secret", admin, attribute{attrVendorSpecific, avpair})
+	check(request, l.answer(request, server), codeCoAACK, 0)
+	if len(changes) != 2 || changes[1].Disconnect || changes[1].Privilege != PRIVILEGE_ADMIN || changes[1].Rule == nil {
+		t.Error("[err] change:", changes)
+	}
+
+	t.Log("[case] Test NAK")
+	request = coaRequest(codeCoARequest, "secret", admin)
+	check(request, l.answer(request, server), codeCoANAK, errorUnsupportedAttribute)
+
+	request = coaRequest(codeDisconnectRequest, "secret")
+	check(request, l.answer(request, server), codeDisconnectNAK, errorMissingAttribute)
+
+	matched = 0
+	request = coaRequest(codeDisconnectRequest, "secret", admin)
+	check(request, l.answer(request, server), codeDisconnectNAK, errorSessionNotFound)
+
+	t.Log("[case] Test dropped requests")
+	if l.answer(coaRequest(codeDisconnectRequest, "wrong", admin), server) != nil {
+		t.Error("[err] request with wrong secret answered")
+	}
+
+	other := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 1700}
+	if l.answer(coaRequest(codeDisconnectRequest, "secret", admin), other) != nil {
+		t.Error("[err] request from unknown client answered")
+	}
+
+	stale := &packet{code: codeDisconnectRequest, id: 1, attributes: []attribute{admin}}
+	stale.addInt(attrEventTimestamp, uint32(time.Now().Add(-time.Hour).Unix()))
+	data, _ := stale.encode()
+	signAccounting(data, "secret")
+	if l.answer(data, server) != nil {
+		t.Error("[err] stale request answered")
+	}
+
+	if len(changes) != 3 {
+		t.Error("[err] dropped requests reached the handler:", changes)
+	}
+}
+
+func TestListen(t *testing.T) {
+	t.Log("[case] Test listener round trip")
+
+	cfg := &Config{Servers: []Server{{IPaddr: "127.0.0.1", Secret: "secret"}}}
+	l, err := Listen(0, func() *Config { return cfg }, func(change Change) (int, error) {
+		return 1, nil
+	})
+	if err != nil {
+		t.Skip("[info] CoA port in use:", err)
+	}
+	defer l.Close()
+
+	port := l.Addr().(*net.UDPAddr).Port
+	conn, err := net.Dial("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatal("[err] dial:", err)
+	}
+	defer conn.Close()
+
+	request := coaRequest(codeDisconnectRequest, "secret", attribute{attrUserName, []byte("admin")})
+	conn.Write(request)
+
+	var auth [authenticator]byte
+	copy(auth[:], request[4:headerLength])
+	reply, err := awaitResponse(conn, 42, auth, "secret", time.Second, true)
+	if err != nil || reply.code != codeDisconnectACK {
+		t.Error("[err] reply:", reply, err)
+	}
+}
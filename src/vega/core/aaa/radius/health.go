@@ -0,0 +1,169 @@
+package radius
+
+import (
+	"os"
+	"time"
+)
+
+// How servers are probed
+const (
+	PROBE_NONE = "none"
+	// RFC 5997
+	PROBE_STATUS_SERVER = "status-server"
+	// an Access-Request for ProbeUsername, a reject counts as an answer
+	PROBE_ACCESS_REQUEST = "access-request"
+)
+
+const (
+	codeStatusServer = 12
+
+	// seconds between probes
+	defaultProbeInterval = 30
+	minProbeInterval     = 5
+
+	defaultProbeUsername = "vega-probe"
+)
+
+// Status is the outcome of the last probes of a server
+type Status struct {
+	Server    string `json:"server"`
+	Reachable bool   `json:"reachable"`
+	// zero until the server answers a probe
+	LastSeen  time.Time `json:"last_seen"`
+	LastProbe time.Time `json:"last_probe"`
+	// round trip of the last answered probe
+	LatencyMs float64 `json:"latency_ms"`
+	LastError string  `json:"last_error,omitempty"`
+}
+
+// ServerStatus returns the status of every configured server, servers not
+// probed yet are not reachable
+func (config *Config) ServerStatus() []Status {
+	list := []Status{}
+
+	servers.Lock()
+	defer servers.Unlock()
+
+	for _, server := range config.Servers {
+		address := server.address(server.Port)
+		if status, ok := servers.status[address]; ok {
+			list = append(list, *status)
+		} else {
+			list = append(list, Status{Server: address})
+		}
+	}
+
+	return list
+}
+
+// unhealthy tells whether the last probe of server went unanswered,
+// servers.Mutex must be held
+func unhealthy(server Server) bool {
+	status, ok := servers.status[server.address(server.Port)]
+	return ok && !status.Reachable
+}
+
+// ProbeServers probes every server once and records the outcome
+func (config *Config) ProbeServers() {
+	for _, server := range config.Servers {
+		start := time.Now()
+		err := config.probe(server)
+		latency := time.Since(start)
+
+		address := server.address(server.Port)
+
+		servers.Lock()
+		status, ok := servers.status[address]
+		if !ok {
+			status = &Status{Server: address}
+			servers.status[address] = status
+		}
+
+		status.LastProbe = start
+		status.Reachable = err == nil
+		if err == nil {
+			status.LastSeen = start
+			status.LatencyMs = float64(latency) / float64(time.Millisecond)
+			status.LastError = ""
+			// answering a probe ends a hold-down
+			delete(servers.dead, address)
+		} else {
+			status.LastError = err.Error()
+		}
+		servers.Unlock()
+	}
+}
+
+func (config *Config) probe(server Server) error {
+	if config.Probe == PROBE_ACCESS_REQUEST {
+		username := config.ProbeUsername
+		if username == "" {
+			username = defaultProbeUsername
+		}
+
+		// reject or accept, the server answered
+		_, err := config.access(server, username, "")
+		return err
+	}
+
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+
+	request, err := newAuthenticator()
+	if err != nil {
+		return err
+	}
+
+	p := &packet{code: codeStatusServer, id: id, authenticator: request}
+	if hostname, err := os.Hostname(); err == nil {
+		p.addString(attrNASIdentifier, hostname)
+	}
+	// RFC 5997 section 3 requires one
+	p.add(attrMessageAuthenticator, make([]byte, authenticator))
+
+	data, err := p.encode()
+	if err != nil {
+		return err
+	}
+	signMessage(data, server.secret())
+
+	_, err = config.send(server, 1, id, request, data)
+	return err
+}
+
+func (config *Config) probeInterval() time.Duration {
+	if config.ProbeInterval == 0 {
+		return defaultProbeInterval * time.Second
+	}
+	return time.Duration(config.ProbeInterval) * time.Second
+}
+
+// StartProber probes the servers in the background until stop is called.
+// config is asked for the servers before every round.
+func StartProber(config func() *Config) (stop func()) {
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			cfg := config()
+			if cfg.Probe == PROBE_STATUS_SERVER || cfg.Probe == PROBE_ACCESS_REQUEST {
+				cfg.ProbeServers()
+			} else {
+				// stale outcomes must not keep servers last
+				servers.Lock()
+				servers.status = make(map[string]*Status)
+				servers.Unlock()
+			}
+
+			select {
+			case <-done:
+				return
+			case <-time.After(cfg.probeInterval()):
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
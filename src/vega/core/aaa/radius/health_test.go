@@ -0,0 +1,86 @@
+package radius
+
+import (
+	"net"
+	"testing"
+)
+
+// statusStandIn answers Status-Server requests with an Access-Accept
+func statusStandIn(t *testing.T, secret string) (*net.UDPConn, uint16) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal("[err] listen:", err)
+	}
+
+	go func() {
+		buf := make([]byte, maxPacketLength)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+
+			data := append([]byte{}, buf[:n]...)
+			request, err := decodePacket(data)
+			if err != nil || request.code != codeStatusServer {
+				continue
+			}
+
+			if present, valid := verifyMessage(data, request.authenticator, secret); !present || !valid {
+				t.Error("[err] stand-in: bad Message-Authenticator in Status-Server")
+				continue
+			}
+
+			conn.WriteToUDP(answer(request, codeAccessAccept, secret, secret, 0), addr)
+		}
+	}()
+
+	return conn, uint16(conn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+func TestProbe(t *testing.T) {
+	conn, port := statusStandIn(t, "secret")
+	defer conn.Close()
+	access, accessPort, _ := accessStandIn(t, "secret", false, true)
+	defer access.Close()
+	dead := deadPort(t)
+
+	cfg := Config{
+		Probe: PROBE_STATUS_SERVER,
+		Servers: []Server{
+			{IPaddr: "127.0.0.1", Secret: "secret", Port: dead, Timeout: 1},
+			{IPaddr: "127.0.0.1", Secret: "secret", Port: port, Timeout: 1},
+		},
+	}
+
+	t.Log("[case] Test status before probing")
+	for _, status := range cfg.ServerStatus() {
+		if status.Reachable || !status.LastProbe.IsZero() {
+			t.Error("[err] unprobed server:", status)
+		}
+	}
+
+	t.Log("[case] Test Status-Server probe")
+	cfg.ProbeServers()
+	status := cfg.ServerStatus()
+	if len(status) != 2 || status[0].Reachable || status[0].LastError == "" {
+		t.Error("[err] dead server status:", status)
+	}
+	if len(status) != 2 || !status[1].Reachable || status[1].LastSeen.IsZero() || status[1].LastError != "" {
+		t.Error("[err] live server status:", status)
+	}
+
+	t.Log("[case] Test failing server tried last")
+	cfg.Servers[0], cfg.Servers[1] = cfg.Servers[1], cfg.Servers[0]
+	if order := cfg.order(); order[0].Port != port || order[1].Port != dead {
+		t.Error("[err] order:", order)
+	}
+
+	t.Log("[case] Test Access-Request probe")
+	cfg.Probe = PROBE_ACCESS_REQUEST
+	cfg.Servers = []Server{{IPaddr: "127.0.0.1", Secret: "secret", Port: accessPort, Timeout: 1}}
+	cfg.ProbeServers()
+	if status := cfg.ServerStatus(); len(status) != 1 || !status[0].Reachable {
+		t.Error("[err] rejected probe not taken as an answer:", status)
+	}
+}
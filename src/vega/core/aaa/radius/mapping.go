@@ -0,0 +1,116 @@
+package radius
+
+import (
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// attributes holding an integer, matched as their decimal value
+var integerAttributes = map[byte]bool{
+	attrServiceType:    true,
+	attrFramedProtocol: true,
+	attrSessionTimeout: true,
+	attrPrivilege:      true,
+}
+
+// Mapping gives the privilege of users whose Access-Accept has an attribute
+// matching an expression
+type Mapping struct {
+	// attribute type, or the vendor type with Vendor set
+	Attribute uint8 `json:"attribute"`
+	// vendor ID of a vendor-specific attribute, 0 for a standard one
+	Vendor uint32 `json:"vendor,omitempty"`
+	// regular expression the whole value must match
+	Match     string `json:"match"`
+	Privilege int    `json:"privilege"`
+}
+
+func (m Mapping) String() string {
+	if m.Vendor != 0 {
+		return fmt.Sprintf("vendor %d attribute %d matching %q: privilege %d", m.Vendor, m.Attribute, m.Match, m.Privilege)
+	}
+	return fmt.Sprintf("attribute %d matching %q: privilege %d", m.Attribute, m.Match, m.Privilege)
+}
+
+func (m Mapping) compile() (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + m.Match + ")$")
+}
+
+// values returns the values of the attribute of m in p as strings
+func (m Mapping) values(p *packet) []string {
+	values := []string{}
+
+	for _, a := range p.attributes {
+		if m.Vendor == 0 {
+			if a.kind != m.Attribute {
+				continue
+			}
+			if integerAttributes[a.kind] && len(a.value) == 4 {
+				values = append(values, strconv.FormatUint(uint64(binary.BigEndian.Uint32(a.value)), 10))
+			} else {
+				values = append(values, string(a.value))
+			}
+			continue
+		}
+
+		if a.kind != attrVendorSpecific || len(a.value) < 4 || binary.BigEndian.Uint32(a.value) != m.Vendor {
+			continue
+		}
+
+		// sub-attributes in the usual type, length, value layout
+		for rest := a.value[4:]; len(rest) >= 2 && int(rest[1]) >= 2 && int(rest[1]) <= len(rest); rest = rest[rest[1]:] {
+			if rest[0] == m.Attribute {
+				values = append(values, string(rest[2:rest[1]]))
+			}
+		}
+	}
+
+	return values
+}
+
+// mapPrivilege returns the privilege of the first mapping matching an
+// attribute of an Access-Accept, or the privilege attribute without a match
+func (config *Config) mapPrivilege(p *packet) (int, *Mapping) {
+	for i, m := range config.Mappings {
+		re, err := m.compile()
+		if err != nil {
+			continue
+		}
+
+		for _, value := range m.values(p) {
+			if re.MatchString(value) {
+				return m.Privilege, &config.Mappings[i]
+			}
+		}
+	}
+
+	if v, found := p.get(attrPrivilege); found && len(v) == 4 {
+		return int(binary.BigEndian.Uint32(v)), nil
+	}
+
+	return -1, nil
+}
+
+func (config *Config) verifyMappings() (errs []error) {
+	for _, m := range config.Mappings {
+		if m.Attribute == 0 {
+			errs = append(errs, fmt.Errorf("Radius mapping %q: attribute must be set", m.Match))
+		}
+
+		if m.Vendor == 0 && m.Attribute == attrVendorSpecific {
+			errs = append(errs, fmt.Errorf("Radius mapping %q: set vendor to match a vendor-specific attribute", m.Match))
+		}
+
+		if _, err := m.compile(); err != nil {
+			errs = append(errs, fmt.Errorf("Radius mapping %q: %s", m.Match, err))
+		}
+
+		if m.Privilege < 0 {
+			errs = append(errs, fmt.Errorf("Radius mapping %q: bad privilege %d", m.Match, m.Privilege))
+		}
+	}
+
+	return
+}
@@ -0,0 +1,68 @@
+package radius
+
+import (
+	"testing"
+)
+
+func TestMapPrivilege(t *testing.T) {
+	accept := &packet{code: codeAccessAccept}
+	accept.addInt(attrServiceType, serviceLogin)
+	accept.addString(attrFilterID, "vega-operators")
+	accept.addVendor(vendorCisco, ciscoAVPair, []byte("shell:priv-lvl=15"))
+	accept.addInt(attrPrivilege, 1)
+
+	cfg := Config{Mappings: []Mapping{
+		{Attribute: attrClass, Match: "admins", Privilege: PRIVILEGE_ADMIN},
+		{Attribute: ciscoAVPair, Vendor: vendorCisco, Match: "shell:priv-lvl=1[5-9]", Privilege: PRIVILEGE_ADMIN},
+		{Attribute: attrServiceType, Match: "1", Privilege: 1},
+	}}
+
+	t.Log("[case] Test vendor-specific mapping")
+	privilege, rule := cfg.mapPrivilege(accept)
+	if privilege != PRIVILEGE_ADMIN || rule == nil || rule.Vendor != vendorCisco {
+		t.Error("[err] Cisco-AVPair:", privilege, rule)
+	}
+
+	t.Log("[case] Test integer attribute mapping")
+	cfg.Mappings = cfg.Mappings[2:]
+	if privilege, rule := cfg.mapPrivilege(accept); privilege != 1 || rule == nil {
+		t.Error("[err] Service-Type:", privilege, rule)
+	}
+
+	t.Log("[case] Test whole value match")
+	cfg.Mappings = []Mapping{{Attribute: attrFilterID, Match: "vega", Privilege: PRIVILEGE_ADMIN}}
+	if privilege, rule := cfg.mapPrivilege(accept); privilege != 1 || rule != nil {
+		t.Error("[err] partial match taken:", privilege, rule)
+	}
+
+	t.Log("[case] Test privilege attribute without a match")
+	cfg.Mappings = nil
+	if privilege, rule := cfg.mapPrivilege(accept); privilege != 1 || rule != nil {
+		t.Error("[err] privilege attribute:", privilege, rule)
+	}
+
+	if privilege, _ := cfg.mapPrivilege(&packet{code: codeAccessAccept}); privilege != -1 {
+		t.Error("[err] no privilege:", privilege)
+	}
+}
+
+func TestVerifyMappings(t *testing.T) {
+	t.Log("[case] Test verify mappings")
+
+	cfg := Config{Mappings: []Mapping{
+		{Attribute: attrFilterID, Match: "admins", Privilege: PRIVILEGE_ADMIN},
+		{Attribute: ciscoAVPair, Vendor: vendorCisco, Match: "shell:priv-lvl=15", Privilege: PRIVILEGE_ADMIN},
+	}}
+	if errs := cfg.verifyMappings(); len(errs) > 0 {
+		t.Error("[err] valid mappings:", errs)
+	}
+
+	cfg.Mappings = []Mapping{
+		{Match: "admins"},
+		{Attribute: attrVendorSpecific, Match: "x"},
+		{Attribute: attrFilterID, Match: "(", Privilege: -1},
+	}
+	if errs := cfg.verifyMappings(); len(errs) != 4 {
+		t.Error("[err] expected 4 errors, got:", errs)
+	}
+}
@@ -0,0 +1,296 @@
+package radius
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// packet codes
+const (
+	codeAccessRequest      = 1
+	codeAccessAccept       = 2
+	codeAccessReject       = 3
+	codeAccountingRequest  = 4
+	codeAccountingResponse = 5
+	codeAccessChallenge    = 11
+	codeDisconnectRequest  = 40
+	codeDisconnectACK      = 41
+	codeDisconnectNAK      = 42
+	codeCoARequest         = 43
+	codeCoAACK             = 44
+	codeCoANAK             = 45
+)
+
+// attribute types
+const (
+	attrUserName           = 1
+	attrUserPassword       = 2
+	attrServiceType        = 6
+	attrFramedProtocol     = 7
+	attrFilterID           = 11
+	attrClass              = 25
+	attrVendorSpecific     = 26
+	attrSessionTimeout     = 27
+	attrCallingStationID   = 31
+	attrNASIdentifier      = 32
+	attrAcctStatusType     = 40
+	attrAcctDelayTime      = 41
+	attrAcctSessionID      = 44
+	attrAcctSessionTime    = 46
+	attrAcctTerminateCause = 49
+	attrEventTimestamp     = 55
+	// RFC 3579 section 3.2
+	attrMessageAuthenticator = 80
+	// RFC 5176 section 3.5
+	attrErrorCause = 101
+	// privilege level sent by our RADIUS servers
+	attrPrivilege = 180
+)
+
+const (
+	headerLength       = 20
+	maxPacketLength    = 4096
+	maxAttributeLength = 253
+
+	vendorCisco   = 9
+	ciscoAVPair   = 1
+	authenticator = 16
+)
+
+var errMalformed = errors.New("Malformed RADIUS packet")
+
+type attribute struct {
+	kind  byte
+	value []byte
+}
+
+type packet struct {
+	code          byte
+	id            byte
+	authenticator [authenticator]byte
+	attributes    []attribute
+}
+
+func (p *packet) add(kind byte, value []byte) {
+	p.attributes = append(p.attributes, attribute{kind, value})
+}
+
+func (p *packet) addString(kind byte, value string) {
+	p.add(kind, []byte(value))
+}
+
+func (p *packet) addInt(kind byte, value uint32) {
+	v := make([]byte, 4)
+	binary.BigEndian.PutUint32(v, value)
+	p.add(kind, v)
+}
+
+// addVendor adds a Vendor-Specific attribute with a single sub-attribute
+func (p *packet) addVendor(vendor uint32, kind byte, value []byte) {
+	v := make([]byte, 6, 6+len(value))
+	binary.BigEndian.PutUint32(v, vendor)
+	v[4] = kind
+	v[5] = byte(2 + len(value))
+	p.add(attrVendorSpecific, append(v, value...))
+}
+
+// get returns the first attribute of a kind
+func (p *packet) get(kind byte) ([]byte, bool) {
+	for _, a := range p.attributes {
+		if a.kind == kind {
+			return a.value, true
+		}
+	}
+	return nil, false
+}
+
+func (p *packet) encode() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	buf.Write([]byte{p.code, p.id, 0, 0})
+	buf.Write(p.authenticator[:])
+
+	for _, a := range p.attributes {
+		if len(a.value) > maxAttributeLength {
+			return nil, fmt.Errorf("RADIUS attribute %d too long", a.kind)
+		}
+		buf.Write([]byte{a.kind, byte(2 + len(a.value))})
+		buf.Write(a.value)
+	}
+
+	data := buf.Bytes()
+	if len(data) > maxPacketLength {
+		return nil, errors.New("RADIUS packet too long")
+	}
+
+	binary.BigEndian.PutUint16(data[2:], uint16(len(data)))
+	return data, nil
+}
+
+func decodePacket(data []byte) (*packet, error) {
+	if len(data) < headerLength {
+		return nil, errMalformed
+	}
+
+	length := int(binary.BigEndian.Uint16(data[2:]))
+	if length < headerLength || length > len(data) || length > maxPacketLength {
+		return nil, errMalformed
+	}
+	// octets past the length are padding
+	data = data[:length]
+
+	p := &packet{code: data[0], id: data[1]}
+	copy(p.authenticator[:], data[4:headerLength])
+
+	for rest := data[headerLength:]; len(rest) > 0; {
+		if len(rest) < 2 || rest[1] < 2 || int(rest[1]) > len(rest) {
+			return nil, errMalformed
+		}
+
+		p.add(rest[0], rest[2:rest[1]])
+		rest = rest[rest[1]:]
+	}
+
+	return p, nil
+}
+
+// newAuthenticator returns a random request authenticator
+func newAuthenticator() (a [authenticator]byte, err error) {
+	_, err = rand.Read(a[:])
+	return
+}
+
+// hidePassword encrypts a User-Password, RFC 2865 section 5.2
+func hidePassword(password string, request [authenticator]byte, secret string) ([]byte, error) {
+	if len(password) > 128 {
+		return nil, errors.New("RADIUS password too long")
+	}
+
+	// pad to a multiple of 16 octets, at least 16
+	length := (len(password) + 15) / 16 * 16
+	if length == 0 {
+		length = 16
+	}
+	hidden := make([]byte, length)
+	copy(hidden, password)
+
+	last := request[:]
+	for i := 0; i < length; i += 16 {
+		b := md5.Sum(append([]byte(secret), last...))
+		for j := 0; j < 16; j++ {
+			hidden[i+j] ^= b[j]
+		}
+		last = hidden[i : i+16]
+	}
+
+	return hidden, nil
+}
+
+// attributeOffset returns the offset of the value of the first attribute of
+// a kind in an encoded packet, or -1
+func attributeOffset(data []byte, kind byte) int {
+	for i := headerLength; i+2 <= len(data) && data[i+1] >= 2; i += int(data[i+1]) {
+		if data[i] == kind {
+			return i + 2
+		}
+	}
+	return -1
+}
+
+// messageAuthenticator computes the Message-Authenticator of an encoded packet
+// as if its authenticator field held auth
+func messageAuthenticator(data []byte, auth [authenticator]byte, secret string, offset int) []byte {
+	msg := append([]byte{}, data...)
+	copy(msg[4:headerLength], auth[:])
+	for i := offset; i < offset+authenticator; i++ {
+		msg[i] = 0
+	}
+
+	h := hmac.New(md5.New, []byte(secret))
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+// signMessage fills in the Message-Authenticator of an encoded request
+func signMessage(data []byte, secret string) {
+	offset := attributeOffset(data, attrMessageAuthenticator)
+	if offset < 0 || offset+authenticator > len(data) {
+		return
+	}
+
+	var auth [authenticator]byte
+	copy(auth[:], data[4:headerLength])
+	copy(data[offset:], messageAuthenticator(data, auth, secret, offset))
+}
+
+// verifyMessage checks the Message-Authenticator of an encoded reply to a
+// request with the given authenticator
+func verifyMessage(data []byte, request [authenticator]byte, secret string) (present, valid bool) {
+	offset := attributeOffset(data, attrMessageAuthenticator)
+	if offset < 0 {
+		return false, false
+	}
+	if offset+authenticator > len(data) || data[offset-1] != 2+authenticator {
+		return true, false
+	}
+
+	sum := messageAuthenticator(data, request, secret, offset)
+	return true, hmac.Equal(sum, data[offset:offset+authenticator])
+}
+
+// newID returns a random packet identifier
+func newID() (byte, error) {
+	var id [1]byte
+	_, err := rand.Read(id[:])
+	return id[0], err
+}
+
+// signAccounting sets the request authenticator of an encoded
+// Accounting-Request, RFC 2866 section 3
+func signAccounting(data []byte, secret string) {
+	for i := 4; i < headerLength; i++ {
+		data[i] = 0
+	}
+
+	sum := md5.Sum(append(append([]byte{}, data...), secret...))
+	copy(data[4:headerLength], sum[:])
+}
+
+// signResponse sets the response authenticator of an encoded reply still
+// holding the request authenticator
+func signResponse(data []byte, secret string) {
+	sum := md5.Sum(append(append([]byte{}, data...), secret...))
+	copy(data[4:headerLength], sum[:])
+}
+
+// verifyRequest checks the request authenticator of an encoded
+// Accounting-Request, Disconnect-Request or CoA-Request
+func verifyRequest(data []byte, secret string) bool {
+	if len(data) < headerLength {
+		return false
+	}
+
+	signed := append([]byte{}, data...)
+	signAccounting(signed, secret)
+	return bytes.Equal(signed[4:headerLength], data[4:headerLength])
+}
+
+// verifyResponse checks the response authenticator of an encoded reply to a
+// request with the given authenticator
+func verifyResponse(data []byte, request [authenticator]byte, secret string) bool {
+	if len(data) < headerLength {
+		return false
+	}
+
+	h := md5.New()
+	h.Write(data[:4])
+	h.Write(request[:])
+	h.Write(data[headerLength:])
+	h.Write([]byte(secret))
+
+	return bytes.Equal(h.Sum(nil), data[4:headerLength])
+}
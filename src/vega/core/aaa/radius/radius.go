@@ -22,6 +22,7 @@ const (
 
 	pam_option_required   = "auth required  pam_radius_auth.so debug"
 	pam_option_sufficient = "auth sufficient  pam_radius_auth.so debug"
+	pam_option_accounting = "session optional  pam_radius_auth.so"
 
 	nss_key = "passwd:"
 
@@ -30,15 +31,47 @@ const (
 
 type (
 	Config struct {
-		Fallback bool     `json:"fallback"`
-		Enabled  bool     `json:"enable"`
-		Servers  []Server `json:"servers"`
+		Fallback bool `json:"fallback"`
+		Enabled  bool `json:"enable"`
+		// send accounting records for API and shell sessions
+		Accounting bool `json:"accounting"`
+		// ordered or round-robin
+		Failover string `json:"failover"`
+		// seconds a server that did not answer is tried last, 0 to never
+		DeadTime uint `json:"dead_time"`
+		// drop answers without a Message-Authenticator
+		RequireMessageAuthenticator bool `json:"require_message_authenticator"`
+		// answer Disconnect-Requests and CoA-Requests from the servers
+		CoA     bool   `json:"coa"`
+		CoAPort uint16 `json:"coa_port"`
+		// none, status-server or access-request
+		Probe string `json:"probe"`
+		// seconds between probes
+		ProbeInterval uint `json:"probe_interval"`
+		// user of access-request probes
+		ProbeUsername string `json:"probe_username"`
+		// privilege by Access-Accept attributes, first match wins
+		Mappings []Mapping `json:"mappings"`
+		Servers  []Server  `json:"servers"`
 	}
 
 	Server struct {
-		IPaddr string `json:"ip"`
-		Secret string `json:"secret"`
-		Port   uint16 `json:"port"`
+		IPaddr   string `json:"ip"`
+		Secret   string `json:"secret"`
+		Port     uint16 `json:"port"`
+		AcctPort uint16 `json:"acct_port"`
+		// seconds to wait for an answer
+		Timeout uint `json:"timeout"`
+		// requests sent before trying the next server
+		Retries uint `json:"retries"`
+		// udp or tls
+		Transport string `json:"transport"`
+		// client certificate, key and CA bundle of the tls transport
+		CertFile string `json:"cert_file"`
+		KeyFile  string `json:"key_file"`
+		CACert   string `json:"ca_cert"`
+		// name in the server certificate, the IP address when empty
+		ServerName string `json:"server_name"`
 	}
 )
 
@@ -65,6 +98,16 @@ func (cfg *Config) Legacy(legacyRoot string) {
 
 	// fallback was always true
 	cfg.Fallback = true
+	cfg.Accounting = false
+	cfg.Failover = FAILOVER_ORDERED
+	cfg.DeadTime = defaultDeadTime
+	cfg.RequireMessageAuthenticator = false
+	cfg.CoA = false
+	cfg.CoAPort = coa_port
+	cfg.Probe = PROBE_NONE
+	cfg.ProbeInterval = defaultProbeInterval
+	cfg.ProbeUsername = defaultProbeUsername
+	cfg.Mappings = []Mapping{}
 
 	return
 }
@@ -72,6 +115,18 @@ func (cfg *Config) Legacy(legacyRoot string) {
 func (config *Config) CopyFrom(otherConfig Config) {
 	config.Enabled = otherConfig.Enabled
 	config.Fallback = otherConfig.Fallback
+	config.Accounting = otherConfig.Accounting
+	config.Failover = otherConfig.Failover
+	config.DeadTime = otherConfig.DeadTime
+	config.RequireMessageAuthenticator = otherConfig.RequireMessageAuthenticator
+	config.CoA = otherConfig.CoA
+	config.CoAPort = otherConfig.CoAPort
+	config.Probe = otherConfig.Probe
+	config.ProbeInterval = otherConfig.ProbeInterval
+	config.ProbeUsername = otherConfig.ProbeUsername
+
+	config.Mappings = make([]Mapping, len(otherConfig.Mappings))
+	copy(config.Mappings, otherConfig.Mappings)
 
 	config.Servers = make([]Server, len(otherConfig.Servers))
 	copy(config.Servers, otherConfig.Servers)
@@ -167,12 +222,29 @@ func (cfg *Config) Save(oldConfig Config) (errors []error) {
 		errors = append(errors, err)
 	}
 
+	err = SetAccounting(cfg.Accounting)
+	if err != nil {
+		errors = append(errors, err)
+	}
+
 	for idx, server := range cfg.Servers {
-		if server.Port == 0 {
-			cfg.Servers[idx].Port = authserver_port
+		cfg.Servers[idx].Port = server.authPort()
+		cfg.Servers[idx].AcctPort = server.acctPort()
+		if server.Transport == "" {
+			cfg.Servers[idx].Transport = TRANSPORT_UDP
+		}
+		if server.Timeout == 0 {
+			cfg.Servers[idx].Timeout = defaultTimeout
+		}
+		if server.Retries == 0 {
+			cfg.Servers[idx].Retries = defaultRetries
 		}
 	}
 
+	if cfg.CoAPort == 0 {
+		cfg.CoAPort = coa_port
+	}
+
 	err = write_server_list(cfg.Servers)
 	if err != nil {
 		errors = append(errors, err)
@@ -189,6 +261,18 @@ func (cfg *Config) Verify() (errs []error) {
 		return
 	}
 
+	if len(cfg.Servers) == 0 && cfg.Accounting {
+		err := fmt.Errorf("Enable radius accounting requires at least 1 server")
+		errs = append(errs, err)
+		return
+	}
+
+	if len(cfg.Servers) == 0 && cfg.CoA {
+		err := fmt.Errorf("Enable radius CoA requires at least 1 server")
+		errs = append(errs, err)
+		return
+	}
+
 	for idx, server := range cfg.Servers {
 		if !util.IsIPaddress(server.IPaddr) {
 			err := fmt.Errorf("Bad Radius server IP: %s", server.IPaddr)
@@ -202,12 +286,51 @@ func (cfg *Config) Verify() (errs []error) {
 			}
 		}
 
-		if server.Secret == "" {
-			err := fmt.Errorf("Can not have empty server secret")
+		switch server.Transport {
+		case "", TRANSPORT_UDP:
+			if server.Secret == "" {
+				err := fmt.Errorf("Can not have empty server secret")
+				errs = append(errs, err)
+			}
+		case TRANSPORT_TLS:
+			errs = append(errs, server.verifyTLS()...)
+		default:
+			err := fmt.Errorf("Bad Radius server transport: %s, use %s or %s", server.Transport, TRANSPORT_UDP, TRANSPORT_TLS)
+			errs = append(errs, err)
+		}
+
+		if server.Timeout > maxTimeout {
+			err := fmt.Errorf("Radius server %s: timeout must be at most %d seconds", server.IPaddr, maxTimeout)
+			errs = append(errs, err)
+		}
+
+		if server.Retries > maxRetries {
+			err := fmt.Errorf("Radius server %s: retries must be at most %d", server.IPaddr, maxRetries)
 			errs = append(errs, err)
 		}
 	}
 
+	errs = append(errs, cfg.verifyMappings()...)
+
+	switch cfg.Probe {
+	case "", PROBE_NONE, PROBE_STATUS_SERVER, PROBE_ACCESS_REQUEST:
+	default:
+		err := fmt.Errorf("Bad Radius probe: %s, use %s, %s or %s", cfg.Probe, PROBE_NONE, PROBE_STATUS_SERVER, PROBE_ACCESS_REQUEST)
+		errs = append(errs, err)
+	}
+
+	if cfg.ProbeInterval != 0 && cfg.ProbeInterval < minProbeInterval {
+		err := fmt.Errorf("Radius probe interval must be at least %d seconds", minProbeInterval)
+		errs = append(errs, err)
+	}
+
+	switch cfg.Failover {
+	case "", FAILOVER_ORDERED, FAILOVER_ROUND_ROBIN:
+	default:
+		err := fmt.Errorf("Bad Radius failover: %s, use %s or %s", cfg.Failover, FAILOVER_ORDERED, FAILOVER_ROUND_ROBIN)
+		errs = append(errs, err)
+	}
+
 	return
 }
 
@@ -215,6 +338,16 @@ func (cfg *Config) Factory() {
 	cfg.Servers = []Server{}
 	cfg.Enabled = false
 	cfg.Fallback = false
+	cfg.Accounting = false
+	cfg.Failover = FAILOVER_ORDERED
+	cfg.DeadTime = defaultDeadTime
+	cfg.RequireMessageAuthenticator = false
+	cfg.CoA = false
+	cfg.CoAPort = coa_port
+	cfg.Probe = PROBE_NONE
+	cfg.ProbeInterval = defaultProbeInterval
+	cfg.ProbeUsername = defaultProbeUsername
+	cfg.Mappings = []Mapping{}
 
 	return
 }
@@ -334,6 +467,10 @@ func read_server_list(root_path string) (servers []Server, err error) {
 		}
 
 		server.Secret = kv_pair.Values[0]
+		if len(kv_pair.Values) > 1 {
+			timeout, _ := strconv.Atoi(kv_pair.Values[1])
+			server.Timeout = uint(timeout)
+		}
 		servers = append(servers, server)
 	}
 
@@ -354,6 +491,11 @@ func write_server_list(servers []Server) (err error) {
 	}
 
 	for _, server := range servers {
+		if server.tls() {
+			// pam_radius_auth speaks UDP only
+			continue
+		}
+
 		if server.Port == 0 {
 			server.Port = authserver_port
 		}
@@ -363,8 +505,12 @@ func write_server_list(servers []Server) (err error) {
 			ipAddr = "[" + ipAddr + "]"
 		}
 
+		if server.Timeout == 0 {
+			server.Timeout = defaultTimeout
+		}
+
 		err = cfg_file.AddLine(ipAddr + ":" + strconv.Itoa(int(server.Port)) +
-			" " + server.Secret)
+			" " + server.Secret + " " + strconv.Itoa(int(server.Timeout)))
 		if err != nil {
 			break
 		}
@@ -443,6 +589,28 @@ func Enable(fallback bool) (err error) {
 	return
 }
 
+// SetAccounting adds or removes the PAM session line sending accounting
+// records for shell logins
+func SetAccounting(enable bool) (err error) {
+
+	pam_file, err := cfg.LoadConfig(pam_radius)
+	if err != nil {
+		return
+	}
+	defer pam_file.Close()
+
+	err = pam_file.DeleteByKey("session")
+	if err != nil {
+		return
+	}
+
+	if enable {
+		err = pam_file.AddLine(pam_option_accounting)
+	}
+
+	return
+}
+
 func Disable() (err error) {
 
 	pam_file, err := cfg.LoadConfig(pam_radius)
@@ -0,0 +1,156 @@
+package radius
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+)
+
+// Transports of a server
+const (
+	TRANSPORT_UDP = "udp"
+	// RadSec, RFC 6614
+	TRANSPORT_TLS = "tls"
+)
+
+const (
+	radsec_port = 2083
+
+	// shared secret of every RadSec server, RFC 6614 section 2.3
+	radsecSecret = "radsec"
+)
+
+func (server Server) tls() bool {
+	return server.Transport == TRANSPORT_TLS
+}
+
+// authPort returns the port of Access-Requests
+func (server Server) authPort() uint16 {
+	switch {
+	case server.Port != 0:
+		return server.Port
+	case server.tls():
+		return radsec_port
+	}
+	return authserver_port
+}
+
+// acctPort returns the port of Accounting-Requests, RadSec takes both on one
+func (server Server) acctPort() uint16 {
+	switch {
+	case server.AcctPort != 0:
+		return server.AcctPort
+	case server.tls():
+		return server.authPort()
+	}
+	return acctserver_port
+}
+
+func (server Server) secret() string {
+	if server.tls() && server.Secret == "" {
+		return radsecSecret
+	}
+	return server.Secret
+}
+
+// dial connects to port of server over its transport
+func (server Server) dial(port uint16) (net.Conn, error) {
+	if !server.tls() {
+		return net.Dial("udp", server.address(port))
+	}
+
+	tlsConfig, err := server.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: server.timeout()}
+	return tls.DialWithDialer(dialer, "tcp", server.address(port), tlsConfig)
+}
+
+func (server Server) tlsConfig() (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(server.CertFile, server.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := loadCACert(server.CACert)
+	if err != nil {
+		return nil, err
+	}
+
+	serverName := server.ServerName
+	if serverName == "" {
+		serverName = server.IPaddr
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		RootCAs:      pool,
+		ServerName:   serverName,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+func loadCACert(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("No certificate in Radius CA file: %s", path)
+	}
+
+	return pool, nil
+}
+
+// attempts returns how often to send a request, once over TLS as the
+// transport retransmits by itself
+func (server Server) attempts() int {
+	if server.tls() {
+		return 1
+	}
+	return server.retries()
+}
+
+// readPacket reads one packet, framing it by its length over TLS
+func readPacket(conn net.Conn, buf []byte) (int, error) {
+	if _, ok := conn.(*tls.Conn); !ok {
+		return conn.Read(buf)
+	}
+
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return 0, err
+	}
+
+	length := int(binary.BigEndian.Uint16(buf[2:]))
+	if length < headerLength || length > len(buf) {
+		return 0, errMalformed
+	}
+
+	_, err := io.ReadFull(conn, buf[4:length])
+	return length, err
+}
+
+func (server Server) verifyTLS() (errs []error) {
+	if server.CertFile == "" || server.KeyFile == "" || server.CACert == "" {
+		err := fmt.Errorf("Radius server %s: TLS needs a certificate, key and CA file", server.IPaddr)
+		return append(errs, err)
+	}
+
+	if _, err := tls.LoadX509KeyPair(server.CertFile, server.KeyFile); err != nil {
+		errs = append(errs, fmt.Errorf("Radius server %s: %s", server.IPaddr, err))
+	}
+
+	if _, err := loadCACert(server.CACert); err != nil {
+		errs = append(errs, fmt.Errorf("Radius server %s: %s", server.IPaddr, err))
+	}
+
+	return
+}
@@ -0,0 +1,237 @@
+package radius
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// pki is a CA with a server and a client certificate written to a directory
+type pki struct {
+	dir    string
+	ca     *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	pool   *x509.CertPool
+	server tls.Certificate
+}
+
+func newPKI(t *testing.T) *pki {
+	dir, err := ioutil.TempDir("", "radsec")
+	if err != nil {
+		t.Fatal("[err] temp dir:", err)
+	}
+
+	p := &pki{dir: dir, pool: x509.NewCertPool()}
+
+	p.caKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "radsec CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &p.caKey.PublicKey, p.caKey)
+	if err != nil {
+		t.Fatal("[err] CA certificate:", err)
+	}
+	p.ca, _ = x509.ParseCertificate(der)
+	p.pool.AddCert(p.ca)
+	p.write(t, "ca.pem", "CERTIFICATE", der)
+
+	p.server = p.issue(t, "server", x509.ExtKeyUsageServerAuth)
+	p.issue(t, "client", x509.ExtKeyUsageClientAuth)
+
+	return p
+}
+
+// issue signs a certificate for 127.0.0.1, written as name.pem and name.key
+func (p *pki) issue(t *testing.T, name string, usage x509.ExtKeyUsage) tls.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, p.ca, &key.PublicKey, p.caKey)
+	if err != nil {
+		t.Fatal("[err] certificate:", err)
+	}
+
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	p.write(t, name+".pem", "CERTIFICATE", der)
+	p.write(t, name+".key", "EC PRIVATE KEY", keyDER)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func (p *pki) write(t *testing.T, name, kind string, der []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der})
+	if err := ioutil.WriteFile(filepath.Join(p.dir, name), data, 0600); err != nil {
+		t.Fatal("[err] write:", err)
+	}
+}
+
+func (p *pki) path(name string) string {
+	return filepath.Join(p.dir, name)
+}
+
+// radsecStandIn accepts "admin" with password "password" and acknowledges
+// accounting, only for clients with a certificate of the CA
+func radsecStandIn(t *testing.T, p *pki) (net.Listener, uint16) {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{p.server},
+		ClientCAs:    p.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	if err != nil {
+		t.Fatal("[err] listen:", err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn net.Conn) {
+				defer conn.Close()
+				buf := make([]byte, maxPacketLength)
+
+				for {
+					n, err := readPacket(conn, buf)
+					if err != nil {
+						return
+					}
+
+					data := append([]byte{}, buf[:n]...)
+					request, err := decodePacket(data)
+					if err != nil {
+						return
+					}
+
+					var code byte
+					switch request.code {
+					case codeAccessRequest:
+						hidden, _ := request.get(attrUserPassword)
+						password, _ := hidePassword(string(hidden[:16]), request.authenticator, radsecSecret)
+						code = codeAccessReject
+						if string(bytes.TrimRight(password, "\x00")) == "password" {
+							code = codeAccessAccept
+						}
+					case codeAccountingRequest:
+						if !verifyRequest(data, radsecSecret) {
+							return
+						}
+						code = codeAccountingResponse
+					default:
+						return
+					}
+
+					conn.Write(answer(request, code, radsecSecret, radsecSecret, 0))
+				}
+			}(conn)
+		}
+	}()
+
+	return listener, uint16(listener.Addr().(*net.TCPAddr).Port)
+}
+
+func TestRadSec(t *testing.T) {
+	p := newPKI(t)
+	defer os.RemoveAll(p.dir)
+
+	listener, port := radsecStandIn(t, p)
+	defer listener.Close()
+
+	server := Server{
+		IPaddr:    "127.0.0.1",
+		Port:      port,
+		Timeout:   2,
+		Transport: TRANSPORT_TLS,
+		CertFile:  p.path("client.pem"),
+		KeyFile:   p.path("client.key"),
+		CACert:    p.path("ca.pem"),
+	}
+	cfg := Config{Servers: []Server{server}}
+
+	t.Log("[case] Test authenticate over TLS")
+	if _, _, ok, errs := cfg.Authenticate("admin", "password"); !ok || len(errs) > 0 {
+		t.Error("[err] accept:", ok, errs)
+	}
+	if _, _, ok, errs := cfg.Authenticate("admin", "wrong"); ok || len(errs) > 0 {
+		t.Error("[err] reject:", ok, errs)
+	}
+
+	t.Log("[case] Test accounting over TLS")
+	if errs := cfg.Account(Accounting{Status: ACCT_START, SessionID: "1", Username: "admin"}); len(errs) > 0 {
+		t.Error("[err] accounting:", errs)
+	}
+
+	t.Log("[case] Test server certificate of another name")
+	cfg.Servers[0].ServerName = "radius.example.com"
+	if _, _, ok, errs := cfg.Authenticate("admin", "password"); ok || len(errs) != 2 {
+		t.Error("[err] wrong server name accepted:", ok, errs)
+	}
+
+	t.Log("[case] Test client certificate not for client auth")
+	cfg.Servers[0].ServerName = ""
+	cfg.Servers[0].CertFile = p.path("server.pem")
+	cfg.Servers[0].KeyFile = p.path("server.key")
+	if _, _, ok, _ := cfg.Authenticate("admin", "password"); ok {
+		t.Error("[err] client with a server only certificate accepted")
+	}
+}
+
+func TestVerifyTLS(t *testing.T) {
+	p := newPKI(t)
+	defer os.RemoveAll(p.dir)
+
+	t.Log("[case] Test verify TLS server")
+	server := Server{
+		IPaddr:    "127.0.0.1",
+		Transport: TRANSPORT_TLS,
+		CertFile:  p.path("client.pem"),
+		KeyFile:   p.path("client.key"),
+		CACert:    p.path("ca.pem"),
+	}
+	cfg := Config{Enabled: true, Servers: []Server{server}}
+	if errs := cfg.Verify(); len(errs) > 0 {
+		t.Error("[err] valid TLS server:", errs)
+	}
+
+	cfg.Servers[0].CertFile = ""
+	if errs := cfg.Verify(); len(errs) != 1 {
+		t.Error("[err] missing certificate:", errs)
+	}
+
+	cfg.Servers[0].CertFile = p.path("missing.pem")
+	cfg.Servers[0].CACert = p.path("client.key")
+	if errs := cfg.Verify(); len(errs) != 2 {
+		t.Error("[err] expected 2 errors, got:", errs)
+	}
+
+	cfg.Servers[0].Transport = "dtls"
+	if errs := cfg.Verify(); len(errs) != 1 {
+		t.Error("[err] bad transport:", errs)
+	}
+}
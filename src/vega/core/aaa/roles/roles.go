@@ -0,0 +1,282 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+// Package roles provide named API roles and the routes they may access
+package roles
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	ROLE_AUDITOR  = "auditor"
+	ROLE_OPERATOR = "operator"
+	ROLE_ADMIN    = "admin"
+
+	// matches any method
+	METHOD_ANY = "*"
+	// path segment matching any single segment
+	SEGMENT_ANY = "*"
+	// last path segment matching any remainder, including none
+	SEGMENT_REST = "**"
+)
+
+var validMethods = map[string]bool{
+	METHOD_ANY: true,
+	"GET":      true,
+	"POST":     true,
+	"PUT":      true,
+	"PATCH":    true,
+	"DELETE":   true,
+}
+
+type (
+	Config struct {
+		Enabled bool   `json:"enable"`
+		Roles   []Role `json:"roles"`
+	}
+
+	Role struct {
+		Name        string       `json:"name"`
+		Permissions []Permission `json:"permissions"`
+		// local groups or LDAP group DNs whose members get the role
+		Groups []string `json:"groups"`
+		// RADIUS privilege attribute values that get the role
+		RadiusPrivileges []int `json:"radius_privileges"`
+		// TACACS+ priv-lvl values that get the role
+		TacacsPrivileges []int `json:"tacacs_privileges"`
+	}
+
+	// Permission allows a method on the paths matching a pattern such as
+	// "/aaa/localusers/*" or "/tasks/**"
+	Permission struct {
+		Method string `json:"method"`
+		Path   string `json:"path"`
+	}
+)
+
+func (p Permission) String() string {
+	return p.Method + " " + p.Path
+}
+
+// Match reports whether the permission allows method on path
+func (p Permission) Match(method, path string) bool {
+	if p.Method != METHOD_ANY && !strings.EqualFold(p.Method, method) {
+		return false
+	}
+
+	pattern := splitPath(p.Path)
+	segments := splitPath(path)
+
+	for i, part := range pattern {
+		if part == SEGMENT_REST && i == len(pattern)-1 {
+			return true
+		}
+
+		if i >= len(segments) {
+			return false
+		}
+
+		if part != SEGMENT_ANY && part != segments[i] {
+			return false
+		}
+	}
+
+	return len(pattern) == len(segments)
+}
+
+func splitPath(path string) []string {
+	return strings.FieldsFunc(path, func(c rune) bool {
+		return c == '/'
+	})
+}
+
+func (config *Config) Legacy(legacyRoot string) {
+	config.Factory()
+}
+
+func (config *Config) CopyFrom(otherConfig Config) {
+	config.Enabled = otherConfig.Enabled
+
+	config.Roles = make([]Role, len(otherConfig.Roles))
+	for i, role := range otherConfig.Roles {
+		config.Roles[i].Name = role.Name
+
+		config.Roles[i].Permissions = make([]Permission, len(role.Permissions))
+		copy(config.Roles[i].Permissions, role.Permissions)
+
+		config.Roles[i].Groups = make([]string, len(role.Groups))
+		copy(config.Roles[i].Groups, role.Groups)
+
+		config.Roles[i].RadiusPrivileges = make([]int, len(role.RadiusPrivileges))
+		copy(config.Roles[i].RadiusPrivileges, role.RadiusPrivileges)
+
+		config.Roles[i].TacacsPrivileges = make([]int, len(role.TacacsPrivileges))
+		copy(config.Roles[i].TacacsPrivileges, role.TacacsPrivileges)
+	}
+}
+
+func (config *Config) CopyFromInterface(data interface{}) bool {
+	otherConfig, ok := data.(*Config)
+	if !ok {
+		return false
+	}
+
+	config.CopyFrom(*otherConfig)
+	return true
+}
+
+func (config *Config) CloneInterface() interface{} {
+	return config.Clone()
+}
+
+func (config *Config) Clone() *Config {
+	newConfig := new(Config)
+	newConfig.CopyFrom(*config)
+
+	return newConfig
+}
+
+func (config *Config) SaveInterface(data interface{}) (bool, []error) {
+	oldConfig, ok := data.(*Config)
+	if !ok {
+		return false, nil
+	}
+
+	return true, config.Save(*oldConfig)
+}
+
+// Save has nothing to write to the system, the API reads the running config
+func (config *Config) Save(oldConfig Config) []error {
+	return nil
+}
+
+func (config *Config) Verify() (errs []error) {
+	names := make(map[string]bool)
+
+	for _, role := range config.Roles {
+		if role.Name == "" {
+			errs = append(errs, errors.New("Empty role name"))
+		} else if names[role.Name] {
+			errs = append(errs, fmt.Errorf("Duplicate role: %s", role.Name))
+		}
+		names[role.Name] = true
+
+		for _, p := range role.Permissions {
+			if !validMethods[strings.ToUpper(p.Method)] {
+				errs = append(errs, fmt.Errorf("Bad method in permission %q of role: %s", p.String(), role.Name))
+			}
+
+			if !strings.HasPrefix(p.Path, "/") {
+				errs = append(errs, fmt.Errorf("Bad path in permission %q of role: %s", p.String(), role.Name))
+			}
+
+			parts := splitPath(p.Path)
+			for i, part := range parts {
+				if part == SEGMENT_REST && i != len(parts)-1 {
+					errs = append(errs, fmt.Errorf("%q must be the last segment in permission %q of role: %s", SEGMENT_REST, p.String(), role.Name))
+				}
+			}
+		}
+
+		for _, group := range role.Groups {
+			if group == "" {
+				errs = append(errs, fmt.Errorf("Empty group of role: %s", role.Name))
+			}
+		}
+	}
+
+	if config.Enabled && len(config.Roles) == 0 {
+		errs = append(errs, errors.New("Enable roles requires at least 1 role"))
+	}
+
+	return
+}
+
+func (config *Config) Factory() {
+	config.Enabled = false
+	config.Roles = []Role{
+		{
+			Name:             ROLE_AUDITOR,
+			Permissions:      []Permission{{"GET", "/**"}},
+			Groups:           []string{"users"},
+			RadiusPrivileges: []int{1},
+			TacacsPrivileges: []int{1},
+		},
+		{
+			Name:             ROLE_OPERATOR,
+			Permissions:      []Permission{{"GET", "/**"}, {METHOD_ANY, "/tasks/**"}},
+			Groups:           []string{},
+			RadiusPrivileges: []int{},
+			TacacsPrivileges: []int{7},
+		},
+		{
+			Name:             ROLE_ADMIN,
+			Permissions:      []Permission{{METHOD_ANY, "/**"}},
+			Groups:           []string{"wheel"},
+			RadiusPrivileges: []int{2},
+			TacacsPrivileges: []int{15},
+		},
+	}
+}
+
+// Resolve returns the roles granted to a user in the given local groups or
+// with the given RADIUS privilege attribute or TACACS+ priv-lvl. Pass -1 for
+// a privilege the user was not given.
+func (config *Config) Resolve(groups []string, radiusPrivilege, tacacsPrivilege int) []string {
+	names := []string{}
+
+outer:
+	for _, role := range config.Roles {
+		for _, privilege := range role.RadiusPrivileges {
+			if privilege == radiusPrivilege {
+				names = append(names, role.Name)
+				continue outer
+			}
+		}
+
+		for _, privilege := range role.TacacsPrivileges {
+			if privilege == tacacsPrivilege {
+				names = append(names, role.Name)
+				continue outer
+			}
+		}
+
+		for _, group := range role.Groups {
+			for _, userGroup := range groups {
+				if group == userGroup {
+					names = append(names, role.Name)
+					continue outer
+				}
+			}
+		}
+	}
+
+	return names
+}
+
+// Allowed reports whether any of the named roles allows method on path. It
+// returns the permission that would be needed otherwise.
+func (config *Config) Allowed(names []string, method, path string) (bool, Permission) {
+	for _, role := range config.Roles {
+		granted := false
+		for _, name := range names {
+			if name == role.Name {
+				granted = true
+				break
+			}
+		}
+		if !granted {
+			continue
+		}
+
+		for _, p := range role.Permissions {
+			if p.Match(method, path) {
+				return true, p
+			}
+		}
+	}
+
+	return false, Permission{Method: strings.ToUpper(method), Path: path}
+}
@@ -0,0 +1,113 @@
+package roles
+
+import (
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	t.Log("[case] Test permission patterns")
+
+	cases := []struct {
+		permission Permission
+		method     string
+		path       string
+		want       bool
+	}{
+		{Permission{"GET", "/**"}, "GET", "/aaa/radius", true},
+		{Permission{"GET", "/**"}, "POST", "/aaa/radius", false},
+		{Permission{"get", "/tasks"}, "GET", "/tasks", true},
+		{Permission{METHOD_ANY, "/tasks/**"}, "DELETE", "/tasks/3", true},
+		{Permission{METHOD_ANY, "/tasks/**"}, "GET", "/tasks", true},
+		{Permission{METHOD_ANY, "/tasks/**"}, "GET", "/system", false},
+		{Permission{"PUT", "/aaa/localusers/*"}, "PUT", "/aaa/localusers/bob", true},
+		{Permission{"PUT", "/aaa/localusers/*"}, "PUT", "/aaa/localusers", false},
+		{Permission{"PUT", "/aaa/localusers/*"}, "PUT", "/aaa/localusers/bob/keys", false},
+	}
+
+	for _, c := range cases {
+		if got := c.permission.Match(c.method, c.path); got != c.want {
+			t.Error("[err]", c.permission, c.method, c.path, "got:", got, "want:", c.want)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	t.Log("[case] Test verify roles")
+
+	var cfg Config
+	cfg.Factory()
+	if errs := cfg.Verify(); len(errs) > 0 {
+		t.Error("[err] factory config:", errs)
+	}
+
+	cfg.Roles = append(cfg.Roles,
+		Role{Name: ROLE_ADMIN},
+		Role{Name: "broken", Permissions: []Permission{
+			{"FETCH", "/tasks"},
+			{"GET", "tasks"},
+			{"GET", "/**/tasks"},
+		}},
+	)
+	if errs := cfg.Verify(); len(errs) != 4 {
+		t.Error("[err] expected 4 errors, got:", errs)
+	}
+
+	cfg = Config{Enabled: true}
+	if errs := cfg.Verify(); len(errs) != 1 {
+		t.Error("[err] expected 1 error, got:", errs)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	t.Log("[case] Test resolve roles from groups and remote privileges")
+
+	var cfg Config
+	cfg.Factory()
+
+	if names := cfg.Resolve([]string{"users", "wheel"}, -1, -1); len(names) != 2 || names[0] != ROLE_AUDITOR || names[1] != ROLE_ADMIN {
+		t.Error("[err] local groups:", names)
+	}
+
+	if names := cfg.Resolve(nil, 2, -1); len(names) != 1 || names[0] != ROLE_ADMIN {
+		t.Error("[err] RADIUS privilege:", names)
+	}
+
+	if names := cfg.Resolve(nil, -1, 7); len(names) != 1 || names[0] != ROLE_OPERATOR {
+		t.Error("[err] TACACS+ privilege:", names)
+	}
+
+	if names := cfg.Resolve([]string{"nobody"}, -1, -1); len(names) != 0 {
+		t.Error("[err] unknown group:", names)
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	t.Log("[case] Test role permissions")
+
+	var cfg Config
+	cfg.Factory()
+
+	if ok, _ := cfg.Allowed([]string{ROLE_AUDITOR}, "GET", "/aaa/radius"); !ok {
+		t.Error("[err] auditor denied read")
+	}
+
+	ok, missing := cfg.Allowed([]string{ROLE_AUDITOR}, "put", "/aaa/radius")
+	if ok {
+		t.Error("[err] auditor allowed write")
+	}
+	if missing.String() != "PUT /aaa/radius" {
+		t.Error("[err] missing permission:", missing)
+	}
+
+	if ok, _ := cfg.Allowed([]string{ROLE_OPERATOR}, "DELETE", "/tasks/1"); !ok {
+		t.Error("[err] operator denied task")
+	}
+
+	if ok, _ := cfg.Allowed([]string{ROLE_AUDITOR, ROLE_ADMIN}, "DELETE", "/tasks/1"); !ok {
+		t.Error("[err] admin denied")
+	}
+
+	if ok, _ := cfg.Allowed(nil, "GET", "/aaa/radius"); ok {
+		t.Error("[err] no role allowed")
+	}
+}
@@ -0,0 +1,473 @@
+package tacacs
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/htbig/common/src/vega/syslogger"
+)
+
+const TacacsNoServerError = "TACACS+: No TACACS+ server is set"
+const TacacsAuthError = "TACACS+: Failed to authenticate with any servers"
+
+const (
+	// privilege level of users without an authorized level
+	PRIVILEGE_USER = 1
+	// privilege level of administrators
+	PRIVILEGE_ADMIN = 15
+)
+
+const (
+	timeout = 5 * time.Second
+
+	headerLength  = 12
+	maxBodyLength = 1 << 16
+
+	versionDefault = 0xc0
+	versionOne     = 0xc1
+
+	typeAuthen = 0x01
+	typeAuthor = 0x02
+
+	flagUnencrypted = 0x01
+
+	authenActionLogin  = 0x01
+	authenTypeASCII    = 0x01
+	authenTypePAP      = 0x02
+	authenServiceLogin = 0x01
+	authenMethodTACACS = 0x06
+
+	authenStatusPass    = 0x01
+	authenStatusFail    = 0x02
+	authenStatusGetData = 0x03
+	authenStatusGetUser = 0x04
+	authenStatusGetPass = 0x05
+	authenStatusError   = 0x07
+
+	authorStatusPassAdd  = 0x01
+	authorStatusPassRepl = 0x02
+	authorStatusFail     = 0x10
+
+	// ASCII logins give up after this many prompts
+	maxPrompts = 4
+
+	clientPort = "api"
+)
+
+var authorArgs = []string{"service=shell", "cmd="}
+
+// Authenticate logs the user in with the configured servers, moving to the
+// next server only when one does not answer. On success it returns the
+// privilege level the server authorizes for a shell.
+func (config *Config) Authenticate(username, password string) (privilege int, ok bool, errs []error) {
+	privilege = -1
+
+	if len(config.Servers) == 0 {
+		errs = append(errs, errors.New(TacacsNoServerError))
+		return
+	}
+
+	// lengths are single bytes on the wire, no server could accept these
+	if len(username) > 0xff || len(password) > 0xff {
+		return -1, false, nil
+	}
+
+	authenType := byte(authenTypePAP)
+	if config.Login == LOGIN_ASCII {
+		authenType = authenTypeASCII
+	}
+
+	var server_errs []error
+
+	for _, server := range config.Servers {
+		privilege, ok, err := authenticate(server, authenType, username, password)
+		if err == nil {
+			return privilege, ok, nil
+		}
+
+		err = fmt.Errorf("%s: %s", server.IPaddr, err.Error())
+		server_errs = append(server_errs, err)
+		syslogger.Err("TACACS+ auth:", err)
+	}
+
+	errs = append(errs, errors.New(TacacsAuthError))
+	errs = append(errs, server_errs...)
+
+	return
+}
+
+func authenticate(server Server, authenType byte, username, password string) (int, bool, error) {
+	var ok bool
+	var err error
+
+	if authenType == authenTypePAP {
+		ok, err = loginPAP(server, username, password)
+	} else {
+		ok, err = loginASCII(server, username, password)
+	}
+
+	if err != nil || !ok {
+		return -1, false, err
+	}
+
+	return authorize(server, authenType, username)
+}
+
+func loginPAP(server Server, username, password string) (bool, error) {
+	s, err := dial(server)
+	if err != nil {
+		return false, err
+	}
+	defer s.close()
+
+	err = s.send(typeAuthen, versionOne, authenStart(authenTypePAP, username, password))
+	if err != nil {
+		return false, err
+	}
+
+	reply, err := s.receiveAuthen()
+	if err != nil {
+		return false, err
+	}
+
+	switch reply.status {
+	case authenStatusPass:
+		return true, nil
+	case authenStatusFail:
+		return false, nil
+	default:
+		return false, reply.err()
+	}
+}
+
+func loginASCII(server Server, username, password string) (bool, error) {
+	s, err := dial(server)
+	if err != nil {
+		return false, err
+	}
+	defer s.close()
+
+	err = s.send(typeAuthen, versionDefault, authenStart(authenTypeASCII, username, ""))
+	if err != nil {
+		return false, err
+	}
+
+	for i := 0; i < maxPrompts; i++ {
+		reply, err := s.receiveAuthen()
+		if err != nil {
+			return false, err
+		}
+
+		var answer string
+		switch reply.status {
+		case authenStatusPass:
+			return true, nil
+		case authenStatusFail:
+			return false, nil
+		case authenStatusGetUser:
+			answer = username
+		case authenStatusGetPass, authenStatusGetData:
+			answer = password
+		default:
+			return false, reply.err()
+		}
+
+		if err := s.send(typeAuthen, versionDefault, authenContinue(answer)); err != nil {
+			return false, err
+		}
+	}
+
+	return false, errors.New("Too many login prompts")
+}
+
+func authorize(server Server, authenType byte, username string) (int, bool, error) {
+	s, err := dial(server)
+	if err != nil {
+		return -1, false, err
+	}
+	defer s.close()
+
+	err = s.send(typeAuthor, versionDefault, authorRequest(authenType, username, authorArgs))
+	if err != nil {
+		return -1, false, err
+	}
+
+	reply, err := s.receiveAuthor()
+	if err != nil {
+		return -1, false, err
+	}
+
+	switch reply.status {
+	case authorStatusPassAdd, authorStatusPassRepl:
+		return privilegeOf(reply.args), true, nil
+	case authorStatusFail:
+		return -1, false, nil
+	default:
+		return -1, false, reply.err()
+	}
+}
+
+// privilegeOf returns the priv-lvl attribute of an authorization reply,
+// mandatory ("=") or optional ("*")
+func privilegeOf(args []string) int {
+	for _, arg := range args {
+		i := strings.IndexAny(arg, "=*")
+		if i < 0 || arg[:i] != "priv-lvl" {
+			continue
+		}
+
+		if level, err := strconv.Atoi(arg[i+1:]); err == nil {
+			return level
+		}
+	}
+
+	return PRIVILEGE_USER
+}
+
+// session is one TACACS+ exchange over its own connection
+type session struct {
+	conn   net.Conn
+	id     uint32
+	seq    byte
+	secret []byte
+}
+
+func dial(server Server) (*session, error) {
+	var id [4]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout("tcp", server.address(), timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	s := &session{
+		conn:   conn,
+		id:     binary.BigEndian.Uint32(id[:]),
+		secret: []byte(server.Secret),
+	}
+
+	return s, nil
+}
+
+func (s *session) close() {
+	s.conn.Close()
+}
+
+func (s *session) send(packetType, version byte, body []byte) error {
+	s.seq++
+
+	packet := make([]byte, headerLength+len(body))
+	packet[0] = version
+	packet[1] = packetType
+	packet[2] = s.seq
+	packet[3] = 0
+	binary.BigEndian.PutUint32(packet[4:], s.id)
+	binary.BigEndian.PutUint32(packet[8:], uint32(len(body)))
+	copy(packet[headerLength:], body)
+	crypt(packet[headerLength:], s.secret, s.id, version, s.seq)
+
+	_, err := s.conn.Write(packet)
+	return err
+}
+
+func (s *session) receive(packetType byte) ([]byte, error) {
+	header := make([]byte, headerLength)
+	if _, err := io.ReadFull(s.conn, header); err != nil {
+		return nil, err
+	}
+
+	version, seq, flags := header[0], header[2], header[3]
+	id := binary.BigEndian.Uint32(header[4:])
+	length := binary.BigEndian.Uint32(header[8:])
+
+	switch {
+	case version&0xf0 != versionDefault:
+		return nil, fmt.Errorf("Bad version 0x%02x", version)
+	case header[1] != packetType:
+		return nil, fmt.Errorf("Bad packet type %d", header[1])
+	case seq != s.seq+1:
+		return nil, fmt.Errorf("Bad sequence number %d", seq)
+	case id != s.id:
+		return nil, errors.New("Bad session ID")
+	case flags&flagUnencrypted != 0:
+		return nil, errors.New("Refused unencrypted reply")
+	case length > maxBodyLength:
+		return nil, fmt.Errorf("Reply too long: %d", length)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.conn, body); err != nil {
+		return nil, err
+	}
+
+	s.seq = seq
+	crypt(body, s.secret, id, version, seq)
+	return body, nil
+}
+
+type authenReply struct {
+	status  byte
+	message string
+}
+
+func (r authenReply) err() error {
+	if r.message != "" {
+		return fmt.Errorf("Authentication status %d: %s", r.status, r.message)
+	}
+	return fmt.Errorf("Authentication status %d", r.status)
+}
+
+func (s *session) receiveAuthen() (authenReply, error) {
+	var reply authenReply
+
+	body, err := s.receive(typeAuthen)
+	if err != nil {
+		return reply, err
+	}
+
+	if len(body) < 6 {
+		return reply, errors.New("Short authentication reply")
+	}
+
+	messageLength := int(binary.BigEndian.Uint16(body[2:]))
+	dataLength := int(binary.BigEndian.Uint16(body[4:]))
+	if 6+messageLength+dataLength != len(body) {
+		return reply, errors.New("Malformed authentication reply")
+	}
+
+	reply.status = body[0]
+	reply.message = string(body[6 : 6+messageLength])
+	return reply, nil
+}
+
+type authorReply struct {
+	status  byte
+	message string
+	args    []string
+}
+
+func (r authorReply) err() error {
+	if r.message != "" {
+		return fmt.Errorf("Authorization status %d: %s", r.status, r.message)
+	}
+	return fmt.Errorf("Authorization status %d", r.status)
+}
+
+func (s *session) receiveAuthor() (authorReply, error) {
+	var reply authorReply
+
+	body, err := s.receive(typeAuthor)
+	if err != nil {
+		return reply, err
+	}
+
+	if len(body) < 6 {
+		return reply, errors.New("Short authorization reply")
+	}
+
+	count := int(body[1])
+	messageLength := int(binary.BigEndian.Uint16(body[2:]))
+	dataLength := int(binary.BigEndian.Uint16(body[4:]))
+
+	offset := 6 + count
+	if offset > len(body) {
+		return reply, errors.New("Malformed authorization reply")
+	}
+
+	total := offset + messageLength + dataLength
+	lengths := body[6:offset]
+	for _, l := range lengths {
+		total += int(l)
+	}
+	if total != len(body) {
+		return reply, errors.New("Malformed authorization reply")
+	}
+
+	reply.status = body[0]
+	reply.message = string(body[offset : offset+messageLength])
+
+	offset += messageLength + dataLength
+	for _, l := range lengths {
+		reply.args = append(reply.args, string(body[offset:offset+int(l)]))
+		offset += int(l)
+	}
+
+	return reply, nil
+}
+
+func authenStart(authenType byte, username, data string) []byte {
+	body := []byte{
+		authenActionLogin,
+		PRIVILEGE_USER,
+		authenType,
+		authenServiceLogin,
+		byte(len(username)),
+		byte(len(clientPort)),
+		0, // no remote address
+		byte(len(data)),
+	}
+
+	body = append(body, username...)
+	body = append(body, clientPort...)
+	return append(body, data...)
+}
+
+func authenContinue(message string) []byte {
+	body := make([]byte, 5)
+	binary.BigEndian.PutUint16(body[0:], uint16(len(message)))
+	return append(body, message...)
+}
+
+func authorRequest(authenType byte, username string, args []string) []byte {
+	body := []byte{
+		authenMethodTACACS,
+		PRIVILEGE_USER,
+		authenType,
+		authenServiceLogin,
+		byte(len(username)),
+		byte(len(clientPort)),
+		0, // no remote address
+		byte(len(args)),
+	}
+
+	for _, arg := range args {
+		body = append(body, byte(len(arg)))
+	}
+
+	body = append(body, username...)
+	body = append(body, clientPort...)
+	for _, arg := range args {
+		body = append(body, arg...)
+	}
+
+	return body
+}
+
+// crypt obfuscates or restores body in place with the MD5 pad of RFC 8907
+func crypt(body, secret []byte, id uint32, version, seq byte) {
+	prefix := make([]byte, 4, 4+len(secret)+2+md5.Size)
+	binary.BigEndian.PutUint32(prefix, id)
+	prefix = append(prefix, secret...)
+	prefix = append(prefix, version, seq)
+
+	var pad []byte
+	for i := range body {
+		if i%md5.Size == 0 {
+			sum := md5.Sum(append(prefix, pad...))
+			pad = sum[:]
+		}
+		body[i] ^= pad[i%md5.Size]
+	}
+}
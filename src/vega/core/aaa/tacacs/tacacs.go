@@ -0,0 +1,215 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+// Package tacacs provide APIs for getting or setting the TACACS+ authentication
+package tacacs
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/htbig/common/src/vega/core/util"
+	"github.com/htbig/common/src/vega/core/util/cfg"
+)
+
+const (
+	pam_tacplus = "/etc/pam.d/pam_tacplus"
+
+	pam_module            = "pam_tacplus.so"
+	pam_option_required   = "required"
+	pam_option_sufficient = "sufficient"
+
+	authserver_port = 49
+
+	LOGIN_PAP   = "pap"
+	LOGIN_ASCII = "ascii"
+)
+
+type (
+	Config struct {
+		Fallback bool     `json:"fallback"`
+		Enabled  bool     `json:"enable"`
+		Login    string   `json:"login"`
+		Servers  []Server `json:"servers"`
+	}
+
+	Server struct {
+		IPaddr string `json:"ip"`
+		Secret string `json:"secret"`
+		Port   uint16 `json:"port"`
+	}
+)
+
+// Legacy has nothing to read, TACACS+ did not exist in the legacy system
+func (cfg *Config) Legacy(legacyRoot string) {
+	cfg.Factory()
+}
+
+func (config *Config) CopyFrom(otherConfig Config) {
+	config.Enabled = otherConfig.Enabled
+	config.Fallback = otherConfig.Fallback
+	config.Login = otherConfig.Login
+
+	config.Servers = make([]Server, len(otherConfig.Servers))
+	copy(config.Servers, otherConfig.Servers)
+}
+
+func (config *Config) CopyFromInterface(data interface{}) bool {
+	otherConfig, ok := data.(*Config)
+	if !ok {
+		return false
+	}
+
+	config.CopyFrom(*otherConfig)
+	return true
+}
+
+func (config *Config) CloneInterface() interface{} {
+	return config.Clone()
+}
+
+func (config *Config) Clone() *Config {
+	newConfig := new(Config)
+	newConfig.CopyFrom(*config)
+
+	return newConfig
+}
+
+func (config *Config) SaveInterface(data interface{}) (bool, []error) {
+	oldConfig, ok := data.(*Config)
+	if !ok {
+		return false, nil
+	}
+
+	return true, config.Save(*oldConfig)
+}
+
+// Save writes the PAM config used by shell logins, the API talks to the
+// servers directly
+func (cfg *Config) Save(oldConfig Config) (errors []error) {
+
+	for idx, server := range cfg.Servers {
+		if server.Port == 0 {
+			cfg.Servers[idx].Port = authserver_port
+		}
+	}
+
+	if cfg.Login == "" {
+		cfg.Login = LOGIN_PAP
+	}
+
+	var err error
+	if cfg.Enabled {
+		err = Enable(cfg.Fallback, cfg.Login, cfg.Servers)
+	} else {
+		err = Disable()
+	}
+
+	if err != nil {
+		errors = append(errors, err)
+	}
+
+	return
+}
+
+func (cfg *Config) Verify() (errs []error) {
+
+	if len(cfg.Servers) == 0 && cfg.Enabled {
+		err := fmt.Errorf("Enable TACACS+ service requires at least 1 server")
+		errs = append(errs, err)
+		return
+	}
+
+	switch cfg.Login {
+	case "", LOGIN_PAP, LOGIN_ASCII:
+	default:
+		errs = append(errs, fmt.Errorf("Bad TACACS+ login type: %s", cfg.Login))
+	}
+
+	for idx, server := range cfg.Servers {
+		if !util.IsIPaddress(server.IPaddr) {
+			err := fmt.Errorf("Bad TACACS+ server IP: %s", server.IPaddr)
+			errs = append(errs, err)
+		}
+
+		for i := idx + 1; i < len(cfg.Servers); i++ {
+			if server.IPaddr == cfg.Servers[i].IPaddr && server.Port == cfg.Servers[i].Port {
+				err := fmt.Errorf("Duplicate TACACS+ server: %s", server.IPaddr)
+				errs = append(errs, err)
+			}
+		}
+
+		if server.Secret == "" {
+			err := fmt.Errorf("Can not have empty server secret")
+			errs = append(errs, err)
+		}
+	}
+
+	return
+}
+
+func (cfg *Config) Factory() {
+	cfg.Servers = []Server{}
+	cfg.Enabled = false
+	cfg.Fallback = false
+	cfg.Login = LOGIN_PAP
+
+	return
+}
+
+func (server Server) address() string {
+	port := server.Port
+	if port == 0 {
+		port = authserver_port
+	}
+
+	return net.JoinHostPort(server.IPaddr, strconv.Itoa(int(port)))
+}
+
+func Enable(fallback bool, login string, servers []Server) (err error) {
+
+	pam_file, err := cfg.LoadConfig(pam_tacplus)
+	if err != nil {
+		return
+	}
+	defer pam_file.Close()
+
+	err = pam_file.DeleteByKey("auth")
+	if err != nil {
+		return
+	}
+
+	pam_option := pam_option_required
+	if fallback {
+		pam_option = pam_option_sufficient
+	}
+
+	values := []string{pam_option, pam_module}
+	for _, server := range servers {
+		values = append(values, "server="+server.address(), "secret="+server.Secret)
+	}
+	values = append(values, "login="+login)
+
+	err = pam_file.AddStrings("auth", values...)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func Disable() (err error) {
+
+	pam_file, err := cfg.LoadConfig(pam_tacplus)
+	if err != nil {
+		return
+	}
+	defer pam_file.Close()
+
+	err = pam_file.DeleteByKey("auth")
+	if err != nil {
+		return
+	}
+
+	return
+}
@@ -0,0 +1,237 @@
+package tacacs
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+)
+
+const test_secret = "testing123"
+
+type test_user struct {
+	password string
+	level    int
+}
+
+var test_users = map[string]test_user{
+	"admin":    {"adminpass", PRIVILEGE_ADMIN},
+	"operator": {"oppass", 7},
+	"nobody":   {"nobodypass", -1}, // authenticates, not authorized
+}
+
+// standIn is a minimal TACACS+ server answering PAP and ASCII logins and
+// shell authorization from test_users
+type standIn struct {
+	listener net.Listener
+	secret   []byte
+}
+
+func newStandIn(t *testing.T, secret string) *standIn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("[err] listen:", err)
+	}
+
+	s := &standIn{listener: listener, secret: []byte(secret)}
+	go s.serve()
+	return s
+}
+
+func (s *standIn) server() Server {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return Server{IPaddr: host, Secret: test_secret, Port: uint16(p)}
+}
+
+func (s *standIn) close() {
+	s.listener.Close()
+}
+
+func (s *standIn) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *standIn) read(conn net.Conn) (header, body []byte, err error) {
+	header = make([]byte, headerLength)
+	if _, err = io.ReadFull(conn, header); err != nil {
+		return
+	}
+
+	body = make([]byte, binary.BigEndian.Uint32(header[8:]))
+	if _, err = io.ReadFull(conn, body); err != nil {
+		return
+	}
+
+	crypt(body, s.secret, binary.BigEndian.Uint32(header[4:]), header[0], header[2])
+	return
+}
+
+func (s *standIn) write(conn net.Conn, request []byte, body []byte) {
+	header := make([]byte, headerLength)
+	copy(header, request[:8])
+	header[2]++
+	binary.BigEndian.PutUint32(header[8:], uint32(len(body)))
+
+	crypt(body, s.secret, binary.BigEndian.Uint32(header[4:]), header[0], header[2])
+	conn.Write(append(header, body...))
+}
+
+func authenReplyBody(status byte, message string) []byte {
+	body := []byte{status, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(body[2:], uint16(len(message)))
+	return append(body, message...)
+}
+
+func (s *standIn) handle(conn net.Conn) {
+	defer conn.Close()
+	// a packet obfuscated with another secret decodes to garbage, drop it
+	defer func() { recover() }()
+
+	header, body, err := s.read(conn)
+	if err != nil {
+		return
+	}
+
+	// user, port and remote address follow the fixed fields of both a
+	// START and a REQUEST
+	fields := 8
+	if header[1] == typeAuthor {
+		fields += int(body[7])
+	}
+	username := string(body[fields : fields+int(body[4])])
+
+	if header[1] == typeAuthor {
+		user, ok := test_users[username]
+		if !ok || user.level < 0 {
+			s.write(conn, header, []byte{authorStatusFail, 0, 0, 0, 0, 0})
+			return
+		}
+
+		arg := "priv-lvl=" + strconv.Itoa(user.level)
+		reply := []byte{authorStatusPassAdd, 1, 0, 0, 0, 0, byte(len(arg))}
+		s.write(conn, header, append(reply, arg...))
+		return
+	}
+
+	var password string
+	if body[2] == authenTypePAP {
+		offset := fields + int(body[4]) + int(body[5]) + int(body[6])
+		password = string(body[offset : offset+int(body[7])])
+	} else {
+		s.write(conn, header, authenReplyBody(authenStatusGetPass, "Password: "))
+		if header, body, err = s.read(conn); err != nil {
+			return
+		}
+		password = string(body[5 : 5+binary.BigEndian.Uint16(body[0:])])
+	}
+
+	if user, ok := test_users[username]; ok && user.password == password {
+		s.write(conn, header, authenReplyBody(authenStatusPass, ""))
+	} else {
+		s.write(conn, header, authenReplyBody(authenStatusFail, "Login incorrect"))
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	server := newStandIn(t, test_secret)
+	defer server.close()
+
+	for _, login := range []string{LOGIN_PAP, LOGIN_ASCII} {
+		t.Log("[case] Test", login, "login")
+
+		cfg := Config{Enabled: true, Login: login, Servers: []Server{server.server()}}
+
+		privilege, ok, errs := cfg.Authenticate("admin", "adminpass")
+		if len(errs) > 0 || !ok || privilege != PRIVILEGE_ADMIN {
+			t.Error("[err] admin:", privilege, ok, errs)
+		}
+
+		privilege, ok, errs = cfg.Authenticate("operator", "oppass")
+		if len(errs) > 0 || !ok || privilege != 7 {
+			t.Error("[err] operator:", privilege, ok, errs)
+		}
+
+		if _, ok, errs = cfg.Authenticate("admin", "wrong"); len(errs) > 0 || ok {
+			t.Error("[err] wrong password:", ok, errs)
+		}
+
+		if _, ok, errs = cfg.Authenticate("nobody", "nobodypass"); len(errs) > 0 || ok {
+			t.Error("[err] unauthorized user:", ok, errs)
+		}
+	}
+}
+
+func TestFailover(t *testing.T) {
+	t.Log("[case] Test failover to the next server")
+
+	server := newStandIn(t, test_secret)
+	defer server.close()
+
+	// grab a port nobody listens on
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("[err] listen:", err)
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+	p, _ := strconv.Atoi(port)
+
+	cfg := Config{Enabled: true, Login: LOGIN_PAP, Servers: []Server{
+		{IPaddr: "127.0.0.1", Secret: test_secret, Port: uint16(p)},
+		server.server(),
+	}}
+
+	if _, ok, errs := cfg.Authenticate("admin", "adminpass"); len(errs) > 0 || !ok {
+		t.Error("[err] failover:", ok, errs)
+	}
+
+	t.Log("[case] Test wrong secret")
+
+	cfg.Servers = []Server{server.server()}
+	cfg.Servers[0].Secret = "wrong"
+	if _, ok, errs := cfg.Authenticate("admin", "adminpass"); len(errs) == 0 || ok {
+		t.Error("[err] expected errors, got:", ok)
+	} else if errs[0].Error() != TacacsAuthError {
+		t.Error("[err] unexpected error:", errs)
+	}
+
+	t.Log("[case] Test no server")
+
+	cfg.Servers = []Server{}
+	if _, _, errs := cfg.Authenticate("admin", "adminpass"); len(errs) != 1 || errs[0].Error() != TacacsNoServerError {
+		t.Error("[err] unexpected errors:", errs)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	t.Log("[case] Test verify TACACS+ config")
+
+	var cfg Config
+	cfg.Factory()
+	if errs := cfg.Verify(); len(errs) > 0 {
+		t.Error("[err] factory config:", errs)
+	}
+
+	cfg.Enabled = true
+	if errs := cfg.Verify(); len(errs) != 1 {
+		t.Error("[err] expected 1 error, got:", errs)
+	}
+
+	cfg.Login = "chap"
+	cfg.Servers = []Server{
+		{IPaddr: "10.0.0.1", Secret: test_secret},
+		{IPaddr: "10.0.0.1", Secret: test_secret},
+		{IPaddr: "tacacs", Secret: ""},
+	}
+	if errs := cfg.Verify(); len(errs) != 4 {
+		t.Error("[err] expected 4 errors, got:", errs)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+// Package trusted provide the networks whose API requests skip authentication
+package trusted
+
+import (
+	"fmt"
+	"net"
+)
+
+const (
+	// requests are treated as coming from an administrator
+	PRIVILEGED = "privileged"
+	// requests skip authentication on unprivileged routes only
+	UNPRIVILEGED = "unprivileged"
+	// requests are always authenticated
+	NONE = "none"
+)
+
+type (
+	Config struct {
+		Networks []Network `json:"networks"`
+	}
+
+	Network struct {
+		CIDR      string `json:"cidr"`
+		Privilege string `json:"privilege"`
+	}
+)
+
+// Legacy sets the networks that used to be hard-coded in the API
+func (config *Config) Legacy(legacyRoot string) {
+	config.Factory()
+}
+
+func (config *Config) CopyFrom(otherConfig Config) {
+	config.Networks = make([]Network, len(otherConfig.Networks))
+	copy(config.Networks, otherConfig.Networks)
+}
+
+func (config *Config) CopyFromInterface(data interface{}) bool {
+	otherConfig, ok := data.(*Config)
+	if !ok {
+		return false
+	}
+
+	config.CopyFrom(*otherConfig)
+	return true
+}
+
+func (config *Config) CloneInterface() interface{} {
+	return config.Clone()
+}
+
+func (config *Config) Clone() *Config {
+	newConfig := new(Config)
+	newConfig.CopyFrom(*config)
+
+	return newConfig
+}
+
+func (config *Config) SaveInterface(data interface{}) (bool, []error) {
+	oldConfig, ok := data.(*Config)
+	if !ok {
+		return false, nil
+	}
+
+	return true, config.Save(*oldConfig)
+}
+
+// Save has nothing to write to the system, the API reads the running config
+func (config *Config) Save(oldConfig Config) []error {
+	return nil
+}
+
+func (config *Config) Verify() (errs []error) {
+	seen := make(map[string]bool)
+
+	for _, network := range config.Networks {
+		_, ipNet, err := net.ParseCIDR(network.CIDR)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Bad trusted network: %s", network.CIDR))
+			continue
+		}
+
+		switch network.Privilege {
+		case PRIVILEGED, UNPRIVILEGED, NONE:
+		default:
+			errs = append(errs, fmt.Errorf("Bad privilege %q of trusted network: %s", network.Privilege, network.CIDR))
+		}
+
+		if seen[ipNet.String()] {
+			errs = append(errs, fmt.Errorf("Duplicate trusted network: %s", network.CIDR))
+		}
+		seen[ipNet.String()] = true
+	}
+
+	return
+}
+
+func (config *Config) Factory() {
+	config.Networks = []Network{
+		{CIDR: "127.0.0.1/32", Privilege: PRIVILEGED},
+		{CIDR: "::1/128", Privilege: PRIVILEGED},
+		{CIDR: "172.17.0.0/24", Privilege: UNPRIVILEGED},
+	}
+}
+
+// Lookup returns the privilege of the most specific network containing ip,
+// or NONE if no network does
+func (config *Config) Lookup(ip net.IP) string {
+	privilege := NONE
+	longest := -1
+
+	if ip == nil {
+		return privilege
+	}
+
+	for _, network := range config.Networks {
+		_, ipNet, err := net.ParseCIDR(network.CIDR)
+		if err != nil || !ipNet.Contains(ip) {
+			continue
+		}
+
+		if ones, _ := ipNet.Mask.Size(); ones > longest {
+			longest = ones
+			privilege = network.Privilege
+		}
+	}
+
+	return privilege
+}
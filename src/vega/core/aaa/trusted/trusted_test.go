@@ -0,0 +1,54 @@
+package trusted
+
+import (
+	"net"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	t.Log("[case] Test verify trusted networks")
+
+	var cfg Config
+	cfg.Factory()
+	if errs := cfg.Verify(); len(errs) > 0 {
+		t.Error("[err] factory config:", errs)
+	}
+
+	cfg.Networks = []Network{
+		{CIDR: "10.0.0.0/8", Privilege: PRIVILEGED},
+		{CIDR: "10.1.2.3/8", Privilege: NONE},
+		{CIDR: "192.168.1.0", Privilege: NONE},
+		{CIDR: "192.168.2.0/24", Privilege: "admin"},
+	}
+	if errs := cfg.Verify(); len(errs) != 3 {
+		t.Error("[err] expected 3 errors, got:", errs)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	t.Log("[case] Test lookup privilege of an address")
+
+	cfg := Config{Networks: []Network{
+		{CIDR: "127.0.0.1/32", Privilege: PRIVILEGED},
+		{CIDR: "172.18.0.0/16", Privilege: UNPRIVILEGED},
+		{CIDR: "172.18.5.0/24", Privilege: NONE},
+	}}
+
+	cases := map[string]string{
+		"127.0.0.1":  PRIVILEGED,
+		"::1":        NONE,
+		"172.18.1.1": UNPRIVILEGED,
+		"172.18.5.1": NONE,
+		"172.17.0.2": NONE,
+	}
+
+	for address, want := range cases {
+		if got := cfg.Lookup(net.ParseIP(address)); got != want {
+			t.Error("[err]", address, "got:", got, "want:", want)
+		}
+	}
+
+	if got := cfg.Lookup(nil); got != NONE {
+		t.Error("[err] nil address got:", got)
+	}
+}
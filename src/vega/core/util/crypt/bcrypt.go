@@ -0,0 +1,197 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+package crypt
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// bcrypt, the OpenBSD Blowfish password hash
+const (
+	bcryptCost      = 10
+	bcryptMinCost   = 4
+	bcryptMaxCost   = 31
+	bcryptSaltBytes = 16
+	// bytes of the password used, with its terminating NUL
+	bcryptMaxKey = 72
+
+	bcryptAlphabet = "./ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+var bcryptMagic = []byte("OrpheanBeholderScryDoubt")
+
+type blowfish struct {
+	p [18]uint32
+	s [4][256]uint32
+}
+
+func (c *blowfish) f(x uint32) uint32 {
+	return ((c.s[0][x>>24] + c.s[1][x>>16&0xff]) ^ c.s[2][x>>8&0xff]) + c.s[3][x&0xff]
+}
+
+func (c *blowfish) encrypt(l, r uint32) (uint32, uint32) {
+	for i := 0; i < 16; i += 2 {
+		l ^= c.p[i]
+		r ^= c.f(l)
+		r ^= c.p[i+1]
+		l ^= c.f(r)
+	}
+	l ^= c.p[16]
+	r ^= c.p[17]
+	return r, l
+}
+
+// stream returns the next big endian word of data, cycling over it
+func stream(data []byte, pos *int) uint32 {
+	var word uint32
+	for i := 0; i < 4; i++ {
+		word = word<<8 | uint32(data[*pos])
+		*pos = (*pos + 1) % len(data)
+	}
+	return word
+}
+
+// expand is ExpandKey of eksblowfish, salt is nil for the plain key schedule
+func (c *blowfish) expand(key, salt []byte) {
+	pos := 0
+	for i := range c.p {
+		c.p[i] ^= stream(key, &pos)
+	}
+
+	var l, r uint32
+	pos = 0
+	next := func() {
+		if salt != nil {
+			l ^= stream(salt, &pos)
+			r ^= stream(salt, &pos)
+		}
+		l, r = c.encrypt(l, r)
+	}
+
+	for i := 0; i < len(c.p); i += 2 {
+		next()
+		c.p[i], c.p[i+1] = l, r
+	}
+
+	for i := range c.s {
+		for j := 0; j < 256; j += 2 {
+			next()
+			c.s[i][j], c.s[i][j+1] = l, r
+		}
+	}
+}
+
+func isBcrypt(hash string) bool {
+	return len(hash) > 4 && hash[0] == '$' && hash[1] == '2' && hash[3] == '$' &&
+		(hash[2] == 'a' || hash[2] == 'b' || hash[2] == 'y')
+}
+
+// parseBcrypt returns the version, cost and salt of "$2b$10$<salt><hash>"
+func parseBcrypt(hash string) (version string, cost int, salt []byte, err error) {
+	if !isBcrypt(hash) || len(hash) != 60 || hash[6] != '$' {
+		return "", 0, nil, errFormat
+	}
+
+	cost, err = strconv.Atoi(hash[4:6])
+	if err != nil || cost < bcryptMinCost || cost > bcryptMaxCost {
+		return "", 0, nil, errFormat
+	}
+
+	if !validChars(hash[7:], bcryptAlphabet) {
+		return "", 0, nil, errFormat
+	}
+
+	salt = decodeBcrypt(hash[7:29], bcryptSaltBytes)
+	return hash[1:3], cost, salt, nil
+}
+
+func bcryptHash(password string, salt []byte, cost int, version string) (string, error) {
+	if cost < bcryptMinCost || cost > bcryptMaxCost {
+		return "", fmt.Errorf("Bad bcrypt cost: %d", cost)
+	}
+	if len(salt) != bcryptSaltBytes {
+		return "", errors.New("Bad bcrypt salt")
+	}
+
+	key := append([]byte(password), 0)
+	if len(key) > bcryptMaxKey {
+		key = key[:bcryptMaxKey]
+	}
+
+	c := &blowfish{p: blowfishP, s: blowfishS}
+	c.expand(key, salt)
+	for i := uint64(0); i < 1<<uint(cost); i++ {
+		c.expand(key, nil)
+		c.expand(salt, nil)
+	}
+
+	text := make([]uint32, len(bcryptMagic)/4)
+	for i := range text {
+		text[i] = binary.BigEndian.Uint32(bcryptMagic[4*i:])
+	}
+	for i := 0; i < 64; i++ {
+		for j := 0; j < len(text); j += 2 {
+			text[j], text[j+1] = c.encrypt(text[j], text[j+1])
+		}
+	}
+
+	sum := make([]byte, len(bcryptMagic))
+	for i, word := range text {
+		binary.BigEndian.PutUint32(sum[4*i:], word)
+	}
+
+	// the last byte is dropped
+	return fmt.Sprintf("$%s$%02d$%s%s", version, cost, encodeBcrypt(salt), encodeBcrypt(sum[:23])), nil
+}
+
+// encodeBcrypt is base64 without padding on the bcrypt alphabet
+func encodeBcrypt(src []byte) string {
+	var dst []byte
+
+	for i := 0; i < len(src); i += 3 {
+		var value uint32
+		n := 0
+		for j := i; j < i+3; j++ {
+			value <<= 8
+			if j < len(src) {
+				value |= uint32(src[j])
+				n++
+			}
+		}
+
+		for k := 0; k <= n; k++ {
+			dst = append(dst, bcryptAlphabet[value>>uint(18-6*k)&0x3f])
+		}
+	}
+
+	return string(dst)
+}
+
+func decodeBcrypt(src string, n int) []byte {
+	dst := make([]byte, 0, n+2)
+
+	for i := 0; i < len(src); i += 4 {
+		var value uint32
+		for j := i; j < i+4; j++ {
+			value <<= 6
+			if j < len(src) {
+				value |= uint32(indexOf(bcryptAlphabet, src[j]))
+			}
+		}
+		dst = append(dst, byte(value>>16), byte(value>>8), byte(value))
+	}
+
+	return dst[:n]
+}
+
+func indexOf(alphabet string, c byte) int {
+	for i := 0; i < len(alphabet); i++ {
+		if alphabet[i] == c {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+// Package crypt provides the crypt(3) password hashes of /etc/shadow:
+// SHA-512 crypt, bcrypt and yescrypt
+package crypt
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"strings"
+)
+
+// Hash methods
+const (
+	SHA512   = "sha512"
+	BCRYPT   = "bcrypt"
+	YESCRYPT = "yescrypt"
+)
+
+const (
+	prefixSHA512   = "$6$"
+	prefixBcrypt   = "$2b$"
+	prefixYescrypt = "$y$"
+)
+
+// alphabet of the crypt(3) base64 encoding
+const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+var errFormat = errors.New("Bad crypt hash")
+
+// Hash hashes password with method and a random salt
+func Hash(method, password string) (string, error) {
+	switch method {
+	case SHA512:
+		salt, err := random(sha512SaltLength * 6 / 8)
+		if err != nil {
+			return "", err
+		}
+		return sha512Crypt(password, encode64(salt)[:sha512SaltLength], sha512Rounds, false), nil
+	case BCRYPT:
+		salt, err := random(bcryptSaltBytes)
+		if err != nil {
+			return "", err
+		}
+		return bcryptHash(password, salt, bcryptCost, "2b")
+	case YESCRYPT:
+		salt, err := random(yescryptSaltBytes)
+		if err != nil {
+			return "", err
+		}
+		return yescryptHash(password, yescryptDefaultSetting+encode64(salt))
+	}
+
+	return "", errors.New("Bad password hash method: " + method)
+}
+
+// Verify tells whether password matches hash
+func Verify(hash, password string) bool {
+	computed, err := rehash(hash, password)
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) == 1
+}
+
+// Valid tells whether hash is a well-formed hash of a known method
+func Valid(hash string) bool {
+	switch {
+	case strings.HasPrefix(hash, prefixSHA512):
+		_, _, _, err := parseSHA512(hash)
+		return err == nil
+	case isBcrypt(hash):
+		_, _, _, err := parseBcrypt(hash)
+		return err == nil
+	case strings.HasPrefix(hash, prefixYescrypt):
+		_, _, err := parseYescrypt(hash)
+		return err == nil
+	}
+
+	return false
+}
+
+// Method returns the method of hash, "" for unknown
+func Method(hash string) string {
+	switch {
+	case strings.HasPrefix(hash, prefixSHA512):
+		return SHA512
+	case isBcrypt(hash):
+		return BCRYPT
+	case strings.HasPrefix(hash, prefixYescrypt):
+		return YESCRYPT
+	}
+
+	return ""
+}
+
+// rehash hashes password with the method and setting of hash
+func rehash(hash, password string) (string, error) {
+	switch {
+	case strings.HasPrefix(hash, prefixSHA512):
+		salt, rounds, custom, err := parseSHA512(hash)
+		if err != nil {
+			return "", err
+		}
+		return sha512Crypt(password, salt, rounds, custom), nil
+	case isBcrypt(hash):
+		version, cost, salt, err := parseBcrypt(hash)
+		if err != nil {
+			return "", err
+		}
+		return bcryptHash(password, salt, cost, version)
+	case strings.HasPrefix(hash, prefixYescrypt):
+		return yescryptHash(password, hash)
+	}
+
+	return "", errFormat
+}
+
+func random(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func validChars(s, alphabet string) bool {
+	for _, c := range s {
+		if !strings.ContainsRune(alphabet, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// encode64 encodes src little endian in groups of three bytes, the crypt(3)
+// base64 of SHA-512 crypt and yescrypt
+func encode64(src []byte) string {
+	var dst []byte
+
+	for i := 0; i < len(src); i += 3 {
+		var value uint32
+		bits := 0
+		for j := i; j < i+3 && j < len(src); j++ {
+			value |= uint32(src[j]) << uint(bits)
+			bits += 8
+		}
+
+		for ; bits > 0; bits -= 6 {
+			dst = append(dst, itoa64[value&0x3f])
+			value >>= 6
+		}
+	}
+
+	return string(dst)
+}
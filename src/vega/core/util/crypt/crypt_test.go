@@ -0,0 +1,87 @@
+package crypt
+
+import (
+	"strings"
+	"testing"
+)
+
+// hashes made by crypt(3) of libxcrypt
+var vectors = []struct {
+	password string
+	hash     string
+}{
+	{"password", "$6$saltsalt$qFmFH.bQmmtXzyBY0s9v7Oicd2z4XSIecDzlB5KiA2/jctKu9YterLp8wwnSq.qc.eoxqOmSuNp2xS0ktL3nh/"},
+	{"", "$6$saltsalt$qkTgsCrWMTAS9gBGcf9W60sFfH.hU0oTCAOJjhbz5tSp/sU3/xXZK4OFwCtq8lIIdpJ6CatVdOTSHKp97TPkt/"},
+	{"a much longer password with more than sixty four characters in it, really!", "$6$rounds=1000$0123456789abcdef$pb7tD0d8hYSKezBZyYji8.nIfMVDIL3AHIwW1dbmDt/QALdAhh1e0MrKEfyW.lmfahQ60dow9ezMEcqAnID./1"},
+	{"U*U", "$2b$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW"},
+	{"", "$2b$05$CCCCCCCCCCCCCCCCCCCCC.7uG0VCzI2bS7j6ymqJi9CdcdxiRTWNy"},
+	{"0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789chars after 72 are ignored", "$2a$05$abcdefghijklmnopqrstuu5s2v8.iXieOjg/.AySBTTZIIVFJeBui"},
+	{"password", "$2y$04$abcdefghijklmnopqrstuughE8Ev8uGFaUgY2cNEySvxngrb/Jzdm"},
+	{"password", "$y$j9T$abcdefghijklmnop$7asOTx5b6Exfl3myM6K0pLBn.I2hsEvu7G0F7NMfaO."},
+	{"", "$y$j9T$abcdefghijklmnop$pLCmL.WFP4llpuknjZB0lCp8KKEVl43CyXQupwneDY0"},
+	{"secret", "$y$j75$abcdefgh$uMUXG79B2cFpoAYyDccUnsaH/aGTyBYMlnQidzyag01"},
+	{"secret", "$y$jC5//$abcdefgh$Rybu3R4T4gZbhwZnVaWbceF53s7QXCnfPy8/fpbuUd7"},
+	{"secret", "$y$j9T$F5Jx5fExrKuPp53xLKQ..1$GmcwIgvdUC9qLWcKCi6gklUa1dM3ziD43YxYNURLKy0"},
+}
+
+func TestVectors(t *testing.T) {
+	t.Log("[case] Test hashes of libxcrypt")
+	for _, v := range vectors {
+		if !Valid(v.hash) {
+			t.Error("[err] not valid:", v.hash)
+		}
+		if computed, err := rehash(v.hash, v.password); err != nil || computed != v.hash {
+			t.Errorf("[err] %q: got %s, expected %s (%v)", v.password, computed, v.hash, err)
+		}
+		if Verify(v.hash, "x"+v.password) {
+			t.Error("[err] wrong password verified:", v.hash)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	for _, method := range []string{SHA512, BCRYPT, YESCRYPT} {
+		t.Log("[case] Test hash with", method)
+		hash, err := Hash(method, "Passw0rd")
+		if err != nil {
+			t.Error("[err] hash:", err)
+			continue
+		}
+		if !Valid(hash) || Method(hash) != method {
+			t.Error("[err] bad hash:", hash)
+		}
+		if !Verify(hash, "Passw0rd") || Verify(hash, "passw0rd") {
+			t.Error("[err] verify:", hash)
+		}
+		if other, _ := Hash(method, "Passw0rd"); other == hash {
+			t.Error("[err] same salt twice:", hash)
+		}
+	}
+
+	if _, err := Hash("md5", "Passw0rd"); err == nil {
+		t.Error("[err] unknown method accepted")
+	}
+}
+
+func TestValid(t *testing.T) {
+	t.Log("[case] Test plaintext and malformed hashes")
+	for _, hash := range []string{
+		"",
+		"Passw0rd",
+		"$6$",
+		"$6$salt$short",
+		"$6$rounds=10$salt$" + strings.Repeat("a", 86),
+		"$6$salt$" + strings.Repeat("!", 86),
+		"$1$salt$hash",
+		"$2b$03$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW",
+		"$2b$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOe",
+		"$2x$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW",
+		"$y$j9T$abcdefghijklmnop$short",
+		"$y$/9T$abcdefghijklmnop$7asOTx5b6Exfl3myM6K0pLBn.I2hsEvu7G0F7NMfaO.",
+		"$y$jzT$abcdefghijklmnop$7asOTx5b6Exfl3myM6K0pLBn.I2hsEvu7G0F7NMfaO.",
+	} {
+		if Valid(hash) {
+			t.Error("[err] valid:", hash)
+		}
+	}
+}
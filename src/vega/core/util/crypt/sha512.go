@@ -0,0 +1,145 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+package crypt
+
+import (
+	"crypto/sha512"
+	"strconv"
+	"strings"
+)
+
+// SHA-512 crypt as specified by Ulrich Drepper
+const (
+	sha512SaltLength = 16
+	sha512Rounds     = 5000
+	sha512MinRounds  = 1000
+	sha512MaxRounds  = 999999999
+
+	roundsPrefix = "rounds="
+)
+
+// byte order of the encoded digest, in groups of three
+var sha512Order = [][3]int{
+	{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+	{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51},
+	{31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35},
+	{15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19},
+	{62, 20, 41},
+}
+
+// parseSHA512 returns the salt and rounds of "$6$[rounds=N$]salt$hash",
+// custom tells whether the rounds were given
+func parseSHA512(hash string) (salt string, rounds int, custom bool, err error) {
+	fields := strings.Split(strings.TrimPrefix(hash, prefixSHA512), "$")
+
+	rounds = sha512Rounds
+	if strings.HasPrefix(fields[0], roundsPrefix) {
+		rounds, err = strconv.Atoi(strings.TrimPrefix(fields[0], roundsPrefix))
+		if err != nil || rounds < sha512MinRounds || rounds > sha512MaxRounds {
+			return "", 0, false, errFormat
+		}
+		custom = true
+		fields = fields[1:]
+	}
+
+	if len(fields) != 2 || len(fields[0]) > sha512SaltLength || len(fields[1]) != 86 {
+		return "", 0, false, errFormat
+	}
+
+	if !validChars(fields[0], itoa64) || !validChars(fields[1], itoa64) {
+		return "", 0, false, errFormat
+	}
+
+	return fields[0], rounds, custom, nil
+}
+
+// repeat returns length bytes of digest repeated
+func repeat(digest []byte, length int) []byte {
+	buf := make([]byte, 0, length)
+	for len(buf) < length {
+		n := length - len(buf)
+		if n > len(digest) {
+			n = len(digest)
+		}
+		buf = append(buf, digest[:n]...)
+	}
+	return buf
+}
+
+func sha512Crypt(password, salt string, rounds int, custom bool) string {
+	p := []byte(password)
+	s := []byte(salt)
+	if len(s) > sha512SaltLength {
+		s = s[:sha512SaltLength]
+	}
+
+	// digest B
+	h := sha512.New()
+	h.Write(p)
+	h.Write(s)
+	h.Write(p)
+	b := h.Sum(nil)
+
+	// digest A
+	h.Reset()
+	h.Write(p)
+	h.Write(s)
+	h.Write(repeat(b, len(p)))
+	for n := len(p); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			h.Write(b)
+		} else {
+			h.Write(p)
+		}
+	}
+	a := h.Sum(nil)
+
+	// sequence P
+	h.Reset()
+	for i := 0; i < len(p); i++ {
+		h.Write(p)
+	}
+	pseq := repeat(h.Sum(nil), len(p))
+
+	// sequence S
+	h.Reset()
+	for i := 0; i < 16+int(a[0]); i++ {
+		h.Write(s)
+	}
+	sseq := repeat(h.Sum(nil), len(s))
+
+	c := a
+	for i := 0; i < rounds; i++ {
+		h.Reset()
+		if i&1 != 0 {
+			h.Write(pseq)
+		} else {
+			h.Write(c)
+		}
+		if i%3 != 0 {
+			h.Write(sseq)
+		}
+		if i%7 != 0 {
+			h.Write(pseq)
+		}
+		if i&1 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(pseq)
+		}
+		c = h.Sum(nil)
+	}
+
+	ordered := make([]byte, 0, sha512.Size)
+	for _, group := range sha512Order {
+		ordered = append(ordered, c[group[2]], c[group[1]], c[group[0]])
+	}
+	ordered = append(ordered, c[63])
+
+	setting := prefixSHA512
+	if custom {
+		setting += roundsPrefix + strconv.Itoa(rounds) + "$"
+	}
+
+	return setting + string(s) + "$" + encode64(ordered)
+}
@@ -0,0 +1,532 @@
+// Copyright (c) 2016, Virtual Gateway Labs. All rights reserved.
+
+package crypt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"strings"
+)
+
+// yescrypt as in libxcrypt, the reference implementation restricted to the
+// default pwxform settings: 12 KiB S-boxes, 6 rounds, gather 4, simple 2
+const (
+	yescryptRW       = 0x002
+	yescryptDefaults = 0x0b6
+	yescryptPrehash  = 0x10000000
+
+	// $y$, default flavor, N = 4096, r = 32
+	yescryptDefaultSetting = "$y$j9T$"
+	yescryptSaltBytes      = 16
+	yescryptMaxSaltBytes   = 64
+	yescryptHashLength     = 43
+	// memory a hash may ask for
+	yescryptMaxMemory = 1 << 30
+
+	pwxSimple = 2
+	pwxGather = 4
+	pwxRounds = 6
+	sWidth    = 8
+	pwxWords  = pwxGather * pwxSimple * 2
+	sWords    = 3 * (1 << sWidth) * pwxSimple * 2
+	sMask     = ((1 << sWidth) - 1) * pwxSimple * 8
+)
+
+type yescryptParams struct {
+	flags uint32
+	n     uint64
+	r     uint32
+	p     uint32
+	t     uint32
+}
+
+// pwxform holds the S-boxes of one thread, S0 and S1 are read while S2 is
+// written, then they rotate
+type pwxform struct {
+	s0, s1, s2 []uint32
+	w          int
+}
+
+// atoi64 returns the value of a crypt(3) base64 character, 64 for none
+func atoi64(c byte) uint32 {
+	if i := strings.IndexByte(itoa64, c); i >= 0 {
+		return uint32(i)
+	}
+	return 64
+}
+
+// decode64Uint32 decodes the variable length integers of the parameters
+func decode64Uint32(src string, min uint32) (uint32, string, bool) {
+	if src == "" {
+		return 0, src, false
+	}
+
+	c := atoi64(src[0])
+	if c > 63 {
+		return 0, src, false
+	}
+	src = src[1:]
+
+	start, end, chars, bits := uint32(0), uint32(47), 1, uint(0)
+	value := min
+	for c > end {
+		value += (end + 1 - start) << bits
+		start = end + 1
+		end = start + (62-end)/2
+		chars++
+		bits += 6
+	}
+	value += (c - start) << bits
+
+	for ; chars > 1; chars-- {
+		if src == "" {
+			return 0, src, false
+		}
+		c = atoi64(src[0])
+		if c > 63 {
+			return 0, src, false
+		}
+		src = src[1:]
+		bits -= 6
+		value += c << bits
+	}
+
+	return value, src, true
+}
+
+// decode64 is the inverse of encode64, it refuses encodings with bits left
+func decode64(src string) ([]byte, bool) {
+	var dst []byte
+
+	for len(src) > 0 {
+		var value uint32
+		bits := uint(0)
+		for len(src) > 0 && bits < 24 {
+			c := atoi64(src[0])
+			if c > 63 {
+				return nil, false
+			}
+			src = src[1:]
+			value |= c << bits
+			bits += 6
+		}
+
+		if bits < 12 {
+			return nil, false
+		}
+
+		for ; bits >= 8; bits -= 8 {
+			dst = append(dst, byte(value))
+			value >>= 8
+		}
+		if value != 0 {
+			return nil, false
+		}
+	}
+
+	return dst, true
+}
+
+// parseYescrypt returns the parameters and salt of
+// "$y$<params>$<salt>[$<hash>]" and the setting up to the salt
+func parseYescrypt(hash string) (params yescryptParams, setting string, err error) {
+	src := strings.TrimPrefix(hash, prefixYescrypt)
+	params.p = 1
+
+	flavor, src, ok := decode64Uint32(src, 0)
+	if !ok || flavor != yescryptRW+(yescryptDefaults-yescryptRW)>>2 {
+		return params, "", errFormat
+	}
+	params.flags = yescryptDefaults
+
+	nLog2, src, ok := decode64Uint32(src, 1)
+	if !ok || nLog2 > 63 {
+		return params, "", errFormat
+	}
+	params.n = 1 << nLog2
+
+	if params.r, src, ok = decode64Uint32(src, 1); !ok {
+		return params, "", errFormat
+	}
+
+	if !strings.HasPrefix(src, "$") {
+		var have uint32
+		if have, src, ok = decode64Uint32(src, 1); !ok || have&^3 != 0 {
+			// no upgrades nor ROM
+			return params, "", errFormat
+		}
+		if have&1 != 0 {
+			if params.p, src, ok = decode64Uint32(src, 2); !ok {
+				return params, "", errFormat
+			}
+		}
+		if have&2 != 0 {
+			if params.t, src, ok = decode64Uint32(src, 1); !ok {
+				return params, "", errFormat
+			}
+		}
+	}
+
+	if !strings.HasPrefix(src, "$") {
+		return params, "", errFormat
+	}
+	src = src[1:]
+
+	if uint64(params.r)*uint64(params.p) >= 1<<30 || params.n/uint64(params.p) <= 1 ||
+		128*uint64(params.r)*params.n > yescryptMaxMemory {
+		return params, "", errFormat
+	}
+
+	salt := src
+	if i := strings.IndexByte(src, '$'); i >= 0 {
+		salt = src[:i]
+		if src[i+1:] != "" && (len(src[i+1:]) != yescryptHashLength || !validChars(src[i+1:], itoa64)) {
+			return params, "", errFormat
+		}
+	}
+	if decoded, ok := decode64(salt); !ok || len(decoded) > yescryptMaxSaltBytes {
+		return params, "", errFormat
+	}
+
+	return params, hash[:len(hash)-len(src)+len(salt)], nil
+}
+
+func yescryptHash(password, hash string) (string, error) {
+	params, setting, err := parseYescrypt(hash)
+	if err != nil {
+		return "", err
+	}
+
+	salt, _ := decode64(setting[strings.LastIndexByte(setting, '$')+1:])
+	dk := yescrypt([]byte(password), salt, params)
+
+	return setting + "$" + encode64(dk), nil
+}
+
+func yescrypt(password, salt []byte, params yescryptParams) []byte {
+	n, p := params.n, uint64(params.p)
+
+	if n/p >= 0x100 && n/p*uint64(params.r) >= 0x20000 {
+		prehash := params
+		prehash.flags |= yescryptPrehash
+		prehash.n >>= 6
+		prehash.t = 0
+		password = yescryptBody(password, salt, prehash)
+	}
+
+	return yescryptBody(password, salt, params)
+}
+
+func yescryptBody(password, salt []byte, params yescryptParams) []byte {
+	r, p := params.r, params.p
+	s := 32 * r
+
+	key := []byte("yescrypt-prehash")
+	if params.flags&yescryptPrehash == 0 {
+		key = key[:8]
+	}
+	password = hmacSHA256(key, password)
+
+	data := pbkdf2SHA256(password, salt, 128*int(r)*int(p))
+	password = append([]byte{}, data[:sha256.Size]...)
+
+	b := make([]uint32, len(data)/4)
+	for i := range b {
+		b[i] = binary.LittleEndian.Uint32(data[4*i:])
+	}
+
+	v := make([]uint32, params.n*uint64(s))
+	xy := make([]uint32, 2*s)
+	sbox := make([]uint32, sWords*int(p))
+	smix(b, params, v, xy, sbox, password)
+
+	for i := range b {
+		binary.LittleEndian.PutUint32(data[4*i:], b[i])
+	}
+
+	dk := pbkdf2SHA256(password, data, sha256.Size)
+	if params.flags&yescryptPrehash != 0 {
+		return dk
+	}
+
+	// StoredKey of SCRAM
+	clientKey := hmacSHA256(dk, []byte("Client Key"))
+	storedKey := sha256.Sum256(clientKey)
+	return storedKey[:]
+}
+
+func smix(b []uint32, params yescryptParams, v, xy, sbox []uint32, password []byte) {
+	r, n, p, t := params.r, params.n, uint64(params.p), params.t
+	s := uint64(32 * r)
+
+	chunk := n / p
+	loopAll := chunk
+	if t <= 1 {
+		if t != 0 {
+			loopAll *= 2
+		}
+		loopAll = (loopAll + 2) / 3
+	} else {
+		loopAll *= uint64(t) - 1
+	}
+	loopRW := loopAll / p
+
+	chunk &^= 1
+	loopAll = (loopAll + 1) &^ 1
+	loopRW = (loopRW + 1) &^ 1
+
+	ctx := make([]pwxform, p)
+	for i := uint64(0); i < p; i++ {
+		start := i * chunk
+		np := chunk
+		if i == p-1 {
+			np = n - start
+		}
+		bp := b[i*s : (i+1)*s]
+		vp := v[start*s : (start+np)*s]
+
+		sp := sbox[i*sWords : (i+1)*sWords]
+		smix1(bp, 1, sWords/32, 0, sp, xy, nil)
+		ctx[i] = pwxform{
+			s2: sp[:sWords/3],
+			s1: sp[sWords/3 : 2*sWords/3],
+			s0: sp[2*sWords/3:],
+		}
+
+		if i == 0 {
+			key := make([]byte, 64)
+			for k, word := range bp[s-16:] {
+				binary.LittleEndian.PutUint32(key[4*k:], word)
+			}
+			copy(password, hmacSHA256(key, password))
+		}
+
+		smix1(bp, r, np, params.flags, vp, xy, &ctx[i])
+		smix2(bp, r, p2floor(np), loopRW, params.flags, vp, xy, &ctx[i])
+	}
+
+	for i := uint64(0); i < p; i++ {
+		smix2(b[i*s:(i+1)*s], r, n, loopAll-loopRW, params.flags&^yescryptRW, v, xy, &ctx[i])
+	}
+}
+
+func p2floor(x uint64) uint64 {
+	for x&(x-1) != 0 {
+		x &= x - 1
+	}
+	return x
+}
+
+// shuffle puts the words of each block of b in the order the SIMD
+// implementations keep them, unshuffle undoes it. Only the blocks of x are
+// taken from b.
+func shuffle(x, b []uint32) {
+	for k := 0; k < len(x); k += 16 {
+		for i := 0; i < 16; i++ {
+			x[k+i] = b[k+i*5%16]
+		}
+	}
+}
+
+func unshuffle(b, x []uint32) {
+	for k := 0; k < len(x); k += 16 {
+		for i := 0; i < 16; i++ {
+			b[k+i*5%16] = x[k+i]
+		}
+	}
+}
+
+func integerify(x []uint32, r uint32) uint64 {
+	last := x[(2*r-1)*16:]
+	return uint64(last[13])<<32 | uint64(last[0])
+}
+
+func smix1(b []uint32, r uint32, n uint64, flags uint32, v, xy []uint32, ctx *pwxform) {
+	s := uint64(32 * r)
+	x, y := xy[:s], xy[s:2*s]
+
+	shuffle(x, b)
+	for i := uint64(0); i < n; i++ {
+		copy(v[i*s:], x)
+
+		if flags&yescryptRW != 0 && i > 1 {
+			// j <-- Wrap(Integerify(X), i)
+			m := p2floor(i)
+			j := uint64(uint32(integerify(x, r)))&(m-1) + (i - m)
+			xor(x, v[j*s:])
+		}
+
+		if ctx != nil {
+			ctx.blockmix(x, r)
+		} else {
+			blockmixSalsa8(x, y, r)
+		}
+	}
+	unshuffle(b, x)
+}
+
+func smix2(b []uint32, r uint32, n, loops uint64, flags uint32, v, xy []uint32, ctx *pwxform) {
+	if loops == 0 {
+		return
+	}
+
+	s := uint64(32 * r)
+	x, y := xy[:s], xy[s:2*s]
+
+	shuffle(x, b)
+	for i := uint64(0); i < loops; i++ {
+		j := integerify(x, r) & (n - 1)
+		xor(x, v[j*s:])
+		if flags&yescryptRW != 0 {
+			copy(v[j*s:(j+1)*s], x)
+		}
+
+		if ctx != nil {
+			ctx.blockmix(x, r)
+		} else {
+			blockmixSalsa8(x, y, r)
+		}
+	}
+	unshuffle(b, x)
+}
+
+func xor(dst, src []uint32) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
+
+func blockmixSalsa8(b, y []uint32, r uint32) {
+	var x [16]uint32
+	copy(x[:], b[(2*r-1)*16:])
+
+	for i := uint32(0); i < 2*r; i++ {
+		xor(x[:], b[i*16:])
+		salsa20(x[:], 8)
+		copy(y[i*16:], x[:])
+	}
+
+	for i := uint32(0); i < r; i++ {
+		copy(b[i*16:], y[2*i*16:(2*i+1)*16])
+		copy(b[(i+r)*16:], y[(2*i+1)*16:(2*i+2)*16])
+	}
+}
+
+func (ctx *pwxform) blockmix(b []uint32, r uint32) {
+	var x [pwxWords]uint32
+	blocks := 32 * r / pwxWords
+
+	copy(x[:], b[(blocks-1)*pwxWords:])
+	for i := uint32(0); i < blocks; i++ {
+		if blocks > 1 {
+			xor(x[:], b[i*pwxWords:])
+		}
+		ctx.transform(x[:])
+		copy(b[i*pwxWords:], x[:])
+	}
+
+	salsa20(b[(2*r-1)*16:2*r*16], 2)
+}
+
+func (ctx *pwxform) transform(b []uint32) {
+	s0, s1, s2 := ctx.s0, ctx.s1, ctx.s2
+	w := ctx.w
+
+	for i := 0; i < pwxRounds; i++ {
+		for j := 0; j < pwxGather; j++ {
+			block := b[j*pwxSimple*2:]
+			p0 := s0[(block[0]&sMask)/4:]
+			p1 := s1[(block[1]&sMask)/4:]
+
+			for k := 0; k < pwxSimple; k++ {
+				x := uint64(block[2*k+1]) * uint64(block[2*k])
+				x += uint64(p0[2*k+1])<<32 | uint64(p0[2*k])
+				x ^= uint64(p1[2*k+1])<<32 | uint64(p1[2*k])
+
+				block[2*k], block[2*k+1] = uint32(x), uint32(x>>32)
+
+				if i != 0 && i != pwxRounds-1 {
+					s2[2*w], s2[2*w+1] = uint32(x), uint32(x>>32)
+					w++
+				}
+			}
+		}
+	}
+
+	ctx.s0, ctx.s1, ctx.s2 = s2, s0, s1
+	ctx.w = w & ((1 << sWidth) * pwxSimple - 1)
+}
+
+// salsa20 is the Salsa20 core on a shuffled block
+func salsa20(b []uint32, rounds int) {
+	var x [16]uint32
+	for i := 0; i < 16; i++ {
+		x[i*5%16] = b[i]
+	}
+
+	rotl := func(a uint32, n uint) uint32 { return a<<n | a>>(32-n) }
+
+	for i := 0; i < rounds; i += 2 {
+		x[4] ^= rotl(x[0]+x[12], 7)
+		x[8] ^= rotl(x[4]+x[0], 9)
+		x[12] ^= rotl(x[8]+x[4], 13)
+		x[0] ^= rotl(x[12]+x[8], 18)
+		x[9] ^= rotl(x[5]+x[1], 7)
+		x[13] ^= rotl(x[9]+x[5], 9)
+		x[1] ^= rotl(x[13]+x[9], 13)
+		x[5] ^= rotl(x[1]+x[13], 18)
+		x[14] ^= rotl(x[10]+x[6], 7)
+		x[2] ^= rotl(x[14]+x[10], 9)
+		x[6] ^= rotl(x[2]+x[14], 13)
+		x[10] ^= rotl(x[6]+x[2], 18)
+		x[3] ^= rotl(x[15]+x[11], 7)
+		x[7] ^= rotl(x[3]+x[15], 9)
+		x[11] ^= rotl(x[7]+x[3], 13)
+		x[15] ^= rotl(x[11]+x[7], 18)
+
+		x[1] ^= rotl(x[0]+x[3], 7)
+		x[2] ^= rotl(x[1]+x[0], 9)
+		x[3] ^= rotl(x[2]+x[1], 13)
+		x[0] ^= rotl(x[3]+x[2], 18)
+		x[6] ^= rotl(x[5]+x[4], 7)
+		x[7] ^= rotl(x[6]+x[5], 9)
+		x[4] ^= rotl(x[7]+x[6], 13)
+		x[5] ^= rotl(x[4]+x[7], 18)
+		x[11] ^= rotl(x[10]+x[9], 7)
+		x[8] ^= rotl(x[11]+x[10], 9)
+		x[9] ^= rotl(x[8]+x[11], 13)
+		x[10] ^= rotl(x[9]+x[8], 18)
+		x[12] ^= rotl(x[15]+x[14], 7)
+		x[13] ^= rotl(x[12]+x[15], 9)
+		x[14] ^= rotl(x[13]+x[12], 13)
+		x[15] ^= rotl(x[14]+x[13], 18)
+	}
+
+	for i := 0; i < 16; i++ {
+		b[i] += x[i*5%16]
+	}
+}
+
+func hmacSHA256(key, message []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(message)
+	return mac.Sum(nil)
+}
+
+// pbkdf2SHA256 is PBKDF2 with HMAC-SHA-256 and one iteration
+func pbkdf2SHA256(password, salt []byte, length int) []byte {
+	mac := hmac.New(sha256.New, password)
+	dk := make([]byte, 0, length+sha256.Size)
+
+	var counter [4]byte
+	for block := uint32(1); len(dk) < length; block++ {
+		binary.BigEndian.PutUint32(counter[:], block)
+		mac.Reset()
+		mac.Write(salt)
+		mac.Write(counter[:])
+		dk = mac.Sum(dk)
+	}
+
+	return dk[:length]
+}